@@ -30,6 +30,9 @@ func NewDefaultBackoffManager(pollInterval, retryInterval time.Duration) Backoff
 
 			// PostgreSQL deadlock indicator
 			"concurrent update",
+
+			// SQLite busy indicator
+			"database is locked",
 		},
 	}
 }
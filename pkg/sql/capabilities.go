@@ -0,0 +1,91 @@
+package sql
+
+import (
+	"fmt"
+)
+
+const (
+	DialectPostgreSQL = "postgresql"
+	DialectMySQL      = "mysql"
+	DialectSQLite     = "sqlite"
+)
+
+// Capabilities describe what a SchemaAdapter or an OffsetsAdapter works with.
+// They are used by NewSubscriber to reject adapters which can't work together,
+// before any message is consumed.
+type Capabilities struct {
+	// Dialect is the SQL dialect of the adapter's queries, for example DialectPostgreSQL.
+	Dialect string
+
+	// AcksPerMessage is true for adapters which ack each message separately (like PostgreSQLQueueSchema),
+	// instead of storing the offset of the last acked message of the consumer group.
+	AcksPerMessage bool
+
+	// ConsumerGroups is true if the adapter supports subscribing with a consumer group.
+	ConsumerGroups bool
+
+	// ConsumerGroupRequired is true if the adapter doesn't support subscribing without a consumer group.
+	ConsumerGroupRequired bool
+}
+
+// CapabilitiesProvider may be implemented by SchemaAdapter and OffsetsAdapter.
+// Adapters which don't implement it are not checked.
+type CapabilitiesProvider interface {
+	Capabilities() Capabilities
+}
+
+func checkAdaptersCompatibility(schemaAdapter SchemaAdapter, offsetsAdapter OffsetsAdapter, consumerGroup string) error {
+	schemaCapabilities, schemaOk := schemaAdapter.(CapabilitiesProvider)
+	offsetsCapabilities, offsetsOk := offsetsAdapter.(CapabilitiesProvider)
+
+	if schemaOk {
+		if err := checkConsumerGroupSupport(schemaAdapter, schemaCapabilities.Capabilities(), consumerGroup); err != nil {
+			return err
+		}
+	}
+	if offsetsOk {
+		if err := checkConsumerGroupSupport(offsetsAdapter, offsetsCapabilities.Capabilities(), consumerGroup); err != nil {
+			return err
+		}
+	}
+
+	if !schemaOk || !offsetsOk {
+		return nil
+	}
+
+	schema := schemaCapabilities.Capabilities()
+	offsets := offsetsCapabilities.Capabilities()
+
+	if schema.Dialect != offsets.Dialect {
+		return fmt.Errorf(
+			"schema adapter %T uses %s dialect, but offsets adapter %T uses %s dialect",
+			schemaAdapter, schema.Dialect, offsetsAdapter, offsets.Dialect,
+		)
+	}
+
+	if schema.AcksPerMessage && !offsets.AcksPerMessage {
+		return fmt.Errorf(
+			"schema adapter %T acks each message separately, but offsets adapter %T stores consumer group offsets",
+			schemaAdapter, offsetsAdapter,
+		)
+	}
+	if !schema.AcksPerMessage && offsets.AcksPerMessage {
+		return fmt.Errorf(
+			"offsets adapter %T acks each message separately, but schema adapter %T relies on consumer group offsets",
+			offsetsAdapter, schemaAdapter,
+		)
+	}
+
+	return nil
+}
+
+func checkConsumerGroupSupport(adapter any, capabilities Capabilities, consumerGroup string) error {
+	if consumerGroup != "" && !capabilities.ConsumerGroups {
+		return fmt.Errorf("%T doesn't support consumer groups", adapter)
+	}
+	if consumerGroup == "" && capabilities.ConsumerGroupRequired {
+		return fmt.Errorf("%T requires a consumer group", adapter)
+	}
+
+	return nil
+}
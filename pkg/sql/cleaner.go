@@ -0,0 +1,221 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+type CleanupQueryParams struct {
+	Topic          string
+	OffsetsAdapter OffsetsAdapter
+
+	// MaxAge is the age of messages to delete. Zero means that messages are deleted regardless of their age.
+	MaxAge time.Duration
+
+	// OnlyAcked limits deleted messages to messages acked by all consumer groups.
+	OnlyAcked bool
+
+	// BatchSize is the maximum number of messages deleted by the query.
+	BatchSize int
+}
+
+// CleanupQuerier may be implemented by SchemaAdapter to support deleting old messages with Cleaner.
+type CleanupQuerier interface {
+	// CleanupQuery returns the SQL query and arguments deleting up to BatchSize messages matching the params.
+	CleanupQuery(params CleanupQueryParams) (Query, error)
+}
+
+// offsetsTableProvider is implemented by the default offsets adapters.
+type offsetsTableProvider interface {
+	MessagesOffsetsTable(topic string) string
+}
+
+func cleanupOffsetsTable(params CleanupQueryParams) (string, error) {
+	provider, ok := params.OffsetsAdapter.(offsetsTableProvider)
+	if !ok {
+		return "", fmt.Errorf("offsets adapter %T doesn't provide the offsets table", params.OffsetsAdapter)
+	}
+
+	return provider.MessagesOffsetsTable(params.Topic), nil
+}
+
+// CleanerConfig is a configuration for Cleaner.
+type CleanerConfig struct {
+	// DB is a database connection. Required.
+	DB Beginner
+
+	// SchemaAdapter must implement CleanupQuerier, like DefaultPostgreSQLSchema or DefaultMySQLSchema. Required.
+	SchemaAdapter SchemaAdapter
+
+	// OffsetsAdapter is the offsets adapter used by subscribers of the topics. Required if OnlyAcked is true.
+	OffsetsAdapter OffsetsAdapter
+
+	// Topics are the topics to clean up. Required.
+	Topics []string
+
+	// MaxAge enables deleting messages older than MaxAge.
+	MaxAge time.Duration
+
+	// OnlyAcked enables deleting messages acked by all consumer groups of the topic.
+	// If there are no consumer groups, no messages are deleted.
+	//
+	// When both MaxAge and OnlyAcked are set, only acked messages older than MaxAge are deleted.
+	// At least one of them is required.
+	OnlyAcked bool
+
+	// BatchSize is the maximum number of messages deleted by a single query.
+	// Deleting in batches avoids holding locks for a long time. Defaults to 1000.
+	BatchSize int
+
+	// Interval is the time between cleanups. Defaults to 1 minute.
+	Interval time.Duration
+
+	Logger watermill.LoggerAdapter
+}
+
+func (c *CleanerConfig) setDefaults() {
+	if c.BatchSize == 0 {
+		c.BatchSize = 1000
+	}
+
+	if c.Interval == 0 {
+		c.Interval = time.Minute
+	}
+
+	if c.Logger == nil {
+		c.Logger = watermill.NopLogger{}
+	}
+}
+
+func (c CleanerConfig) validate() error {
+	if c.DB == nil {
+		return errors.New("missing db")
+	}
+	if c.SchemaAdapter == nil {
+		return errors.New("missing schema adapter")
+	}
+	if _, ok := c.SchemaAdapter.(CleanupQuerier); !ok {
+		return fmt.Errorf("schema adapter %T doesn't support cleanup", c.SchemaAdapter)
+	}
+	if c.OnlyAcked && c.OffsetsAdapter == nil {
+		return errors.New("missing offsets adapter")
+	}
+	if len(c.Topics) == 0 {
+		return errors.New("missing topics")
+	}
+	for _, topic := range c.Topics {
+		if err := validateTopic(topic, c.SchemaAdapter, c.OffsetsAdapter); err != nil {
+			return err
+		}
+	}
+	if c.MaxAge < 0 {
+		return errors.New("max age must be non-negative")
+	}
+	if c.MaxAge == 0 && !c.OnlyAcked {
+		return errors.New("max age or only acked must be set")
+	}
+	if c.BatchSize < 0 {
+		return errors.New("batch size must be non-negative")
+	}
+	if c.Interval < 0 {
+		return errors.New("interval must be non-negative")
+	}
+
+	return nil
+}
+
+// Cleaner periodically deletes old messages from the messages tables.
+type Cleaner struct {
+	config CleanerConfig
+	logger watermill.LoggerAdapter
+}
+
+func NewCleaner(config CleanerConfig) (*Cleaner, error) {
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Cleaner{
+		config: config,
+		logger: config.Logger,
+	}, nil
+}
+
+// Run cleans up the topics every Interval, until ctx is canceled.
+// Errors are logged, and the cleanup is retried after Interval.
+func (c *Cleaner) Run(ctx context.Context) error {
+	for {
+		if _, err := c.Cleanup(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Error("Could not clean up messages", err, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.config.Interval):
+		}
+	}
+}
+
+// Cleanup deletes messages from all topics once, in batches of BatchSize.
+// It returns the number of deleted messages.
+func (c *Cleaner) Cleanup(ctx context.Context) (int64, error) {
+	var deleted int64
+
+	for _, topic := range c.config.Topics {
+		topicDeleted, err := c.cleanupTopic(ctx, topic)
+		deleted += topicDeleted
+		if err != nil {
+			return deleted, fmt.Errorf("could not clean up topic %s: %w", topic, err)
+		}
+	}
+
+	return deleted, nil
+}
+
+func (c *Cleaner) cleanupTopic(ctx context.Context, topic string) (int64, error) {
+	query, err := c.config.SchemaAdapter.(CleanupQuerier).CleanupQuery(CleanupQueryParams{
+		Topic:          topic,
+		OffsetsAdapter: c.config.OffsetsAdapter,
+		MaxAge:         c.config.MaxAge,
+		OnlyAcked:      c.config.OnlyAcked,
+		BatchSize:      c.config.BatchSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not generate cleanup query: %w", err)
+	}
+
+	var deleted int64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		result, err := c.config.DB.ExecContext(ctx, query.Query, query.Args...)
+		if err != nil {
+			return deleted, fmt.Errorf("could not delete messages: %w", err)
+		}
+
+		batchDeleted, err := result.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("could not get number of deleted messages: %w", err)
+		}
+
+		deleted += batchDeleted
+
+		c.logger.Debug("Deleted messages", watermill.LogFields{
+			"topic":         topic,
+			"deleted_count": batchDeleted,
+		})
+
+		if batchDeleted < int64(c.config.BatchSize) {
+			return deleted, nil
+		}
+	}
+}
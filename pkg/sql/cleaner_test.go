@@ -0,0 +1,175 @@
+package sql_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func TestCleaner_SQLite_only_acked(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "cleaner_" + watermill.NewShortUUID()
+
+	schemaAdapter := newSQLiteSchemaAdapter(1)
+	offsetsAdapter := newSQLiteOffsetsAdapter()
+
+	publisher, subscriber := newPubSub(t, db, "cleaner", schemaAdapter, offsetsAdapter)
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	publishCleanerTestMessages(t, publisher, topic, 10)
+
+	cleaner, err := sql.NewCleaner(sql.CleanerConfig{
+		DB:             db,
+		SchemaAdapter:  schemaAdapter,
+		OffsetsAdapter: offsetsAdapter,
+		Topics:         []string{topic},
+		OnlyAcked:      true,
+		BatchSize:      2,
+		Logger:         logger,
+	})
+	require.NoError(t, err)
+
+	deleted, err := cleaner.Cleanup(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, deleted, "no consumer group acked any message")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, err := subscriber.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		msg := <-messages
+		require.True(t, msg.Ack())
+	}
+
+	// with subscribe batch size of 1, the next message is sent after the previous ack is committed
+	<-messages
+	cancel()
+	require.NoError(t, subscriber.Close())
+
+	deleted, err = cleaner.Cleanup(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, deleted)
+	assert.Equal(t, 5, countCleanerTestMessages(t, db, schemaAdapter, topic))
+}
+
+func TestCleaner_SQLite_max_age(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "cleaner_" + watermill.NewShortUUID()
+
+	schemaAdapter := newSQLiteSchemaAdapter(1)
+
+	publisher, subscriber := newPubSub(t, db, "", schemaAdapter, newSQLiteOffsetsAdapter())
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	publishCleanerTestMessages(t, publisher, topic, 7)
+
+	_, err := db.ExecContext(
+		context.Background(),
+		fmt.Sprintf(`UPDATE %s SET "created_at" = datetime('now', '-2 hours') WHERE "offset" <= 5`, schemaAdapter.MessagesTable(topic)),
+	)
+	require.NoError(t, err)
+
+	cleaner, err := sql.NewCleaner(sql.CleanerConfig{
+		DB:            db,
+		SchemaAdapter: schemaAdapter,
+		Topics:        []string{topic},
+		MaxAge:        time.Hour,
+		BatchSize:     2,
+		Logger:        logger,
+	})
+	require.NoError(t, err)
+
+	deleted, err := cleaner.Cleanup(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, deleted)
+	assert.Equal(t, 2, countCleanerTestMessages(t, db, schemaAdapter, topic))
+}
+
+func TestNewCleaner_invalid_config(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name   string
+		Config sql.CleanerConfig
+	}{
+		{
+			Name: "missing_criteria",
+			Config: sql.CleanerConfig{
+				DB:            newSQLite(t),
+				SchemaAdapter: newSQLiteSchemaAdapter(0),
+				Topics:        []string{"topic"},
+			},
+		},
+		{
+			Name: "only_acked_without_offsets_adapter",
+			Config: sql.CleanerConfig{
+				DB:            newSQLite(t),
+				SchemaAdapter: newSQLiteSchemaAdapter(0),
+				Topics:        []string{"topic"},
+				OnlyAcked:     true,
+			},
+		},
+		{
+			Name: "unsupported_schema_adapter",
+			Config: sql.CleanerConfig{
+				DB:            newSQLite(t),
+				SchemaAdapter: sql.PostgreSQLQueueSchema{},
+				Topics:        []string{"topic"},
+				MaxAge:        time.Hour,
+			},
+		},
+		{
+			Name: "missing_topics",
+			Config: sql.CleanerConfig{
+				DB:            newSQLite(t),
+				SchemaAdapter: newSQLiteSchemaAdapter(0),
+				MaxAge:        time.Hour,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := sql.NewCleaner(tc.Config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func publishCleanerTestMessages(t *testing.T, publisher message.Publisher, topic string, count int) {
+	t.Helper()
+
+	var messages []*message.Message
+	for i := 0; i < count; i++ {
+		messages = append(messages, message.NewMessage(watermill.NewUUID(), []byte(fmt.Sprintf("%d", i))))
+	}
+
+	require.NoError(t, publisher.Publish(topic, messages...))
+}
+
+func countCleanerTestMessages(t *testing.T, db sql.Beginner, schemaAdapter *sql.DefaultSQLiteSchema, topic string) int {
+	t.Helper()
+
+	rows, err := db.QueryContext(context.Background(), "SELECT COUNT(*) FROM "+schemaAdapter.MessagesTable(topic))
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var count int
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&count))
+
+	return count
+}
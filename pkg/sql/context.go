@@ -7,7 +7,8 @@ import (
 type contextKey string
 
 const (
-	txContextKey contextKey = "tx"
+	txContextKey    contextKey = "tx"
+	topicContextKey contextKey = "topic"
 )
 
 func setTxToContext(ctx context.Context, tx Tx) context.Context {
@@ -17,6 +18,7 @@ func setTxToContext(ctx context.Context, tx Tx) context.Context {
 // TxFromContext returns the transaction used by the subscriber to consume the message.
 // The transaction will be committed if ack of the message is successful.
 // When a nack is sent, the transaction will be rolled back.
+// Leased messages are delivered without a transaction, unless SubscriberConfig.LeasedMessageTx is enabled.
 //
 // It is useful when you want to ensure that data is updated only when the message is processed.
 // Example usage: https://github.com/ThreeDotsLabs/watermill/tree/master/_examples/real-world-examples/exactly-once-delivery-counter
@@ -24,3 +26,13 @@ func TxFromContext(ctx context.Context) (Tx, bool) {
 	tx, ok := ctx.Value(txContextKey).(Tx)
 	return tx, ok
 }
+
+func setTopicToContext(ctx context.Context, topic string) context.Context {
+	return context.WithValue(ctx, topicContextKey, topic)
+}
+
+// TopicFromContext returns the topic of the message received from Subscriber.SubscribePattern.
+func TopicFromContext(ctx context.Context) (string, bool) {
+	topic, ok := ctx.Value(topicContextKey).(string)
+	return topic, ok
+}
@@ -0,0 +1,187 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// PgxCopyFromInserter may be implemented by SchemaAdapter to insert messages with the COPY protocol,
+// which is much faster than INSERT for a large number of messages.
+//
+// It's used by Publisher with pgx database handles, see PublisherConfig.CopyFromMinMessages.
+type PgxCopyFromInserter interface {
+	// CopyFrom inserts the messages in the transaction, preserving their order.
+	CopyFrom(ctx context.Context, tx pgx.Tx, params InsertQueryParams) error
+}
+
+// copyFrom inserts the messages with COPY, if it's enabled and supported by the database handle.
+// It returns false if the messages should be inserted with the insert query instead.
+func (p *Publisher) copyFrom(ctx context.Context, db ContextExecutor, topic string, messages message.Messages) (bool, error) {
+	if p.config.CopyFromMinMessages == 0 || len(messages) < p.config.CopyFromMinMessages {
+		return false, nil
+	}
+
+	inserter, ok := p.config.SchemaAdapter.(PgxCopyFromInserter)
+	if !ok {
+		return false, nil
+	}
+
+	params := InsertQueryParams{
+		Topic: topic,
+		Msgs:  messages,
+	}
+
+	var err error
+
+	switch db := db.(type) {
+	case PgxTx:
+		err = inserter.CopyFrom(ctx, db.Tx, params)
+	case *PgxTx:
+		err = inserter.CopyFrom(ctx, db.Tx, params)
+	case PgxBeginner:
+		err = runInTx(ctx, db, func(ctx context.Context, tx Tx) error {
+			return inserter.CopyFrom(ctx, tx.(*PgxTx).Tx, params)
+		})
+	default:
+		return false, nil
+	}
+
+	if err != nil {
+		return true, fmt.Errorf("could not copy messages: %w", err)
+	}
+
+	return true, nil
+}
+
+// copyFromColumns returns names of the columns written by the marshaler, followed by extraColumns.
+func copyFromColumns(marshaler Marshaler, topic string, extraColumns ...string) []string {
+	columns := []string{"uuid", "payload", "metadata"}
+	for _, column := range marshaler.ExtraColumns(topic) {
+		columns = append(columns, column.Name)
+	}
+
+	return append(columns, extraColumns...)
+}
+
+// copyFromRows returns values of the columns written by the marshaler for each message, followed by extraValues.
+func copyFromRows(marshaler Marshaler, topic string, msgs message.Messages, extraValues ...any) ([][]any, error) {
+	args, err := marshalMessages(marshaler, topic, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := 3 + len(marshaler.ExtraColumns(topic))
+
+	rows := make([][]any, len(msgs))
+	for i := range msgs {
+		row := make([]any, 0, columns+len(extraValues))
+		row = append(row, args[i*columns:(i+1)*columns]...)
+		row = append(row, extraValues...)
+
+		rows[i] = row
+	}
+
+	return rows, nil
+}
+
+// parsePostgreSQLIdentifier splits a possibly quoted and schema-qualified table name, like `"schema"."table"`,
+// into parts accepted by pgx.
+func parsePostgreSQLIdentifier(name string) (pgx.Identifier, error) {
+	var identifier pgx.Identifier
+
+	part := strings.Builder{}
+	quoted := false
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+
+		switch {
+		case c == '"' && quoted && i+1 < len(name) && name[i+1] == '"':
+			part.WriteByte('"')
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			identifier = append(identifier, part.String())
+			part.Reset()
+		case !quoted:
+			// unquoted identifiers are folded to lower case by PostgreSQL
+			part.WriteString(strings.ToLower(string(c)))
+		default:
+			part.WriteByte(c)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quoted identifier: %s", name)
+	}
+
+	identifier = append(identifier, part.String())
+
+	for _, part := range identifier {
+		if part == "" {
+			return nil, fmt.Errorf("invalid identifier: %s", name)
+		}
+	}
+
+	return identifier, nil
+}
+
+func (s DefaultPostgreSQLSchema) CopyFrom(ctx context.Context, tx pgx.Tx, params InsertQueryParams) error {
+	table, err := parsePostgreSQLIdentifier(s.MessagesTable(params.Topic))
+	if err != nil {
+		return err
+	}
+
+	// all rows are inserted by the same transaction, as with InsertQuery
+	var transactionID uint64
+	if err := tx.QueryRow(ctx, "SELECT pg_current_xact_id()").Scan(&transactionID); err != nil {
+		return fmt.Errorf("could not get transaction id: %w", err)
+	}
+
+	rows, err := copyFromRows(s.marshaler(), params.Topic, params.Msgs, transactionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		table,
+		copyFromColumns(s.marshaler(), params.Topic, "transaction_id"),
+		pgx.CopyFromRows(rows),
+	)
+
+	return err
+}
+
+// CopyFrom is not supported with ConsumerGroups, because ack rows of consumer groups are inserted with the messages.
+func (s PostgreSQLQueueSchema) CopyFrom(ctx context.Context, tx pgx.Tx, params InsertQueryParams) error {
+	if s.ConsumerGroups {
+		return errors.New("COPY is not supported with ConsumerGroups in PostgreSQLQueueSchema")
+	}
+
+	table, err := parsePostgreSQLIdentifier(s.MessagesTable(params.Topic))
+	if err != nil {
+		return err
+	}
+
+	rows, err := copyFromRows(s.marshaler(), params.Topic, params.Msgs)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		table,
+		copyFromColumns(s.marshaler(), params.Topic),
+		pgx.CopyFromRows(rows),
+	)
+
+	return err
+}
@@ -0,0 +1,63 @@
+package sql
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePostgreSQLIdentifier(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		ExpectedIdentifier pgx.Identifier
+		ExpectedError      bool
+	}{
+		{
+			Name:               `"watermill_topic"`,
+			ExpectedIdentifier: pgx.Identifier{"watermill_topic"},
+		},
+		{
+			Name:               `"Watermill-Topic"`,
+			ExpectedIdentifier: pgx.Identifier{"Watermill-Topic"},
+		},
+		{
+			Name:               `Watermill_Topic`,
+			ExpectedIdentifier: pgx.Identifier{"watermill_topic"},
+		},
+		{
+			Name:               `"schema"."watermill_topic"`,
+			ExpectedIdentifier: pgx.Identifier{"schema", "watermill_topic"},
+		},
+		{
+			Name:               `schema."watermill.topic"`,
+			ExpectedIdentifier: pgx.Identifier{"schema", "watermill.topic"},
+		},
+		{
+			Name:               `"watermill""topic"`,
+			ExpectedIdentifier: pgx.Identifier{`watermill"topic`},
+		},
+		{
+			Name:          `"watermill_topic`,
+			ExpectedError: true,
+		},
+		{
+			Name:          `schema.`,
+			ExpectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			identifier, err := parsePostgreSQLIdentifier(tc.Name)
+			if tc.ExpectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpectedIdentifier, identifier)
+		})
+	}
+}
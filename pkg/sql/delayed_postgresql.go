@@ -2,7 +2,8 @@ package sql
 
 import (
 	"fmt"
-	"strings"
+
+	"github.com/jackc/pgx/v5"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/components/delay"
@@ -16,6 +17,10 @@ type DelayedPostgreSQLPublisherConfig struct {
 	// OverridePublisherConfig allows overriding the default PublisherConfig.
 	OverridePublisherConfig func(config *PublisherConfig) error
 
+	// Namespace sets the PostgreSQL schema and the prefix of the tables.
+	// It should be the same as Namespace of the delayed subscriber.
+	Namespace PostgreSQLNamespace
+
 	Logger watermill.LoggerAdapter
 }
 
@@ -31,7 +36,7 @@ func NewDelayedPostgreSQLPublisher(db ContextExecutor, config DelayedPostgreSQLP
 	config.setDefaults()
 
 	publisherConfig := PublisherConfig{
-		SchemaAdapter:        PostgreSQLQueueSchema{},
+		SchemaAdapter:        PostgreSQLQueueSchema{Namespace: config.Namespace},
 		AutoInitializeSchema: true,
 	}
 
@@ -70,6 +75,10 @@ type DelayedPostgreSQLSubscriberConfig struct {
 	// If set to true, messages without delay metadata will be received immediately.
 	AllowNoDelay bool
 
+	// Namespace sets the PostgreSQL schema and the prefix of the tables.
+	// It should be the same as Namespace of the delayed publisher.
+	Namespace PostgreSQLNamespace
+
 	Logger watermill.LoggerAdapter
 }
 
@@ -95,6 +104,7 @@ func NewDelayedPostgreSQLSubscriber(db Beginner, config DelayedPostgreSQLSubscri
 			GenerateWhereClause: func(params GenerateWhereClauseParams) (string, []any) {
 				return where, nil
 			},
+			Namespace: config.Namespace,
 		},
 	}
 
@@ -102,6 +112,7 @@ func NewDelayedPostgreSQLSubscriber(db Beginner, config DelayedPostgreSQLSubscri
 		SchemaAdapter: schemaAdapter,
 		OffsetsAdapter: PostgreSQLQueueOffsetsAdapter{
 			DeleteOnAck: config.DeleteOnAck,
+			Namespace:   config.Namespace,
 		},
 		InitializeSchema: true,
 	}
@@ -132,7 +143,13 @@ func (a delayedPostgreSQLSchemaAdapter) SchemaInitializingQueries(params SchemaI
 	}
 
 	table := a.MessagesTable(params.Topic)
-	index := fmt.Sprintf(`"%s_delayed_until_idx"`, strings.ReplaceAll(table, `"`, ""))
+
+	// the index is created in the schema of the table, so its name can't be qualified
+	identifier, err := parsePostgreSQLIdentifier(table)
+	if err != nil {
+		return nil, err
+	}
+	index := pgx.Identifier{identifier[len(identifier)-1] + "_delayed_until_idx"}.Sanitize()
 
 	queries = append(queries, Query{
 		Query: fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s ((metadata->>'%s'))`, index, table, delay.DelayedUntilKey),
@@ -47,6 +47,9 @@ type DelayedRequeuerConfig struct {
 	// DelayOnError middleware. Optional
 	DelayOnError *middleware.DelayOnError
 
+	// Namespace sets the PostgreSQL schema and the prefix of the tables of the delayed publisher and subscriber.
+	Namespace PostgreSQLNamespace
+
 	Logger watermill.LoggerAdapter
 }
 
@@ -99,7 +102,8 @@ func NewPostgreSQLDelayedRequeuer(config DelayedRequeuerConfig) (*DelayedRequeue
 	}
 
 	publisher, err := NewDelayedPostgreSQLPublisher(config.DB, DelayedPostgreSQLPublisherConfig{
-		Logger: config.Logger,
+		Namespace: config.Namespace,
+		Logger:    config.Logger,
 	})
 	if err != nil {
 		return nil, err
@@ -107,6 +111,7 @@ func NewPostgreSQLDelayedRequeuer(config DelayedRequeuerConfig) (*DelayedRequeue
 
 	subscriber, err := NewDelayedPostgreSQLSubscriber(config.DB, DelayedPostgreSQLSubscriberConfig{
 		DeleteOnAck: true,
+		Namespace:   config.Namespace,
 		Logger:      config.Logger,
 	})
 	if err != nil {
@@ -0,0 +1,94 @@
+package sql
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// PostgreSQLFilter selects messages delivered to a consumer group of DefaultPostgreSQLSchema.
+//
+// Messages which don't match the filter are not delivered, but they are acked together with the delivered messages,
+// so the consumer group doesn't stop on them. All selected messages are counted in SubscribeBatchSize,
+// whether they match the filter or not.
+//
+// When both MetadataEquals and Where are set, messages must match both of them.
+type PostgreSQLFilter struct {
+	// MetadataEquals matches messages which have all the metadata keys equal to the values.
+	// With the JSONB metadata column, it can use PostgreSQLMetadataGINIndex.
+	MetadataEquals map[string]string
+
+	// Where is an SQL condition, which can use the uuid, payload, and metadata columns,
+	// and the columns of the schema's Marshaler, for example "payload->>'type' = $1".
+	// Placeholders of Args are numbered from $1.
+	Where string
+	Args  []any
+}
+
+// condition returns the SQL condition of the filter, with placeholders numbered after the previous arguments.
+func (f PostgreSQLFilter) condition(previousArgs int) (string, []any) {
+	var conditions []string
+	var args []any
+
+	if len(f.MetadataEquals) > 0 {
+		// map[string]string can always be marshaled
+		metadata, _ := json.Marshal(f.MetadataEquals)
+
+		args = append(args, string(metadata))
+		conditions = append(conditions, "metadata::jsonb @> $"+strconv.Itoa(previousArgs+len(args))+"::jsonb")
+	}
+
+	if f.Where != "" {
+		conditions = append(conditions, "("+shiftPostgreSQLPlaceholders(f.Where, previousArgs+len(args))+")")
+		args = append(args, f.Args...)
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE", nil
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// shiftPostgreSQLPlaceholders increases numbers of placeholders like $1 in the query by offset.
+// Placeholders in string literals and quoted identifiers are not changed.
+func shiftPostgreSQLPlaceholders(query string, offset int) string {
+	if offset == 0 {
+		return query
+	}
+
+	result := strings.Builder{}
+	var quote byte
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			j := i + 1
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+
+			n, _ := strconv.Atoi(query[i+1 : j])
+			result.WriteString("$" + strconv.Itoa(n+offset))
+
+			i = j - 1
+			continue
+		}
+
+		result.WriteByte(c)
+	}
+
+	return result.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
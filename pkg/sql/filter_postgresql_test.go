@@ -0,0 +1,107 @@
+package sql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func TestDefaultPostgreSQLSchema_filter_query(t *testing.T) {
+	t.Parallel()
+
+	schemaAdapter := sql.DefaultPostgreSQLSchema{
+		GenerateFilter: func(params sql.GenerateWhereClauseParams) sql.PostgreSQLFilter {
+			return sql.PostgreSQLFilter{
+				MetadataEquals: map[string]string{"tenant": params.ConsumerGroup},
+				Where:          "payload->>'type' = $1",
+				Args:           []any{"order_placed"},
+			}
+		},
+	}
+
+	query, err := schemaAdapter.SelectQuery(sql.SelectQueryParams{
+		Topic:          "topic",
+		ConsumerGroup:  "acme",
+		OffsetsAdapter: sql.DefaultPostgreSQLOffsetsAdapter{},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(
+		t,
+		query.Query,
+		`COALESCE((metadata::jsonb @> $2::jsonb AND (payload->>'type' = $3)), FALSE)`,
+	)
+	assert.Equal(t, []any{"acme", `{"tenant":"acme"}`, "order_placed"}, query.Args)
+}
+
+func TestDefaultPostgreSQLSchema_filter(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	topic := "filter_" + watermill.NewShortUUID()
+
+	schemaAdapter := newPostgresSchemaAdapter(2)
+	schemaAdapter.GenerateFilter = func(params sql.GenerateWhereClauseParams) sql.PostgreSQLFilter {
+		return sql.PostgreSQLFilter{
+			MetadataEquals: map[string]string{"tenant": params.ConsumerGroup},
+		}
+	}
+	offsetsAdapter := newPostgresOffsetsAdapter()
+
+	publisher, subscriber := newPubSub(t, db, "acme", schemaAdapter, offsetsAdapter)
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	var expectedUUIDs []string
+	for _, tenant := range []string{"acme", "other", "other", "acme", "other", "other", "other"} {
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		msg.Metadata.Set("tenant", tenant)
+		require.NoError(t, publisher.Publish(topic, msg))
+
+		if tenant == "acme" {
+			expectedUUIDs = append(expectedUUIDs, msg.UUID)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := subscriber.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	var receivedUUIDs []string
+	for range expectedUUIDs {
+		select {
+		case msg := <-messages:
+			receivedUUIDs = append(receivedUUIDs, msg.UUID)
+			msg.Ack()
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	assert.Equal(t, expectedUUIDs, receivedUUIDs)
+
+	admin, err := sql.NewOffsetsAdmin(db, schemaAdapter, offsetsAdapter)
+	require.NoError(t, err)
+
+	// the consumer group is moved past the messages at the end of the topic which don't match the filter
+	assert.Eventually(t, func() bool {
+		groups, err := admin.ConsumerGroups(context.Background(), topic)
+		require.NoError(t, err)
+		require.Len(t, groups, 1)
+
+		return groups[0].Lag == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("message %s of another tenant received", msg.UUID)
+	default:
+	}
+}
@@ -0,0 +1,263 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// ForwarderDestinationTopicMetadataKey is the metadata key with the topic to which Forwarder publishes the message.
+// It's set by OutboxPublisher.
+const ForwarderDestinationTopicMetadataKey = "_watermill_forwarder_destination_topic"
+
+const defaultOutboxTopic = "outbox"
+
+// OutboxPublisherConfig is a configuration for OutboxPublisher.
+type OutboxPublisherConfig struct {
+	// OutboxTopic is the topic to which messages are published. It must match ForwarderConfig.OutboxTopic.
+	// Defaults to "outbox".
+	OutboxTopic string
+}
+
+func (c *OutboxPublisherConfig) setDefaults() {
+	if c.OutboxTopic == "" {
+		c.OutboxTopic = defaultOutboxTopic
+	}
+}
+
+// OutboxPublisher publishes messages to the outbox topic, with the destination topic stored in the metadata.
+//
+// It should wrap a Publisher created with a transaction (*sql.Tx), so messages are stored in the outbox
+// only if the transaction is committed. Forwarder then publishes them to the destination topic.
+type OutboxPublisher struct {
+	publisher message.Publisher
+	config    OutboxPublisherConfig
+}
+
+func NewOutboxPublisher(publisher message.Publisher, config OutboxPublisherConfig) (*OutboxPublisher, error) {
+	if publisher == nil {
+		return nil, errors.New("publisher is nil")
+	}
+
+	config.setDefaults()
+
+	// the topic is validated by the schema adapter of Publisher, other publishers use the default validation
+	var adapter any = publisher
+	if sqlPublisher, ok := publisher.(*Publisher); ok {
+		adapter = sqlPublisher.config.SchemaAdapter
+	}
+	if err := validateTopic(config.OutboxTopic, adapter); err != nil {
+		return nil, fmt.Errorf("invalid outbox topic: %w", err)
+	}
+
+	return &OutboxPublisher{
+		publisher: publisher,
+		config:    config,
+	}, nil
+}
+
+// Publish publishes messages to the outbox topic. The topic is the destination topic used by Forwarder.
+// The messages are not modified.
+func (p *OutboxPublisher) Publish(topic string, messages ...*message.Message) error {
+	if topic == "" {
+		return errors.New("destination topic is empty")
+	}
+
+	outboxMessages := make([]*message.Message, len(messages))
+	for i, msg := range messages {
+		outboxMsg := msg.Copy()
+		outboxMsg.SetContext(msg.Context())
+		outboxMsg.Metadata.Set(ForwarderDestinationTopicMetadataKey, topic)
+
+		outboxMessages[i] = outboxMsg
+	}
+
+	if err := p.publisher.Publish(p.config.OutboxTopic, outboxMessages...); err != nil {
+		return fmt.Errorf("could not publish to outbox topic %s: %w", p.config.OutboxTopic, err)
+	}
+
+	return nil
+}
+
+func (p *OutboxPublisher) Close() error {
+	return p.publisher.Close()
+}
+
+// ForwarderConfig is a configuration for Forwarder.
+type ForwarderConfig struct {
+	// DB is a database connection. Required.
+	DB Beginner
+
+	// SubscriberConfig is used to create the Subscriber of the outbox topic.
+	// SchemaAdapter and OffsetsAdapter are required.
+	SubscriberConfig SubscriberConfig
+
+	// Publisher is the publisher to which messages are forwarded, for example Kafka or AMQP publisher. Required.
+	Publisher message.Publisher
+
+	// OutboxTopic is the topic from which messages are forwarded. Defaults to "outbox".
+	OutboxTopic string
+
+	// AckWithoutDestinationTopic enables acking messages without the destination topic in metadata.
+	// Otherwise, they are nacked, which blocks the outbox until they are removed.
+	AckWithoutDestinationTopic bool
+
+	Logger watermill.LoggerAdapter
+}
+
+func (c *ForwarderConfig) setDefaults() {
+	if c.OutboxTopic == "" {
+		c.OutboxTopic = defaultOutboxTopic
+	}
+
+	if c.Logger == nil {
+		c.Logger = watermill.NopLogger{}
+	}
+}
+
+func (c ForwarderConfig) validate() error {
+	if c.DB == nil {
+		return errors.New("missing db")
+	}
+
+	if c.Publisher == nil {
+		return errors.New("missing publisher")
+	}
+
+	return validateTopic(c.OutboxTopic, c.SubscriberConfig.SchemaAdapter, c.SubscriberConfig.OffsetsAdapter)
+}
+
+// Forwarder subscribes to the outbox topic and publishes messages to the destination topic
+// set by OutboxPublisher in metadata.
+//
+// A message is acked only after it's published by the Publisher, so it's delivered at least once.
+// If publishing fails, the message is nacked and forwarded again after SubscriberConfig.ResendInterval.
+type Forwarder struct {
+	subscriber *Subscriber
+	config     ForwarderConfig
+	logger     watermill.LoggerAdapter
+
+	closeMu sync.Mutex
+	closing chan struct{}
+	closed  bool
+	runWg   sync.WaitGroup
+}
+
+func NewForwarder(config ForwarderConfig) (*Forwarder, error) {
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	subscriber, err := NewSubscriber(config.DB, config.SubscriberConfig, config.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not create subscriber: %w", err)
+	}
+
+	return &Forwarder{
+		subscriber: subscriber,
+		config:     config,
+		logger: config.Logger.With(watermill.LogFields{
+			"outbox_topic": config.OutboxTopic,
+		}),
+		closing: make(chan struct{}),
+	}, nil
+}
+
+// Run forwards messages until ctx is canceled or Close is called.
+func (f *Forwarder) Run(ctx context.Context) error {
+	f.closeMu.Lock()
+	if f.closed {
+		f.closeMu.Unlock()
+		return errors.New("forwarder is closed")
+	}
+	f.runWg.Add(1)
+	f.closeMu.Unlock()
+
+	defer f.runWg.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-f.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	defer func() {
+		if err := f.subscriber.Close(); err != nil {
+			f.logger.Error("Could not close subscriber", err, nil)
+		}
+	}()
+
+	messages, err := f.subscriber.Subscribe(ctx, f.config.OutboxTopic)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to outbox topic: %w", err)
+	}
+
+	for msg := range messages {
+		f.forward(msg)
+	}
+
+	return nil
+}
+
+// Close stops Run and waits until it returns.
+func (f *Forwarder) Close() error {
+	f.closeMu.Lock()
+	if f.closed {
+		f.closeMu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.closing)
+	f.closeMu.Unlock()
+
+	f.runWg.Wait()
+
+	return f.subscriber.Close()
+}
+
+func (f *Forwarder) forward(msg *message.Message) {
+	logger := f.logger.With(watermill.LogFields{
+		"message_uuid": msg.UUID,
+	})
+
+	destinationTopic := msg.Metadata.Get(ForwarderDestinationTopicMetadataKey)
+	if destinationTopic == "" {
+		logger.Error("Missing destination topic in outbox message", nil, watermill.LogFields{
+			"acked": f.config.AckWithoutDestinationTopic,
+		})
+
+		if f.config.AckWithoutDestinationTopic {
+			msg.Ack()
+		} else {
+			msg.Nack()
+		}
+		return
+	}
+
+	forwardedMsg := msg.Copy()
+	forwardedMsg.SetContext(msg.Context())
+	delete(forwardedMsg.Metadata, ForwarderDestinationTopicMetadataKey)
+
+	if err := f.config.Publisher.Publish(destinationTopic, forwardedMsg); err != nil {
+		logger.Error("Could not forward message", err, watermill.LogFields{
+			"destination_topic": destinationTopic,
+		})
+		msg.Nack()
+		return
+	}
+
+	logger.Trace("Message forwarded", watermill.LogFields{
+		"destination_topic": destinationTopic,
+	})
+	msg.Ack()
+}
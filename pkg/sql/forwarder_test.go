@@ -0,0 +1,200 @@
+package sql_test
+
+import (
+	"context"
+	stdSQL "database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+)
+
+// failingPublisher fails the first publish, to check that the message is forwarded again.
+type failingPublisher struct {
+	message.Publisher
+
+	mu     sync.Mutex
+	failed bool
+}
+
+func (p *failingPublisher) Publish(topic string, messages ...*message.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.failed {
+		p.failed = true
+		return errors.New("publish failed")
+	}
+
+	return p.Publisher.Publish(topic, messages...)
+}
+
+func TestForwarder(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+
+	outboxTopic := "outbox_" + watermill.NewShortUUID()
+	destinationTopic := "destination_" + watermill.NewShortUUID()
+
+	destination := gochannel.NewGoChannel(gochannel.Config{Persistent: true}, logger)
+
+	subscriberConfig := sql.SubscriberConfig{
+		ConsumerGroup:  "forwarder",
+		PollInterval:   10 * time.Millisecond,
+		ResendInterval: 10 * time.Millisecond,
+		SchemaAdapter:  newSQLiteSchemaAdapter(0),
+		OffsetsAdapter: newSQLiteOffsetsAdapter(),
+	}
+
+	// the outbox table must exist before publishing in a transaction
+	initializer, err := sql.NewSubscriber(db, subscriberConfig, logger)
+	require.NoError(t, err)
+	require.NoError(t, initializer.SubscribeInitialize(outboxTopic))
+
+	forwarder, err := sql.NewForwarder(sql.ForwarderConfig{
+		DB:               db,
+		SubscriberConfig: subscriberConfig,
+		Publisher:        &failingPublisher{Publisher: destination},
+		OutboxTopic:      outboxTopic,
+		Logger:           logger,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	forwarderDone := make(chan struct{})
+	go func() {
+		defer close(forwarderDone)
+		assert.NoError(t, forwarder.Run(ctx))
+	}()
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{"foo":"bar"}`))
+	msg.Metadata.Set("key", "value")
+
+	tx, err := db.BeginTx(ctx, &stdSQL.TxOptions{})
+	require.NoError(t, err)
+
+	txPublisher, err := sql.NewPublisher(tx, sql.PublisherConfig{
+		SchemaAdapter: newSQLiteSchemaAdapter(0),
+	}, logger)
+	require.NoError(t, err)
+
+	outboxPublisher, err := sql.NewOutboxPublisher(txPublisher, sql.OutboxPublisherConfig{
+		OutboxTopic: outboxTopic,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, outboxPublisher.Publish(destinationTopic, msg))
+	require.NoError(t, tx.Commit())
+
+	forwarded, err := destination.Subscribe(ctx, destinationTopic)
+	require.NoError(t, err)
+
+	select {
+	case received := <-forwarded:
+		assert.Equal(t, msg.UUID, received.UUID)
+		assert.Equal(t, msg.Payload, received.Payload)
+		assert.Equal(t, "value", received.Metadata.Get("key"))
+		assert.Empty(t, received.Metadata.Get(sql.ForwarderDestinationTopicMetadataKey))
+		received.Ack()
+	case <-time.After(10 * time.Second):
+		t.Fatal("message was not forwarded")
+	}
+
+	select {
+	case received := <-forwarded:
+		t.Fatalf("message %s should be forwarded once", received.UUID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case <-forwarderDone:
+	case <-time.After(10 * time.Second):
+		t.Fatal("forwarder did not stop")
+	}
+}
+
+func TestForwarder_Close(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+
+	forwarder, err := sql.NewForwarder(sql.ForwarderConfig{
+		DB: db,
+		SubscriberConfig: sql.SubscriberConfig{
+			ConsumerGroup:    "forwarder",
+			PollInterval:     10 * time.Millisecond,
+			SchemaAdapter:    newSQLiteSchemaAdapter(0),
+			OffsetsAdapter:   newSQLiteOffsetsAdapter(),
+			InitializeSchema: true,
+		},
+		Publisher:   gochannel.NewGoChannel(gochannel.Config{}, logger),
+		OutboxTopic: "outbox_" + watermill.NewShortUUID(),
+		Logger:      logger,
+	})
+	require.NoError(t, err)
+
+	forwarderDone := make(chan struct{})
+	go func() {
+		defer close(forwarderDone)
+		assert.NoError(t, forwarder.Run(context.Background()))
+	}()
+
+	// waiting for Run to subscribe, Close also works before it
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, forwarder.Close())
+
+	select {
+	case <-forwarderDone:
+	case <-time.After(time.Second):
+		t.Fatal("Run should return when the forwarder is closed")
+	}
+
+	require.NoError(t, forwarder.Close())
+	assert.Error(t, forwarder.Run(context.Background()), "closed forwarder should not run again")
+}
+
+func TestForwarder_outbox_topic_validated_by_adapters(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+
+	// the shared table adapters allow topics which are not valid identifiers
+	outboxTopic := "tenants/acme/outbox"
+
+	publisher, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter: newPostgresSharedTableSchemaAdapter(),
+	}, logger)
+	require.NoError(t, err)
+
+	_, err = sql.NewOutboxPublisher(publisher, sql.OutboxPublisherConfig{OutboxTopic: outboxTopic})
+	assert.NoError(t, err)
+
+	_, err = sql.NewOutboxPublisher(gochannel.NewGoChannel(gochannel.Config{}, logger), sql.OutboxPublisherConfig{OutboxTopic: outboxTopic})
+	assert.ErrorIs(t, err, sql.ErrInvalidTopicName, "other publishers should use the default validation")
+
+	_, err = sql.NewForwarder(sql.ForwarderConfig{
+		DB: db,
+		SubscriberConfig: sql.SubscriberConfig{
+			SchemaAdapter:  newPostgresSharedTableSchemaAdapter(),
+			OffsetsAdapter: newPostgresSharedTableOffsetsAdapter(),
+		},
+		Publisher:   gochannel.NewGoChannel(gochannel.Config{}, logger),
+		OutboxTopic: outboxTopic,
+		Logger:      logger,
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,171 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PostgreSQLIndex is an index of the messages table, created by SchemaInitializingQueries
+// of DefaultPostgreSQLSchema, PostgreSQLQueueSchema, and PostgreSQLSharedTableSchema (see their GenerateIndexes).
+type PostgreSQLIndex struct {
+	// Name is the name of the index. It must be unique in the database schema, so it should contain the topic. Required.
+	Name string
+
+	// Method is the index method, for example "gin". Defaults to btree.
+	Method string
+
+	// Expression is the indexed column, like "metadata", or an expression in parentheses,
+	// like "(metadata->>'tenant')". Required.
+	Expression string
+
+	// OperatorClass is the operator class of the indexed expression, for example "jsonb_path_ops".
+	OperatorClass string
+
+	// Where makes the index partial, for example "acked = false".
+	Where string
+}
+
+// PostgreSQLMetadataGINIndex returns a GIN index of the metadata column, used by containment queries
+// like metadata @> '{"tenant": "acme"}'. It requires the JSONB metadata column.
+func PostgreSQLMetadataGINIndex(name string) PostgreSQLIndex {
+	return PostgreSQLIndex{
+		Name:          name,
+		Method:        "gin",
+		Expression:    "metadata",
+		OperatorClass: "jsonb_path_ops",
+	}
+}
+
+// PostgreSQLMetadataKeyIndex returns an index of the metadata key, used by queries like metadata->>'tenant' = 'acme'.
+// It works with both JSON and JSONB metadata columns.
+func PostgreSQLMetadataKeyIndex(name string, key string) PostgreSQLIndex {
+	return PostgreSQLIndex{
+		Name:       name,
+		Expression: "(metadata->>'" + strings.ReplaceAll(key, "'", "''") + "')",
+	}
+}
+
+func (i PostgreSQLIndex) validate() error {
+	if i.Name == "" {
+		return errors.New("index name is required")
+	}
+	if i.Expression == "" {
+		return fmt.Errorf("expression of index %s is required", i.Name)
+	}
+
+	return nil
+}
+
+// postgreSQLIndexQueries returns queries creating the indexes of the table, if they don't exist.
+func postgreSQLIndexQueries(table string, indexes []PostgreSQLIndex) ([]Query, error) {
+	var queries []Query
+
+	for _, index := range indexes {
+		if err := index.validate(); err != nil {
+			return nil, err
+		}
+
+		query := strings.Builder{}
+		query.WriteString(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s", pgx.Identifier{index.Name}.Sanitize(), table))
+		if index.Method != "" {
+			query.WriteString(" USING " + index.Method)
+		}
+
+		query.WriteString(" (" + index.Expression)
+		if index.OperatorClass != "" {
+			query.WriteString(" " + index.OperatorClass)
+		}
+		query.WriteString(")")
+
+		if index.Where != "" {
+			query.WriteString(" WHERE " + index.Where)
+		}
+
+		queries = append(queries, Query{Query: query.String()})
+	}
+
+	return queries, nil
+}
+
+// postgreSQLJSONBMigrationQueries returns queries converting the JSON columns of the table to JSONB.
+// Columns which are not JSON (for example, already converted) are not changed, so the queries can be executed again.
+func postgreSQLJSONBMigrationQueries(table string, columns []string) ([]Query, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("JSONB is not enabled for any column")
+	}
+
+	var queries []Query
+
+	for _, column := range columns {
+		queries = append(queries, Query{
+			Query: fmt.Sprintf(`
+				DO $$
+				BEGIN
+					IF EXISTS (
+						SELECT 1 FROM pg_attribute
+						WHERE attrelid = '%s'::regclass AND attname = '%s' AND atttypid = 'json'::regtype
+					) THEN
+						ALTER TABLE %s ALTER COLUMN "%s" TYPE JSONB USING "%s"::JSONB;
+					END IF;
+				END $$;`,
+				strings.ReplaceAll(table, "'", "''"),
+				column,
+				table,
+				column,
+				column,
+			),
+		})
+	}
+
+	return queries, nil
+}
+
+// jsonbColumns returns the columns which have the JSONB type.
+func jsonbColumns(payloadType string, metadataType string) []string {
+	var columns []string
+
+	if strings.EqualFold(payloadType, "JSONB") {
+		columns = append(columns, "payload")
+	}
+	if strings.EqualFold(metadataType, "JSONB") {
+		columns = append(columns, "metadata")
+	}
+
+	return columns
+}
+
+// JSONBMigrationQueries returns queries converting the payload and metadata columns of an existing messages table
+// from JSON to JSONB, when they are JSONB in the schema (see JSONB and GeneratePayloadType).
+// Columns which are already converted are skipped.
+//
+// Converted values are normalized: whitespace and the order of keys are not preserved,
+// and only the last value of duplicate keys is kept.
+//
+// The conversion rewrites the table and locks it for the time of the rewrite,
+// so it should be executed when the topic is not used, for example during a maintenance window.
+func (s DefaultPostgreSQLSchema) JSONBMigrationQueries(topic string) ([]Query, error) {
+	return postgreSQLJSONBMigrationQueries(
+		s.MessagesTable(topic),
+		jsonbColumns(s.PayloadColumnType(topic), s.MetadataColumnType(topic)),
+	)
+}
+
+// JSONBMigrationQueries works like DefaultPostgreSQLSchema.JSONBMigrationQueries.
+func (s PostgreSQLQueueSchema) JSONBMigrationQueries(topic string) ([]Query, error) {
+	return postgreSQLJSONBMigrationQueries(
+		s.MessagesTable(topic),
+		jsonbColumns(s.payloadColumnType(topic), s.metadataColumnType(topic)),
+	)
+}
+
+// JSONBMigrationQueries works like DefaultPostgreSQLSchema.JSONBMigrationQueries, but the table is shared
+// by all topics, so the conversion locks and rewrites messages of all topics.
+func (s PostgreSQLSharedTableSchema) JSONBMigrationQueries(topic string) ([]Query, error) {
+	return postgreSQLJSONBMigrationQueries(
+		s.MessagesTable(topic),
+		jsonbColumns(s.PayloadColumnType(topic), s.MetadataColumnType(topic)),
+	)
+}
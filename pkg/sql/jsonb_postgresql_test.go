@@ -0,0 +1,206 @@
+package sql_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func TestPostgreSQLSchemas_JSONB_queries(t *testing.T) {
+	t.Parallel()
+
+	generateIndexes := func(topic string) []sql.PostgreSQLIndex {
+		return []sql.PostgreSQLIndex{
+			sql.PostgreSQLMetadataGINIndex(topic + "_metadata_idx"),
+			sql.PostgreSQLMetadataKeyIndex(topic+"_tenant_idx", "tenant"),
+			{
+				Name:       topic + "_not_acked_idx",
+				Expression: `"offset"`,
+				Where:      "acked = false",
+			},
+		}
+	}
+
+	testCases := []struct {
+		Name          string
+		SchemaAdapter sql.SchemaAdapter
+	}{
+		{
+			Name: "default",
+			SchemaAdapter: sql.DefaultPostgreSQLSchema{
+				InitializeSchemaWithoutTransaction: true,
+				JSONB:                              true,
+				GenerateIndexes:                    generateIndexes,
+			},
+		},
+		{
+			Name: "queue",
+			SchemaAdapter: sql.PostgreSQLQueueSchema{
+				JSONB:           true,
+				GenerateIndexes: generateIndexes,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			queries, err := tc.SchemaAdapter.SchemaInitializingQueries(sql.SchemaInitializingQueriesParams{Topic: "topic"})
+			require.NoError(t, err)
+
+			// JSONB would normalize the payload, so it's used only for metadata
+			assert.Contains(t, queries[0].Query, `"payload" JSON DEFAULT NULL`)
+			assert.Contains(t, queries[0].Query, `"metadata" JSONB DEFAULT NULL`)
+
+			var indexQueries []string
+			for _, query := range queries {
+				indexQueries = append(indexQueries, query.Query)
+			}
+
+			assert.Subset(t, indexQueries, []string{
+				`CREATE INDEX IF NOT EXISTS "topic_metadata_idx" ON "watermill_topic" USING gin (metadata jsonb_path_ops)`,
+				`CREATE INDEX IF NOT EXISTS "topic_tenant_idx" ON "watermill_topic" ((metadata->>'tenant'))`,
+				`CREATE INDEX IF NOT EXISTS "topic_not_acked_idx" ON "watermill_topic" ("offset") WHERE acked = false`,
+			})
+		})
+	}
+}
+
+func TestPostgreSQLSchemas_invalid_index(t *testing.T) {
+	t.Parallel()
+
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		GenerateIndexes: func(topic string) []sql.PostgreSQLIndex {
+			return []sql.PostgreSQLIndex{{Name: "index"}}
+		},
+	}
+
+	_, err := schemaAdapter.SchemaInitializingQueries(sql.SchemaInitializingQueriesParams{Topic: "topic"})
+	assert.Error(t, err)
+}
+
+func TestPostgreSQLSchemas_JSONBMigrationQueries(t *testing.T) {
+	t.Parallel()
+
+	_, err := sql.DefaultPostgreSQLSchema{}.JSONBMigrationQueries("topic")
+	assert.Error(t, err, "JSONB is not enabled")
+
+	queries, err := sql.DefaultPostgreSQLSchema{
+		JSONB: true,
+		GeneratePayloadType: func(topic string) string {
+			return "BYTEA"
+		},
+	}.JSONBMigrationQueries("topic")
+	require.NoError(t, err)
+	require.Len(t, queries, 1)
+	assert.Contains(t, queries[0].Query, `ALTER TABLE "watermill_topic" ALTER COLUMN "metadata" TYPE JSONB`)
+
+	queries, err = sql.PostgreSQLQueueSchema{JSONB: true}.JSONBMigrationQueries("topic")
+	require.NoError(t, err)
+	require.Len(t, queries, 1)
+	assert.Contains(t, queries[0].Query, `ALTER COLUMN "metadata" TYPE JSONB`)
+
+	queries, err = sql.PostgreSQLQueueSchema{
+		JSONB: true,
+		GeneratePayloadType: func(topic string) string {
+			return "JSONB"
+		},
+	}.JSONBMigrationQueries("topic")
+	require.NoError(t, err)
+	assert.Len(t, queries, 2)
+}
+
+func TestPostgreSQLJSONBMigration(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	topic := "jsonb_" + watermill.NewShortUUID()
+
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		GenerateMessagesTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_%s"`, topic)
+		},
+	}
+
+	// the table is created with JSON columns first
+	publisher, subscriber := newPubSub(t, db, "", schemaAdapter, sql.PostgreSQLQueueOffsetsAdapter{})
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{"foo":"bar"}`))
+	msg.Metadata.Set("tenant", "acme")
+	require.NoError(t, publisher.Publish(topic, msg))
+
+	schemaAdapter.JSONB = true
+	schemaAdapter.GeneratePayloadType = func(topic string) string {
+		return "JSONB"
+	}
+	schemaAdapter.GenerateIndexes = func(topic string) []sql.PostgreSQLIndex {
+		return []sql.PostgreSQLIndex{
+			sql.PostgreSQLMetadataGINIndex("test_" + topic + "_metadata_idx"),
+		}
+	}
+	schemaAdapter.GenerateWhereClause = func(params sql.GenerateWhereClauseParams) (string, []any) {
+		return `metadata @> '{"tenant": "acme"}'`, nil
+	}
+
+	queries, err := schemaAdapter.JSONBMigrationQueries(topic)
+	require.NoError(t, err)
+
+	// the second run doesn't change anything
+	for i := 0; i < 2; i++ {
+		for _, query := range queries {
+			_, err := db.ExecContext(context.Background(), query.Query, query.Args...)
+			require.NoError(t, err)
+		}
+	}
+
+	rows, err := db.QueryContext(
+		context.Background(),
+		`SELECT data_type FROM information_schema.columns WHERE table_name = $1 AND column_name IN ('payload', 'metadata')`,
+		"test_"+topic,
+	)
+	require.NoError(t, err)
+
+	var types []string
+	for rows.Next() {
+		var dataType string
+		require.NoError(t, rows.Scan(&dataType))
+		types = append(types, dataType)
+	}
+	require.NoError(t, rows.Close())
+	assert.Equal(t, []string{"jsonb", "jsonb"}, types)
+
+	publisher, subscriber = newPubSub(t, db, "", schemaAdapter, sql.PostgreSQLQueueOffsetsAdapter{})
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	otherMsg := message.NewMessage(watermill.NewUUID(), []byte(`{"foo":"baz"}`))
+	otherMsg.Metadata.Set("tenant", "other")
+	require.NoError(t, publisher.Publish(topic, otherMsg))
+
+	messages, err := subscriber.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	select {
+	case received := <-messages:
+		assert.Equal(t, msg.UUID, received.UUID)
+		assert.JSONEq(t, string(msg.Payload), string(received.Payload))
+		received.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	select {
+	case received := <-messages:
+		t.Fatalf("message %s of another tenant received", received.UUID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
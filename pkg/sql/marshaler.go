@@ -0,0 +1,156 @@
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// MarshalerColumn is a column of the messages table used by Marshaler, besides uuid, payload, and metadata.
+type MarshalerColumn struct {
+	// Name is the name of the column. It's quoted by the schema adapter.
+	Name string
+
+	// Type is the SQL type of the column used when the messages table is created, for example "VARCHAR(255)".
+	Type string
+}
+
+// Marshaler converts Watermill messages to values of the messages table columns, and back.
+// It's used by the default schema adapters, so the way messages are stored can be changed
+// without implementing the whole SchemaAdapter, for example to compress payloads, to store metadata
+// in a different format (see GenerateMetadataType of the schema adapters), or to promote selected
+// metadata keys into dedicated columns, which can be indexed.
+type Marshaler interface {
+	// ExtraColumns returns the columns stored by Marshal besides uuid, payload, and metadata.
+	// They are created with the messages table, and selected after the columns used by the schema adapter.
+	// Names of the columns must not collide with columns of the schema adapter.
+	ExtraColumns(topic string) []MarshalerColumn
+
+	// Marshal returns values of the uuid, payload, and metadata columns, followed by values of ExtraColumns.
+	Marshal(topic string, msg *message.Message) ([]any, error)
+
+	// Unmarshal creates the Watermill message from the selected row.
+	// Values of ExtraColumns are available in row.ExtraData by their names, as []byte (nil for NULL).
+	Unmarshal(topic string, row Row) (*message.Message, error)
+}
+
+// DefaultMarshaler stores the payload as is, and the metadata as JSON.
+// It's the default Marshaler of the schema adapters.
+type DefaultMarshaler struct{}
+
+func (DefaultMarshaler) ExtraColumns(topic string) []MarshalerColumn {
+	return nil
+}
+
+func (DefaultMarshaler) Marshal(topic string, msg *message.Message) ([]any, error) {
+	metadata, err := json.Marshal(msg.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal metadata into JSON: %w", err)
+	}
+
+	return []any{msg.UUID, []byte(msg.Payload), metadata}, nil
+}
+
+func (DefaultMarshaler) Unmarshal(topic string, row Row) (*message.Message, error) {
+	msg := message.NewMessage(string(row.UUID), row.Payload)
+
+	if row.Metadata != nil {
+		if err := json.Unmarshal(row.Metadata, &msg.Metadata); err != nil {
+			return nil, fmt.Errorf("could not unmarshal metadata as JSON: %w", err)
+		}
+	}
+
+	return msg, nil
+}
+
+func marshalerOrDefault(marshaler Marshaler) Marshaler {
+	if marshaler == nil {
+		return DefaultMarshaler{}
+	}
+
+	return marshaler
+}
+
+// marshalMessages returns values of all columns of all messages, in order.
+func marshalMessages(marshaler Marshaler, topic string, msgs message.Messages) ([]any, error) {
+	columns := 3 + len(marshaler.ExtraColumns(topic))
+
+	args := make([]any, 0, len(msgs)*columns)
+	for _, msg := range msgs {
+		values, err := marshaler.Marshal(topic, msg)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal message %s: %w", msg.UUID, err)
+		}
+		if len(values) != columns {
+			return nil, fmt.Errorf("marshaler returned %d values for message %s, expected %d", len(values), msg.UUID, columns)
+		}
+
+		args = append(args, values...)
+	}
+
+	return args, nil
+}
+
+// unmarshalRow scans the row into dest followed by the extra columns, and unmarshals the message.
+// dest must contain pointers to r.Offset, r.UUID, r.Payload, and r.Metadata, in the order of the select query.
+func unmarshalRow(marshaler Marshaler, topic string, scanner Scanner, r *Row, dest ...any) error {
+	columns := marshaler.ExtraColumns(topic)
+
+	extra := make([][]byte, len(columns))
+	for i := range extra {
+		dest = append(dest, &extra[i])
+	}
+
+	if err := scanner.Scan(dest...); err != nil {
+		return fmt.Errorf("could not scan message row: %w", err)
+	}
+
+	if len(columns) > 0 {
+		if r.ExtraData == nil {
+			r.ExtraData = map[string]any{}
+		}
+		for i, column := range columns {
+			r.ExtraData[column.Name] = extra[i]
+		}
+	}
+
+	msg, err := marshaler.Unmarshal(topic, *r)
+	if err != nil {
+		return err
+	}
+
+	r.Msg = msg
+
+	return nil
+}
+
+// marshalerColumnsList returns the quoted extra columns, each preceded by a comma and the prefix,
+// so it can be appended to a list of columns.
+func marshalerColumnsList(columns []MarshalerColumn, quote string, prefix string) string {
+	result := strings.Builder{}
+	for _, column := range columns {
+		result.WriteString(", " + prefix + quote + column.Name + quote)
+	}
+
+	return result.String()
+}
+
+// marshalerColumnsDefinitions returns definitions of the quoted extra columns, each preceded by a comma,
+// so they can be appended to a CREATE TABLE statement.
+func marshalerColumnsDefinitions(columns []MarshalerColumn, quote string) string {
+	result := strings.Builder{}
+	for _, column := range columns {
+		result.WriteString(",\n" + quote + column.Name + quote + " " + column.Type + " DEFAULT NULL")
+	}
+
+	return result.String()
+}
+
+// questionMarkInsertMarkers returns markers of count rows with the number of columns, like (?,?,?),(?,?,?).
+func questionMarkInsertMarkers(count int, columns int) string {
+	row := "(" + strings.TrimRight(strings.Repeat("?,", columns), ",") + ")"
+
+	return strings.TrimRight(strings.Repeat(row+",", count), ",")
+}
@@ -0,0 +1,139 @@
+package sql_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// tenantMarshaler compresses payloads, and stores the tenant metadata in a dedicated column.
+type tenantMarshaler struct{}
+
+func (tenantMarshaler) ExtraColumns(topic string) []sql.MarshalerColumn {
+	return []sql.MarshalerColumn{{Name: "tenant", Type: "TEXT"}}
+}
+
+func (tenantMarshaler) Marshal(topic string, msg *message.Message) ([]any, error) {
+	payload := bytes.Buffer{}
+	writer := gzip.NewWriter(&payload)
+	if _, err := writer.Write(msg.Payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	metadata := message.Metadata{}
+	for key, value := range msg.Metadata {
+		if key != "tenant" {
+			metadata[key] = value
+		}
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return []any{msg.UUID, payload.Bytes(), metadataJSON, msg.Metadata.Get("tenant")}, nil
+}
+
+func (tenantMarshaler) Unmarshal(topic string, row sql.Row) (*message.Message, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(row.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := message.NewMessage(string(row.UUID), payload)
+	if err := json.Unmarshal(row.Metadata, &msg.Metadata); err != nil {
+		return nil, err
+	}
+
+	msg.Metadata.Set("tenant", string(row.ExtraData["tenant"].([]byte)))
+
+	return msg, nil
+}
+
+func TestMarshaler_SQLite(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "marshaler_" + watermill.NewShortUUID()
+
+	schemaAdapter := newSQLiteSchemaAdapter(1)
+	schemaAdapter.Marshaler = tenantMarshaler{}
+
+	publisher, subscriber := newPubSub(t, db, "marshaler", schemaAdapter, newSQLiteOffsetsAdapter())
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{"foo":"bar"}`))
+	msg.Metadata.Set("tenant", "acme")
+	msg.Metadata.Set("key", "value")
+	require.NoError(t, publisher.Publish(topic, msg))
+
+	rows, err := db.QueryContext(
+		context.Background(),
+		`SELECT "tenant", "metadata" FROM `+schemaAdapter.MessagesTable(topic),
+	)
+	require.NoError(t, err)
+
+	var tenant, metadata string
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&tenant, &metadata))
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, "acme", tenant)
+	assert.JSONEq(t, `{"key":"value"}`, metadata)
+
+	messages, err := subscriber.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	select {
+	case received := <-messages:
+		assert.Equal(t, msg.UUID, received.UUID)
+		assert.Equal(t, msg.Payload, received.Payload)
+		assert.Equal(t, "acme", received.Metadata.Get("tenant"))
+		assert.Equal(t, "value", received.Metadata.Get("key"))
+		received.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+type invalidMarshaler struct {
+	sql.DefaultMarshaler
+}
+
+func (invalidMarshaler) Marshal(topic string, msg *message.Message) ([]any, error) {
+	return []any{msg.UUID, []byte(msg.Payload)}, nil
+}
+
+func TestMarshaler_invalid_values(t *testing.T) {
+	t.Parallel()
+
+	schemaAdapter := sql.DefaultSQLiteSchema{
+		Marshaler: invalidMarshaler{},
+	}
+
+	_, err := schemaAdapter.InsertQuery(sql.InsertQueryParams{
+		Topic: "topic",
+		Msgs:  message.Messages{message.NewMessage(watermill.NewUUID(), nil)},
+	})
+	assert.ErrorContains(t, err, "marshaler returned 2 values")
+}
@@ -0,0 +1,88 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// Queries reported with Metrics.QueryDuration.
+const (
+	MetricsQuerySelect = "select"
+	MetricsQueryAck    = "ack"
+	MetricsQueryInsert = "insert"
+)
+
+// Metrics is notified about messages and queries of Publisher and Subscriber.
+// PrometheusMetrics exposes them to Prometheus.
+//
+// Methods are called synchronously, so they must be fast and safe for concurrent use.
+type Metrics interface {
+	// MessagesPublished is called after messages are inserted. When the Publisher uses a transaction,
+	// the messages are not visible to subscribers until it's committed.
+	MessagesPublished(topic string, count int)
+
+	// MessageConsumed is called when a message is sent to the subscriber's output channel.
+	// Resent messages are counted again.
+	MessageConsumed(topic string, consumerGroup string)
+
+	MessageAcked(topic string, consumerGroup string)
+	MessageNacked(topic string, consumerGroup string)
+
+	// QueryDuration is called after a query is executed, with a non-nil err if it failed.
+	// The query is MetricsQuerySelect, MetricsQueryAck, or MetricsQueryInsert.
+	QueryDuration(query string, topic string, duration time.Duration, err error)
+
+	// BackoffSleep is called when the Subscriber waits before the next query, as returned by BackoffManager.
+	BackoffSleep(topic string, consumerGroup string, duration time.Duration)
+
+	// ConsumerGroupLag is called periodically with the number of messages not acked by the consumer group yet.
+	ConsumerGroupLag(topic string, consumerGroup string, lag int64)
+}
+
+// NopMetrics is a Metrics implementation which does nothing. It's the default in PublisherConfig and SubscriberConfig.
+type NopMetrics struct{}
+
+func (NopMetrics) MessagesPublished(topic string, count int)                                   {}
+func (NopMetrics) MessageConsumed(topic string, consumerGroup string)                          {}
+func (NopMetrics) MessageAcked(topic string, consumerGroup string)                             {}
+func (NopMetrics) MessageNacked(topic string, consumerGroup string)                            {}
+func (NopMetrics) QueryDuration(query string, topic string, duration time.Duration, err error) {}
+func (NopMetrics) BackoffSleep(topic string, consumerGroup string, duration time.Duration)     {}
+func (NopMetrics) ConsumerGroupLag(topic string, consumerGroup string, lag int64)              {}
+
+// reportLag reports the lag of the subscriber's consumer group every LagReportInterval, until ctx is canceled.
+// The lag is computed with OffsetsAdmin, so it's not reported for adapters which it doesn't support.
+func (s *Subscriber) reportLag(ctx context.Context, topic string, logger watermill.LoggerAdapter) {
+	defer s.subscribeWg.Done()
+
+	admin, err := NewOffsetsAdmin(s.db, s.config.SchemaAdapter, s.config.OffsetsAdapter)
+	if err != nil {
+		logger.Debug("Consumer group lag is not reported", watermill.LogFields{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	for {
+		groups, err := admin.ConsumerGroups(ctx, topic)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Could not compute consumer group lag", err, nil)
+		}
+
+		for _, group := range groups {
+			if group.ConsumerGroup == s.config.ConsumerGroup {
+				s.config.Metrics.ConsumerGroupLag(topic, group.ConsumerGroup, group.Lag)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.closing:
+			return
+		case <-time.After(s.config.LagReportInterval):
+		}
+	}
+}
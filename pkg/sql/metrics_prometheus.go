@@ -0,0 +1,113 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusMetrics is a Metrics implementation exposing metrics to Prometheus.
+//
+// All metrics have the "watermill_sql" namespace, for example watermill_sql_messages_published_total.
+type PrometheusMetrics struct {
+	messagesPublished *prometheus.CounterVec
+	messagesConsumed  *prometheus.CounterVec
+	messagesAcked     *prometheus.CounterVec
+	messagesNacked    *prometheus.CounterVec
+	queryDuration     *prometheus.HistogramVec
+	backoffSleep      *prometheus.HistogramVec
+	consumerGroupLag  *prometheus.GaugeVec
+}
+
+// NewPrometheusMetrics creates PrometheusMetrics and registers them in the registerer,
+// for example prometheus.DefaultRegisterer.
+func NewPrometheusMetrics(registerer prometheus.Registerer) (*PrometheusMetrics, error) {
+	const namespace = "watermill_sql"
+
+	m := &PrometheusMetrics{
+		messagesPublished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_published_total",
+			Help:      "Number of published messages.",
+		}, []string{"topic"}),
+		messagesConsumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_consumed_total",
+			Help:      "Number of messages sent to subscribers, including resent messages.",
+		}, []string{"topic", "consumer_group"}),
+		messagesAcked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_acked_total",
+			Help:      "Number of messages acked by subscribers.",
+		}, []string{"topic", "consumer_group"}),
+		messagesNacked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_nacked_total",
+			Help:      "Number of messages nacked by subscribers.",
+		}, []string{"topic", "consumer_group"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Duration of select, ack, and insert queries.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"query", "topic", "success"}),
+		backoffSleep: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backoff_sleep_seconds",
+			Help:      "Time subscribers wait before the next query.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic", "consumer_group"}),
+		consumerGroupLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "consumer_group_lag",
+			Help:      "Number of messages not acked by the consumer group yet.",
+		}, []string{"topic", "consumer_group"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.messagesPublished,
+		m.messagesConsumed,
+		m.messagesAcked,
+		m.messagesNacked,
+		m.queryDuration,
+		m.backoffSleep,
+		m.consumerGroupLag,
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, fmt.Errorf("could not register metrics: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *PrometheusMetrics) MessagesPublished(topic string, count int) {
+	m.messagesPublished.WithLabelValues(topic).Add(float64(count))
+}
+
+func (m *PrometheusMetrics) MessageConsumed(topic string, consumerGroup string) {
+	m.messagesConsumed.WithLabelValues(topic, consumerGroup).Inc()
+}
+
+func (m *PrometheusMetrics) MessageAcked(topic string, consumerGroup string) {
+	m.messagesAcked.WithLabelValues(topic, consumerGroup).Inc()
+}
+
+func (m *PrometheusMetrics) MessageNacked(topic string, consumerGroup string) {
+	m.messagesNacked.WithLabelValues(topic, consumerGroup).Inc()
+}
+
+func (m *PrometheusMetrics) QueryDuration(query string, topic string, duration time.Duration, err error) {
+	m.queryDuration.WithLabelValues(query, topic, strconv.FormatBool(err == nil)).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) BackoffSleep(topic string, consumerGroup string, duration time.Duration) {
+	m.backoffSleep.WithLabelValues(topic, consumerGroup).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) ConsumerGroupLag(topic string, consumerGroup string, lag int64) {
+	m.consumerGroupLag.WithLabelValues(topic, consumerGroup).Set(float64(lag))
+}
@@ -0,0 +1,179 @@
+package sql_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+type recordedMetrics struct {
+	mu sync.Mutex
+
+	published int
+	consumed  int
+	acked     int
+	nacked    int
+	queries   map[string]int
+	lag       map[string]int64
+}
+
+func newRecordedMetrics() *recordedMetrics {
+	return &recordedMetrics{
+		queries: map[string]int{},
+		lag:     map[string]int64{},
+	}
+}
+
+func (m *recordedMetrics) MessagesPublished(topic string, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.published += count
+}
+
+func (m *recordedMetrics) MessageConsumed(topic string, consumerGroup string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.consumed++
+}
+
+func (m *recordedMetrics) MessageAcked(topic string, consumerGroup string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acked++
+}
+
+func (m *recordedMetrics) MessageNacked(topic string, consumerGroup string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nacked++
+}
+
+func (m *recordedMetrics) QueryDuration(query string, topic string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries[query]++
+}
+
+func (m *recordedMetrics) BackoffSleep(topic string, consumerGroup string, duration time.Duration) {}
+
+func (m *recordedMetrics) ConsumerGroupLag(topic string, consumerGroup string, lag int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lag[consumerGroup] = lag
+}
+
+func (m *recordedMetrics) consumerGroupLag(consumerGroup string) (int64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lag, ok := m.lag[consumerGroup]
+	return lag, ok
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "metrics_" + watermill.NewShortUUID()
+	metrics := newRecordedMetrics()
+
+	publisher, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        newSQLiteSchemaAdapter(1),
+		AutoInitializeSchema: true,
+		Metrics:              metrics,
+	}, logger)
+	require.NoError(t, err)
+
+	subscriber, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		ConsumerGroup:     "metrics",
+		PollInterval:      10 * time.Millisecond,
+		ResendInterval:    10 * time.Millisecond,
+		SchemaAdapter:     newSQLiteSchemaAdapter(1),
+		OffsetsAdapter:    newSQLiteOffsetsAdapter(),
+		InitializeSchema:  true,
+		Metrics:           metrics,
+		LagReportInterval: 10 * time.Millisecond,
+	}, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, publisher.Publish(
+		topic,
+		message.NewMessage(watermill.NewUUID(), []byte("1")),
+		message.NewMessage(watermill.NewUUID(), []byte("2")),
+		message.NewMessage(watermill.NewUUID(), []byte("3")),
+	))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := subscriber.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	// the first message is nacked once
+	msg := <-messages
+	msg.Nack()
+
+	for i := 0; i < 3; i++ {
+		msg := <-messages
+		msg.Ack()
+	}
+
+	assert.Eventually(t, func() bool {
+		lag, ok := metrics.consumerGroupLag("metrics")
+		return ok && lag == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, subscriber.Close())
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	assert.Equal(t, 3, metrics.published)
+	assert.Equal(t, 4, metrics.consumed)
+	assert.Equal(t, 3, metrics.acked)
+	assert.Equal(t, 1, metrics.nacked)
+	assert.Equal(t, 1, metrics.queries[sql.MetricsQueryInsert])
+	assert.GreaterOrEqual(t, metrics.queries[sql.MetricsQuerySelect], 3)
+	assert.GreaterOrEqual(t, metrics.queries[sql.MetricsQueryAck], 3)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+
+	metrics, err := sql.NewPrometheusMetrics(registry)
+	require.NoError(t, err)
+
+	metrics.MessagesPublished("topic", 3)
+	metrics.MessageAcked("topic", "group")
+	metrics.QueryDuration(sql.MetricsQuerySelect, "topic", time.Millisecond, nil)
+	metrics.ConsumerGroupLag("topic", "group", 5)
+
+	count, err := testutil.GatherAndCount(registry)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	err = testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP watermill_sql_consumer_group_lag Number of messages not acked by the consumer group yet.
+# TYPE watermill_sql_consumer_group_lag gauge
+watermill_sql_consumer_group_lag{consumer_group="group",topic="topic"} 5
+# HELP watermill_sql_messages_published_total Number of published messages.
+# TYPE watermill_sql_messages_published_total counter
+watermill_sql_messages_published_total{topic="topic"} 3
+`), "watermill_sql_consumer_group_lag", "watermill_sql_messages_published_total")
+	assert.NoError(t, err)
+
+	_, err = sql.NewPrometheusMetrics(registry)
+	assert.Error(t, err, "metrics are already registered")
+}
@@ -0,0 +1,68 @@
+package sql
+
+import (
+	"github.com/jackc/pgx/v5"
+)
+
+// PostgreSQLNamespace configures where the PostgreSQL adapters create their tables.
+// The same value should be set in the schema adapter and the offsets adapter (and other components using
+// the tables, like PostgreSQLPartitionManager's schema adapter), so they use the same tables.
+//
+// It's used only for the default table names. Tables set with functions like GenerateMessagesTableName are not changed.
+type PostgreSQLNamespace struct {
+	// Schema is the PostgreSQL schema of the tables. It's created during the schema initialization, if it doesn't exist.
+	// Defaults to the current schema (the first schema in search_path, usually "public").
+	Schema string
+
+	// TablePrefix is prepended to the names of the tables. Defaults to "watermill_".
+	TablePrefix string
+}
+
+func (n PostgreSQLNamespace) prefix() string {
+	if n.TablePrefix == "" {
+		return "watermill_"
+	}
+
+	return n.TablePrefix
+}
+
+// table returns the quoted name of the table with the prefix, qualified with the schema if it's set.
+func (n PostgreSQLNamespace) table(name string) string {
+	return n.qualify(n.prefix() + name)
+}
+
+// qualify returns the quoted name of the table, qualified with the schema if it's set.
+func (n PostgreSQLNamespace) qualify(table string) string {
+	if n.Schema == "" {
+		return pgx.Identifier{table}.Sanitize()
+	}
+
+	return pgx.Identifier{n.Schema, table}.Sanitize()
+}
+
+// schemaInitializingQueries returns the query creating the schema, if it's set.
+func (n PostgreSQLNamespace) schemaInitializingQueries() []Query {
+	if n.Schema == "" {
+		return nil
+	}
+
+	return []Query{{Query: "CREATE SCHEMA IF NOT EXISTS " + pgx.Identifier{n.Schema}.Sanitize()}}
+}
+
+// postgreSQLNamespaceProvider is implemented by the PostgreSQL schema adapters,
+// so other components (like SchemaMigrator) can create their tables in the same namespace.
+type postgreSQLNamespaceProvider interface {
+	postgreSQLNamespace() PostgreSQLNamespace
+}
+
+func (s DefaultPostgreSQLSchema) postgreSQLNamespace() PostgreSQLNamespace {
+	return s.Namespace
+}
+
+func (s PostgreSQLQueueSchema) postgreSQLNamespace() PostgreSQLNamespace {
+	return s.Namespace
+}
+
+func (s PostgreSQLSharedTableSchema) postgreSQLNamespace() PostgreSQLNamespace {
+	return s.Namespace
+}
@@ -0,0 +1,220 @@
+package sql_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/components/delay"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func TestPostgreSQLNamespace_tables(t *testing.T) {
+	t.Parallel()
+
+	namespace := sql.PostgreSQLNamespace{
+		Schema:      "events",
+		TablePrefix: "wm_",
+	}
+
+	testCases := []struct {
+		Name     string
+		Table    string
+		Expected string
+	}{
+		{
+			Name:     "default_messages",
+			Table:    sql.DefaultPostgreSQLSchema{}.MessagesTable("topic"),
+			Expected: `"watermill_topic"`,
+		},
+		{
+			Name:     "default_offsets",
+			Table:    sql.DefaultPostgreSQLOffsetsAdapter{}.MessagesOffsetsTable("topic"),
+			Expected: `"watermill_offsets_topic"`,
+		},
+		{
+			Name:     "messages",
+			Table:    sql.DefaultPostgreSQLSchema{Namespace: namespace}.MessagesTable("topic"),
+			Expected: `"events"."wm_topic"`,
+		},
+		{
+			Name:     "offsets",
+			Table:    sql.DefaultPostgreSQLOffsetsAdapter{Namespace: namespace}.MessagesOffsetsTable("topic"),
+			Expected: `"events"."wm_offsets_topic"`,
+		},
+		{
+			Name:     "prefix_only",
+			Table:    sql.DefaultPostgreSQLSchema{Namespace: sql.PostgreSQLNamespace{TablePrefix: "wm_"}}.MessagesTable("topic"),
+			Expected: `"wm_topic"`,
+		},
+		{
+			Name:     "queue_messages",
+			Table:    sql.PostgreSQLQueueOffsetsAdapter{Namespace: namespace}.MessagesTable("topic"),
+			Expected: `"events"."wm_topic"`,
+		},
+		{
+			Name:     "queue_consumer_groups",
+			Table:    sql.PostgreSQLQueueSchema{Namespace: namespace}.ConsumerGroupsTable("topic"),
+			Expected: `"events"."wm_consumer_groups_topic"`,
+		},
+		{
+			Name:     "queue_acks",
+			Table:    sql.PostgreSQLQueueOffsetsAdapter{Namespace: namespace}.AcksTable("topic"),
+			Expected: `"events"."wm_acks_topic"`,
+		},
+		{
+			Name:     "shared_messages",
+			Table:    sql.PostgreSQLSharedTableSchema{Namespace: namespace}.MessagesTable("topic"),
+			Expected: `"events"."wm_messages"`,
+		},
+		{
+			Name:     "shared_offsets",
+			Table:    sql.PostgreSQLSharedTableOffsetsAdapter{Namespace: namespace, TableName: "offsets"}.MessagesOffsetsTable("topic"),
+			Expected: `"events"."offsets"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.Expected, tc.Table)
+		})
+	}
+}
+
+func TestPostgreSQLNamespace_schema_initializing_queries(t *testing.T) {
+	t.Parallel()
+
+	queries, err := sql.DefaultPostgreSQLSchema{
+		InitializeSchemaWithoutTransaction: true,
+		Namespace:                          sql.PostgreSQLNamespace{Schema: "events"},
+	}.SchemaInitializingQueries(sql.SchemaInitializingQueriesParams{Topic: "topic"})
+	require.NoError(t, err)
+	require.NotEmpty(t, queries)
+
+	assert.Equal(t, `CREATE SCHEMA IF NOT EXISTS "events"`, queries[0].Query)
+
+	queries, err = sql.DefaultPostgreSQLSchema{
+		InitializeSchemaWithoutTransaction: true,
+		NotifyOnInsert:                     true,
+		Namespace:                          sql.PostgreSQLNamespace{Schema: "events", TablePrefix: "wm_"},
+	}.SchemaInitializingQueries(sql.SchemaInitializingQueriesParams{Topic: "topic"})
+	require.NoError(t, err)
+
+	var notifyQueries []string
+	for _, query := range queries {
+		if strings.Contains(query.Query, "notify_insert") {
+			notifyQueries = append(notifyQueries, query.Query)
+		}
+	}
+	require.Len(t, notifyQueries, 2)
+	assert.Contains(t, notifyQueries[0], `CREATE OR REPLACE FUNCTION "events"."wm_notify_insert"()`)
+	assert.Contains(t, notifyQueries[1], `EXECUTE FUNCTION "events"."wm_notify_insert"(`)
+
+	queries, err = sql.DefaultPostgreSQLSchema{
+		InitializeSchemaWithoutTransaction: true,
+	}.SchemaInitializingQueries(sql.SchemaInitializingQueriesParams{Topic: "topic"})
+	require.NoError(t, err)
+
+	for _, query := range queries {
+		assert.NotContains(t, query.Query, "CREATE SCHEMA")
+	}
+}
+
+func TestPostgreSQLNamespace(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	namespace := sql.PostgreSQLNamespace{
+		Schema:      "test_" + strings.ToLower(watermill.NewShortUUID()),
+		TablePrefix: "wm_",
+	}
+
+	schemaAdapter := sql.DefaultPostgreSQLSchema{Namespace: namespace}
+	offsetsAdapter := sql.DefaultPostgreSQLOffsetsAdapter{Namespace: namespace}
+
+	publisher, subscriber := newPubSub(t, db, "namespace", schemaAdapter, offsetsAdapter)
+
+	topic := "namespace_" + watermill.NewShortUUID()
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	require.NoError(t, publisher.Publish(topic, msg))
+
+	messages, err := subscriber.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	select {
+	case received := <-messages:
+		assert.Equal(t, msg.UUID, received.UUID)
+		received.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	rows, err := db.QueryContext(
+		context.Background(),
+		`SELECT table_name FROM information_schema.tables WHERE table_schema = $1 ORDER BY table_name`,
+		namespace.Schema,
+	)
+	require.NoError(t, err)
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		require.NoError(t, rows.Scan(&table))
+		tables = append(tables, table)
+	}
+	require.NoError(t, rows.Close())
+
+	assert.Equal(t, []string{"wm_" + topic, "wm_offsets_" + topic}, tables)
+}
+
+func TestPostgreSQLNamespace_delayed(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	namespace := sql.PostgreSQLNamespace{
+		Schema: "test_" + strings.ToLower(watermill.NewShortUUID()),
+	}
+
+	pub, err := sql.NewDelayedPostgreSQLPublisher(db, sql.DelayedPostgreSQLPublisherConfig{
+		DelayPublisherConfig: delay.PublisherConfig{
+			AllowNoDelay: true,
+		},
+		Namespace: namespace,
+		Logger:    logger,
+	})
+	require.NoError(t, err)
+
+	sub, err := sql.NewDelayedPostgreSQLSubscriber(db, sql.DelayedPostgreSQLSubscriberConfig{
+		DeleteOnAck:  true,
+		AllowNoDelay: true,
+		Namespace:    namespace,
+		Logger:       logger,
+	})
+	require.NoError(t, err)
+
+	topic := watermill.NewUUID()
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+	require.NoError(t, pub.Publish(topic, msg))
+
+	select {
+	case received := <-messages:
+		assert.Equal(t, msg.UUID, received.UUID)
+		received.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
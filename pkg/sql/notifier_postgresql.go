@@ -0,0 +1,212 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// Notifier wakes up the Subscriber when new messages may be available, so it doesn't need to wait for PollInterval.
+type Notifier interface {
+	// Listen returns a channel which receives a value every time new messages may be available in the topic.
+	// Notifications may be spurious or coalesced, the Subscriber always queries for messages after receiving one.
+	//
+	// The channel should be closed when ctx is done.
+	// When the channel is closed earlier, the Subscriber falls back to polling.
+	Listen(ctx context.Context, topic string) (<-chan struct{}, error)
+}
+
+// postgreSQLNotifyChannelMaxLength is the maximum length of the channel name (NAMEDATALEN - 1).
+const postgreSQLNotifyChannelMaxLength = 63
+
+// DefaultPostgreSQLNotifyChannel returns the LISTEN/NOTIFY channel used for the topic
+// by DefaultPostgreSQLSchema and PostgreSQLQueueSchema when NotifyOnInsert is enabled.
+func DefaultPostgreSQLNotifyChannel(topic string) string {
+	channel := "watermill_" + topic
+	if len(channel) <= postgreSQLNotifyChannelMaxLength {
+		return channel
+	}
+
+	// PostgreSQL rejects longer channel names, so the topic is replaced with its hash
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(topic))
+
+	return fmt.Sprintf("watermill_%x", h.Sum64())
+}
+
+// postgreSQLNotifyOnInsertQueries returns queries creating a trigger which sends a notification
+// to the topic's channel after each INSERT statement.
+// The notification is delivered to listeners when the inserting transaction commits.
+//
+// The trigger function is created in the namespace, so adapters in different namespaces don't replace
+// each other's function.
+func postgreSQLNotifyOnInsertQueries(namespace PostgreSQLNamespace, table string, topic string) []Query {
+	function := namespace.table("notify_insert")
+
+	createFunction := `
+		CREATE OR REPLACE FUNCTION ` + function + `() RETURNS trigger AS $$
+		BEGIN
+			PERFORM pg_notify(TG_ARGV[0], '');
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+	`
+
+	// topic is validated with validateTopicName, so it's safe to use it as a string literal
+	createTrigger := `
+		CREATE OR REPLACE TRIGGER watermill_notify_insert
+		AFTER INSERT ON ` + table + `
+		FOR EACH STATEMENT EXECUTE FUNCTION ` + function + `('` + DefaultPostgreSQLNotifyChannel(topic) + `');
+	`
+
+	return []Query{{Query: createFunction}, {Query: createTrigger}}
+}
+
+type PostgreSQLNotifierConfig struct {
+	// Connect opens a new connection used for LISTEN. Required.
+	// Each subscribed topic uses a separate connection, which is closed by the notifier.
+	// The connection must not be shared, so it can't be acquired from a pool.
+	Connect func(ctx context.Context) (*pgx.Conn, error)
+
+	// GenerateChannelName may be used to override the channel name listened to for the topic.
+	// It must match the channel used by the schema adapter. Defaults to DefaultPostgreSQLNotifyChannel.
+	GenerateChannelName func(topic string) string
+
+	// ReconnectInterval is the time to wait before reconnecting after the listen connection drops.
+	// The Subscriber falls back to polling until the connection is restored.
+	// Must be non-negative. Defaults to 1s.
+	ReconnectInterval time.Duration
+}
+
+func (c *PostgreSQLNotifierConfig) setDefaults() {
+	if c.GenerateChannelName == nil {
+		c.GenerateChannelName = DefaultPostgreSQLNotifyChannel
+	}
+	if c.ReconnectInterval == 0 {
+		c.ReconnectInterval = time.Second
+	}
+}
+
+func (c PostgreSQLNotifierConfig) validate() error {
+	if c.Connect == nil {
+		return errors.New("connect is nil")
+	}
+	if c.ReconnectInterval < 0 {
+		return errors.New("reconnect interval must be a positive duration")
+	}
+
+	return nil
+}
+
+// PostgreSQLNotifier is a Notifier based on PostgreSQL LISTEN/NOTIFY.
+//
+// It requires enabling NotifyOnInsert in DefaultPostgreSQLSchema or PostgreSQLQueueSchema.
+type PostgreSQLNotifier struct {
+	config PostgreSQLNotifierConfig
+	logger watermill.LoggerAdapter
+}
+
+func NewPostgreSQLNotifier(config PostgreSQLNotifierConfig, logger watermill.LoggerAdapter) (*PostgreSQLNotifier, error) {
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	return &PostgreSQLNotifier{
+		config: config,
+		logger: logger,
+	}, nil
+}
+
+func (n *PostgreSQLNotifier) Listen(ctx context.Context, topic string) (<-chan struct{}, error) {
+	if err := validateTopicName(topic); err != nil {
+		return nil, err
+	}
+
+	channel := n.config.GenerateChannelName(topic)
+	logger := n.logger.With(watermill.LogFields{
+		"topic":   topic,
+		"channel": channel,
+	})
+
+	// buffered, so notifications received during querying are not lost
+	out := make(chan struct{}, 1)
+
+	go func() {
+		defer close(out)
+
+		for {
+			err := n.listen(ctx, channel, out, logger)
+			if ctx.Err() != nil {
+				return
+			}
+
+			logger.Error("Listen connection dropped, reconnecting", err, watermill.LogFields{
+				"wait_time": n.config.ReconnectInterval,
+			})
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(n.config.ReconnectInterval):
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (n *PostgreSQLNotifier) listen(
+	ctx context.Context,
+	channel string,
+	out chan struct{},
+	logger watermill.LoggerAdapter,
+) error {
+	conn, err := n.config.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("could not connect: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(context.WithoutCancel(ctx)); err != nil {
+			logger.Error("Could not close listen connection", err, nil)
+		}
+	}()
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	if err != nil {
+		return fmt.Errorf("could not listen: %w", err)
+	}
+
+	logger.Debug("Listening for notifications", nil)
+
+	// messages may have been inserted while the connection was down
+	notify(out)
+
+	for {
+		_, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("could not wait for notification: %w", err)
+		}
+
+		logger.Trace("Received notification", nil)
+		notify(out)
+	}
+}
+
+func notify(out chan struct{}) {
+	select {
+	case out <- struct{}{}:
+	default:
+		// there is a pending notification already
+	}
+}
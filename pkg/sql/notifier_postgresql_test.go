@@ -0,0 +1,123 @@
+package sql_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func newPostgreSQLNotifier(t *testing.T) *sql.PostgreSQLNotifier {
+	addr := os.Getenv("WATERMILL_TEST_POSTGRES_HOST")
+	if addr == "" {
+		addr = "localhost"
+	}
+
+	connStr := fmt.Sprintf("postgres://watermill:password@%s/watermill?sslmode=disable", addr)
+
+	notifier, err := sql.NewPostgreSQLNotifier(sql.PostgreSQLNotifierConfig{
+		Connect: func(ctx context.Context) (*pgx.Conn, error) {
+			return pgx.Connect(ctx, connStr)
+		},
+	}, logger)
+	require.NoError(t, err)
+
+	return notifier
+}
+
+func TestPostgreSQLNotifier(t *testing.T) {
+	t.Parallel()
+
+	// the trigger function is created in the schema of the namespace
+	namespace := sql.PostgreSQLNamespace{Schema: "test_" + strings.ToLower(watermill.NewShortUUID())}
+
+	testCases := []struct {
+		Name           string
+		SchemaAdapter  sql.SchemaAdapter
+		OffsetsAdapter sql.OffsetsAdapter
+	}{
+		{
+			Name: "default",
+			SchemaAdapter: sql.DefaultPostgreSQLSchema{
+				NotifyOnInsert: true,
+			},
+			OffsetsAdapter: sql.DefaultPostgreSQLOffsetsAdapter{},
+		},
+		{
+			Name: "queue",
+			SchemaAdapter: sql.PostgreSQLQueueSchema{
+				NotifyOnInsert: true,
+			},
+			OffsetsAdapter: sql.PostgreSQLQueueOffsetsAdapter{},
+		},
+		{
+			Name: "namespace",
+			SchemaAdapter: sql.DefaultPostgreSQLSchema{
+				NotifyOnInsert: true,
+				Namespace:      namespace,
+			},
+			OffsetsAdapter: sql.DefaultPostgreSQLOffsetsAdapter{
+				Namespace: namespace,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newPostgreSQL(t)
+
+			pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+				SchemaAdapter:        tc.SchemaAdapter,
+				AutoInitializeSchema: true,
+			}, logger)
+			require.NoError(t, err)
+
+			sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+				// without notifications, no message would be received during the test
+				PollInterval:     time.Hour,
+				SchemaAdapter:    tc.SchemaAdapter,
+				OffsetsAdapter:   tc.OffsetsAdapter,
+				InitializeSchema: true,
+				Notifier:         newPostgreSQLNotifier(t),
+			}, logger)
+			require.NoError(t, err)
+
+			topic := "notify_" + watermill.NewUUID()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			messages, err := sub.Subscribe(ctx, topic)
+			require.NoError(t, err)
+
+			// wait for the subscriber to go to sleep after the initial query
+			time.Sleep(time.Millisecond * 500)
+
+			for i := 0; i < 3; i++ {
+				msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+				require.NoError(t, pub.Publish(topic, msg))
+
+				select {
+				case received := <-messages:
+					require.Equal(t, msg.UUID, received.UUID)
+					received.Ack()
+				case <-time.After(time.Second * 5):
+					t.Fatal("message not received, subscriber was not notified")
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,10 @@ type AckMessageQueryParams struct {
 	LastRow       Row
 	Rows          []Row
 	ConsumerGroup string
+
+	// ConsumerULID is set when the message was leased (see LeasesMessages).
+	// The message must be acked only if it's still leased by the consumer.
+	ConsumerULID []byte
 }
 
 type ConsumedMessageQueryParams struct {
@@ -14,6 +18,20 @@ type ConsumedMessageQueryParams struct {
 	ConsumerULID  []byte
 }
 
+type NotAckedMessageQueryParams struct {
+	Topic         string
+	Row           Row
+	ConsumerGroup string
+
+	// Reason describes why the message was not acked, for example "message nacked" or "ack deadline exceeded".
+	// It's empty if the message was not delivered at all (for example, when the subscriber is closing).
+	Reason string
+
+	// ConsumerULID is set when the message was leased (see LeasesMessages).
+	// The lease must be released only if it's still held by the consumer.
+	ConsumerULID []byte
+}
+
 type NextOffsetQueryParams struct {
 	Topic         string
 	ConsumerGroup string
@@ -21,6 +39,9 @@ type NextOffsetQueryParams struct {
 
 type OffsetsSchemaInitializingQueriesParams struct {
 	Topic string
+
+	// ConsumerGroup is the consumer group of the subscriber initializing the schema.
+	ConsumerGroup string
 }
 
 type BeforeSubscribingQueriesParams struct {
@@ -49,3 +70,35 @@ type OffsetsAdapter interface {
 	// All queries will be executed in a single transaction.
 	BeforeSubscribingQueries(params BeforeSubscribingQueriesParams) ([]Query, error)
 }
+
+// NotAckedMessageQuerier may be implemented by OffsetsAdapter to store information about failed deliveries.
+type NotAckedMessageQuerier interface {
+	// NotAckedMessageQuery returns the SQL query and arguments which will be executed when a message is nacked
+	// or not acked within the ack deadline. It's executed in the same transaction as ConsumedMessageQuery.
+	//
+	// The query will be not executed if it's empty.
+	NotAckedMessageQuery(params NotAckedMessageQueryParams) (Query, error)
+}
+
+type DeliveryAttemptQueryParams struct {
+	Topic         string
+	Row           Row
+	ConsumerGroup string
+}
+
+// DeliveryAttemptQuerier may be implemented by OffsetsAdapter to persist delivery attempts
+// outside of the consuming transaction, so they are counted even if the transaction is rolled back
+// (for example, when the subscriber crashes while processing the message).
+//
+// When it's implemented, a nacked message is not resent in the same transaction.
+// The transaction is committed after ResendInterval, and the message is selected again,
+// so each delivery executes DeliveryAttemptQuery.
+type DeliveryAttemptQuerier interface {
+	// DeliveryAttemptQuery returns the SQL query and arguments which record a delivery attempt of the message.
+	// The query must return a single row with the number of attempts recorded since the attempts
+	// of Row (see Row.ExtraData["delivery_attempts"]) were persisted, including the current one.
+	//
+	// It's executed before each delivery using a separate database connection,
+	// while the message is locked by the consuming transaction.
+	DeliveryAttemptQuery(params DeliveryAttemptQueryParams) (Query, error)
+}
@@ -32,6 +32,18 @@ func (a DefaultMySQLOffsetsAdapter) SchemaInitializingQueries(params OffsetsSche
 	}, nil
 }
 
+// SchemaMigrations returns migrations of the offsets table, see SchemaMigrator.
+func (a DefaultMySQLOffsetsAdapter) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := a.SchemaInitializingQueries(OffsetsSchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create offsets table", Queries: createQueries},
+	}, nil
+}
+
 func (a DefaultMySQLOffsetsAdapter) AckMessageQuery(params AckMessageQueryParams) (Query, error) {
 	ackQuery := `INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + ` (offset_consumed, offset_acked, consumer_group)
 		VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE offset_consumed=VALUES(offset_consumed), offset_acked=VALUES(offset_acked)`
@@ -67,3 +79,59 @@ func (a DefaultMySQLOffsetsAdapter) ConsumedMessageQuery(params ConsumedMessageQ
 func (a DefaultMySQLOffsetsAdapter) BeforeSubscribingQueries(params BeforeSubscribingQueriesParams) ([]Query, error) {
 	return nil, nil
 }
+
+func (a DefaultMySQLOffsetsAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectMySQL,
+		ConsumerGroups: true,
+	}
+}
+
+func (a DefaultMySQLOffsetsAdapter) ConsumerGroupsQuery(params ConsumerGroupsQueryParams) (Query, error) {
+	return Query{
+		Query: `
+			SELECT
+				o.consumer_group,
+				COALESCE(o.offset_acked, 0),
+				NULL,
+				(SELECT COUNT(*) FROM ` + params.MessagesTable + " m WHERE m.`offset` > COALESCE(o.offset_acked, 0))" + `
+			FROM ` + a.MessagesOffsetsTable(params.Topic) + ` o
+			ORDER BY o.consumer_group
+		`,
+	}, nil
+}
+
+func (a DefaultMySQLOffsetsAdapter) MessageExistsQuery(params MessageExistsQueryParams) (Query, error) {
+	return Query{
+		Query: `SELECT EXISTS (SELECT 1 FROM ` + params.MessagesTable + ` WHERE uuid = ?)`,
+		Args:  []any{params.MessageUUID},
+	}, nil
+}
+
+func (a DefaultMySQLOffsetsAdapter) SeekQuery(params SeekQueryParams) (Query, error) {
+	args := []any{params.ConsumerGroup}
+
+	// position is the offset of the last message before the next message to consume
+	var position string
+	switch params.SeekTo {
+	case SeekToEarliest:
+		position = "SELECT 0 AS `offset`"
+	case SeekToLatest:
+		position = "SELECT `offset` FROM " + params.MessagesTable + " ORDER BY `offset` DESC LIMIT 1"
+	case SeekToTimestamp:
+		args = append(args, params.Timestamp)
+		position = "SELECT `offset` FROM " + params.MessagesTable + " WHERE `created_at` < ? ORDER BY `offset` DESC LIMIT 1"
+	case SeekToMessageUUID:
+		args = append(args, params.MessageUUID)
+		position = "SELECT `offset` - 1 AS `offset` FROM " + params.MessagesTable + " WHERE `uuid` = ? ORDER BY `offset` ASC LIMIT 1"
+	default:
+		return Query{}, fmt.Errorf("unknown seek position: %d", params.SeekTo)
+	}
+
+	seekQuery := `INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + ` (consumer_group, offset_acked, offset_consumed)
+		SELECT ?, COALESCE(p.` + "`offset`" + `, 0), COALESCE(p.` + "`offset`" + `, 0)
+		FROM (SELECT 1) AS d LEFT JOIN (` + position + `) AS p ON TRUE
+		ON DUPLICATE KEY UPDATE offset_acked=VALUES(offset_acked), offset_consumed=VALUES(offset_consumed)`
+
+	return Query{seekQuery, args}, nil
+}
@@ -16,19 +16,41 @@ import (
 type DefaultPostgreSQLOffsetsAdapter struct {
 	// GenerateMessagesOffsetsTableName may be used to override how the messages/offsets table name is generated.
 	GenerateMessagesOffsetsTableName func(topic string) string
+
+	// Namespace sets the PostgreSQL schema and the prefix of the default table names.
+	// It should be the same as Namespace of the schema adapter.
+	Namespace PostgreSQLNamespace
 }
 
 func (a DefaultPostgreSQLOffsetsAdapter) SchemaInitializingQueries(params OffsetsSchemaInitializingQueriesParams) ([]Query, error) {
-	return []Query{
-		{
-			Query: `
+	return append(a.Namespace.schemaInitializingQueries(), Query{
+		Query: `
 				CREATE TABLE IF NOT EXISTS ` + a.MessagesOffsetsTable(params.Topic) + ` (
 				consumer_group VARCHAR(255) NOT NULL,
 				offset_acked BIGINT,
 				last_processed_transaction_id xid8 NOT NULL,
 				PRIMARY KEY(consumer_group)
 			)`,
-		},
+	}), nil
+}
+
+// SchemaMigrations returns migrations of the offsets table, see SchemaMigrator.
+func (a DefaultPostgreSQLOffsetsAdapter) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := a.SchemaInitializingQueries(OffsetsSchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	// offsets acked before transaction_id was added point to messages with transaction_id 0,
+	// see DefaultPostgreSQLSchema.SchemaMigrations
+	addLastProcessedTransactionID := `
+		ALTER TABLE ` + a.MessagesOffsetsTable(topic) + `
+			ADD COLUMN IF NOT EXISTS last_processed_transaction_id xid8 NOT NULL DEFAULT '0';
+	`
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create offsets table", Queries: createQueries},
+		{Version: 2, Description: "add last_processed_transaction_id column", Queries: []Query{{Query: addLastProcessedTransactionID}}},
 	}, nil
 }
 
@@ -63,7 +85,7 @@ func (a DefaultPostgreSQLOffsetsAdapter) MessagesOffsetsTable(topic string) stri
 	if a.GenerateMessagesOffsetsTableName != nil {
 		return a.GenerateMessagesOffsetsTableName(topic)
 	}
-	return fmt.Sprintf(`"watermill_offsets_%s"`, topic)
+	return a.Namespace.table("offsets_" + topic)
 }
 
 func (a DefaultPostgreSQLOffsetsAdapter) ConsumedMessageQuery(params ConsumedMessageQueryParams) (Query, error) {
@@ -86,3 +108,83 @@ func (a DefaultPostgreSQLOffsetsAdapter) BeforeSubscribingQueries(params BeforeS
 		},
 	}, nil
 }
+
+func (a DefaultPostgreSQLOffsetsAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectPostgreSQL,
+		ConsumerGroups: true,
+	}
+}
+
+func (a DefaultPostgreSQLOffsetsAdapter) ConsumerGroupsQuery(params ConsumerGroupsQueryParams) (Query, error) {
+	// lag is counted in the same way as SelectQuery selects messages, messages from not committed
+	// transactions are not counted
+	return Query{
+		Query: `
+			SELECT
+				o.consumer_group,
+				COALESCE(o.offset_acked, 0),
+				o.last_processed_transaction_id::text,
+				(
+					SELECT COUNT(*) FROM ` + params.MessagesTable + ` m
+					WHERE
+						(m.transaction_id, m."offset") > (o.last_processed_transaction_id, COALESCE(o.offset_acked, 0))
+						AND m.transaction_id < pg_snapshot_xmin(pg_current_snapshot())
+				)
+			FROM ` + a.MessagesOffsetsTable(params.Topic) + ` o
+			ORDER BY o.consumer_group
+		`,
+	}, nil
+}
+
+func (a DefaultPostgreSQLOffsetsAdapter) MessageExistsQuery(params MessageExistsQueryParams) (Query, error) {
+	return Query{
+		Query: `SELECT EXISTS (SELECT 1 FROM ` + params.MessagesTable + ` WHERE uuid = $1)`,
+		Args:  []any{params.MessageUUID},
+	}, nil
+}
+
+func (a DefaultPostgreSQLOffsetsAdapter) SeekQuery(params SeekQueryParams) (Query, error) {
+	args := []any{params.ConsumerGroup}
+
+	// position is the last message before the next message to consume
+	var position string
+	switch params.SeekTo {
+	case SeekToEarliest:
+		position = `SELECT 0 AS "offset", '0'::xid8 AS transaction_id`
+	case SeekToLatest:
+		position = `
+			SELECT "offset", transaction_id FROM ` + params.MessagesTable + `
+			WHERE transaction_id < pg_snapshot_xmin(pg_current_snapshot())
+			ORDER BY transaction_id DESC, "offset" DESC
+			LIMIT 1`
+	case SeekToTimestamp:
+		args = append(args, params.Timestamp)
+		position = `
+			SELECT "offset", transaction_id FROM ` + params.MessagesTable + `
+			WHERE created_at < $2::timestamptz
+			ORDER BY transaction_id DESC, "offset" DESC
+			LIMIT 1`
+	case SeekToMessageUUID:
+		args = append(args, params.MessageUUID)
+		position = `
+			SELECT "offset" - 1 AS "offset", transaction_id FROM ` + params.MessagesTable + `
+			WHERE uuid = $2
+			ORDER BY transaction_id ASC, "offset" ASC
+			LIMIT 1`
+	default:
+		return Query{}, fmt.Errorf("unknown seek position: %d", params.SeekTo)
+	}
+
+	seekQuery := `
+		INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + ` (consumer_group, offset_acked, last_processed_transaction_id)
+		SELECT $1, COALESCE(p."offset", 0), COALESCE(p.transaction_id, '0')
+		FROM (SELECT 1) AS d LEFT JOIN (` + position + `) AS p ON TRUE
+		ON CONFLICT
+			(consumer_group)
+		DO UPDATE SET
+			offset_acked = excluded.offset_acked,
+			last_processed_transaction_id = excluded.last_processed_transaction_id`
+
+	return Query{seekQuery, args}, nil
+}
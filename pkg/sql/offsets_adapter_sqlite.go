@@ -0,0 +1,142 @@
+package sql
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultSQLiteOffsetsAdapter is adapter for storing offsets for SQLite databases.
+//
+// DefaultSQLiteOffsetsAdapter is designed to support multiple subscribers with exactly once delivery
+// and guaranteed order.
+//
+// SQLite has no row-level locks, so there is no FOR UPDATE in NextOffsetQuery.
+// Instead, ConsumedMessageQuery writes to the offsets table, which takes the database write lock.
+// When another consumer is trying to consume the same message, it fails with "database is locked"
+// (or waits for the lock when transactions are started with an immediate lock).
+// After that, consumer will consume next message.
+type DefaultSQLiteOffsetsAdapter struct {
+	// GenerateMessagesOffsetsTableName may be used to override how the messages/offsets table name is generated.
+	GenerateMessagesOffsetsTableName func(topic string) string
+}
+
+func (a DefaultSQLiteOffsetsAdapter) SchemaInitializingQueries(params OffsetsSchemaInitializingQueriesParams) ([]Query, error) {
+	return []Query{
+		{
+			Query: `
+				CREATE TABLE IF NOT EXISTS ` + a.MessagesOffsetsTable(params.Topic) + ` (
+				consumer_group TEXT NOT NULL,
+				offset_acked INTEGER,
+				offset_consumed INTEGER NOT NULL,
+				PRIMARY KEY(consumer_group)
+			)`,
+		},
+	}, nil
+}
+
+// SchemaMigrations returns migrations of the offsets table, see SchemaMigrator.
+func (a DefaultSQLiteOffsetsAdapter) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := a.SchemaInitializingQueries(OffsetsSchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create offsets table", Queries: createQueries},
+	}, nil
+}
+
+func (a DefaultSQLiteOffsetsAdapter) AckMessageQuery(params AckMessageQueryParams) (Query, error) {
+	ackQuery := `INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + ` (offset_consumed, offset_acked, consumer_group)
+		VALUES (?, ?, ?) ON CONFLICT(consumer_group) DO UPDATE SET offset_consumed=excluded.offset_consumed, offset_acked=excluded.offset_acked`
+
+	return Query{ackQuery, []any{params.LastRow.Offset, params.LastRow.Offset, params.ConsumerGroup}}, nil
+}
+
+func (a DefaultSQLiteOffsetsAdapter) NextOffsetQuery(params NextOffsetQueryParams) (Query, error) {
+	return Query{
+		Query: `SELECT COALESCE(
+				(SELECT offset_acked
+				 FROM ` + a.MessagesOffsetsTable(params.Topic) + `
+				 WHERE consumer_group=?
+				), 0)`,
+		Args: []any{params.ConsumerGroup},
+	}, nil
+}
+
+func (a DefaultSQLiteOffsetsAdapter) MessagesOffsetsTable(topic string) string {
+	if a.GenerateMessagesOffsetsTableName != nil {
+		return a.GenerateMessagesOffsetsTableName(topic)
+	}
+	return fmt.Sprintf(`"watermill_offsets_%s"`, topic)
+}
+
+func (a DefaultSQLiteOffsetsAdapter) ConsumedMessageQuery(params ConsumedMessageQueryParams) (Query, error) {
+	// offset_consumed is not queried anywhere, it's used only to take the write lock before the message is delivered.
+	consumedQuery := `INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + ` (offset_consumed, consumer_group)
+		VALUES (?, ?) ON CONFLICT(consumer_group) DO UPDATE SET offset_consumed=excluded.offset_consumed`
+	return Query{consumedQuery, []any{params.Row.Offset, params.ConsumerGroup}}, nil
+}
+
+func (a DefaultSQLiteOffsetsAdapter) BeforeSubscribingQueries(params BeforeSubscribingQueriesParams) ([]Query, error) {
+	return nil, nil
+}
+
+func (a DefaultSQLiteOffsetsAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectSQLite,
+		ConsumerGroups: true,
+	}
+}
+
+func (a DefaultSQLiteOffsetsAdapter) ConsumerGroupsQuery(params ConsumerGroupsQueryParams) (Query, error) {
+	return Query{
+		Query: `
+			SELECT
+				o.consumer_group,
+				COALESCE(o.offset_acked, 0),
+				NULL,
+				(SELECT COUNT(*) FROM ` + params.MessagesTable + ` m WHERE m."offset" > COALESCE(o.offset_acked, 0))
+			FROM ` + a.MessagesOffsetsTable(params.Topic) + ` o
+			ORDER BY o.consumer_group
+		`,
+	}, nil
+}
+
+func (a DefaultSQLiteOffsetsAdapter) MessageExistsQuery(params MessageExistsQueryParams) (Query, error) {
+	return Query{
+		Query: `SELECT EXISTS (SELECT 1 FROM ` + params.MessagesTable + ` WHERE "uuid" = ?)`,
+		Args:  []any{params.MessageUUID},
+	}, nil
+}
+
+func (a DefaultSQLiteOffsetsAdapter) SeekQuery(params SeekQueryParams) (Query, error) {
+	args := []any{params.ConsumerGroup}
+
+	// position is the offset of the last message before the next message to consume
+	var position string
+	switch params.SeekTo {
+	case SeekToEarliest:
+		position = `SELECT 0 AS "offset"`
+	case SeekToLatest:
+		position = `SELECT "offset" FROM ` + params.MessagesTable + ` ORDER BY "offset" DESC LIMIT 1`
+	case SeekToTimestamp:
+		// created_at is stored as text in UTC by CURRENT_TIMESTAMP
+		args = append(args, params.Timestamp.UTC().Format(time.DateTime))
+		position = `SELECT "offset" FROM ` + params.MessagesTable + ` WHERE "created_at" < ? ORDER BY "offset" DESC LIMIT 1`
+	case SeekToMessageUUID:
+		args = append(args, params.MessageUUID)
+		position = `SELECT "offset" - 1 AS "offset" FROM ` + params.MessagesTable + ` WHERE "uuid" = ? ORDER BY "offset" ASC LIMIT 1`
+	default:
+		return Query{}, fmt.Errorf("unknown seek position: %d", params.SeekTo)
+	}
+
+	// WHERE TRUE is required by SQLite to parse ON CONFLICT after SELECT
+	seekQuery := `INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + ` (consumer_group, offset_acked, offset_consumed)
+		SELECT ?, COALESCE(p."offset", 0), COALESCE(p."offset", 0)
+		FROM (SELECT 1) AS d LEFT JOIN (` + position + `) AS p ON TRUE
+		WHERE TRUE
+		ON CONFLICT(consumer_group) DO UPDATE SET offset_acked=excluded.offset_acked, offset_consumed=excluded.offset_consumed`
+
+	return Query{seekQuery, args}, nil
+}
@@ -0,0 +1,260 @@
+package sql
+
+import (
+	"context"
+	stdSQL "database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrMessageNotFound is returned by OffsetsAdmin.SeekToMessage when there is no message with the UUID in the topic.
+var ErrMessageNotFound = errors.New("message not found")
+
+// SeekTo is the position to which OffsetsAdmin moves a consumer group.
+type SeekTo int
+
+const (
+	// SeekToEarliest moves the consumer group to the first message in the topic.
+	SeekToEarliest SeekTo = iota + 1
+	// SeekToLatest moves the consumer group after the last message in the topic.
+	SeekToLatest
+	// SeekToTimestamp moves the consumer group to the first message created at or after SeekQueryParams.Timestamp.
+	SeekToTimestamp
+	// SeekToMessageUUID moves the consumer group to the message with SeekQueryParams.MessageUUID.
+	SeekToMessageUUID
+)
+
+type ConsumerGroupsQueryParams struct {
+	Topic         string
+	MessagesTable string
+}
+
+type SeekQueryParams struct {
+	Topic         string
+	MessagesTable string
+	ConsumerGroup string
+
+	SeekTo SeekTo
+
+	// Timestamp is used with SeekToTimestamp.
+	Timestamp time.Time
+
+	// MessageUUID is used with SeekToMessageUUID.
+	MessageUUID string
+}
+
+type MessageExistsQueryParams struct {
+	Topic         string
+	MessagesTable string
+	MessageUUID   string
+}
+
+// OffsetsAdminQuerier may be implemented by OffsetsAdapter to support OffsetsAdmin.
+type OffsetsAdminQuerier interface {
+	// ConsumerGroupsQuery returns the SQL query and arguments listing consumer groups of the topic.
+	// Each row contains the consumer group, offset_acked, last_processed_transaction_id (NULL if not used by the adapter),
+	// and the number of messages not acked by the consumer group yet.
+	ConsumerGroupsQuery(params ConsumerGroupsQueryParams) (Query, error)
+
+	// MessageExistsQuery returns the SQL query and arguments returning a single boolean row,
+	// which is true if the message with MessageUUID exists.
+	MessageExistsQuery(params MessageExistsQueryParams) (Query, error)
+
+	// SeekQuery returns the SQL query and arguments moving the consumer group to the position.
+	// The consumer group is created if it doesn't exist.
+	SeekQuery(params SeekQueryParams) (Query, error)
+}
+
+// messagesTableProvider is implemented by the default schema adapters.
+type messagesTableProvider interface {
+	MessagesTable(topic string) string
+}
+
+// ConsumerGroupOffsets is the position of a consumer group in a topic.
+type ConsumerGroupOffsets struct {
+	ConsumerGroup string
+
+	// OffsetAcked is the offset of the last acked message.
+	OffsetAcked int64
+
+	// LastProcessedTransactionID is the transaction ID of the last acked message.
+	// It's used only by DefaultPostgreSQLOffsetsAdapter.
+	LastProcessedTransactionID XID8
+
+	// Lag is the number of messages not acked by the consumer group yet.
+	Lag int64
+}
+
+// OffsetsAdmin inspects and moves positions of consumer groups.
+//
+// Moving a consumer group while its subscribers are running is safe,
+// but the messages being processed are still acked after the move.
+type OffsetsAdmin struct {
+	db             ContextExecutor
+	schemaAdapter  messagesTableProvider
+	offsetsAdapter OffsetsAdminQuerier
+
+	// topicAdapters are the adapters validating topic names, see validateTopic
+	topicAdapters []any
+}
+
+// NewOffsetsAdmin creates OffsetsAdmin. The adapters must be the same as used by subscribers.
+// The offsets adapter must implement OffsetsAdminQuerier,
+// like DefaultPostgreSQLOffsetsAdapter or DefaultMySQLOffsetsAdapter.
+func NewOffsetsAdmin(db ContextExecutor, schemaAdapter SchemaAdapter, offsetsAdapter OffsetsAdapter) (*OffsetsAdmin, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	messagesTable, ok := schemaAdapter.(messagesTableProvider)
+	if !ok {
+		return nil, fmt.Errorf("schema adapter %T doesn't provide the messages table", schemaAdapter)
+	}
+
+	querier, ok := offsetsAdapter.(OffsetsAdminQuerier)
+	if !ok {
+		return nil, fmt.Errorf("offsets adapter %T doesn't support offsets administration", offsetsAdapter)
+	}
+
+	return &OffsetsAdmin{
+		db:             db,
+		schemaAdapter:  messagesTable,
+		offsetsAdapter: querier,
+		topicAdapters:  []any{schemaAdapter, offsetsAdapter},
+	}, nil
+}
+
+// ConsumerGroups returns positions of all consumer groups of the topic, ordered by the consumer group.
+func (a *OffsetsAdmin) ConsumerGroups(ctx context.Context, topic string) ([]ConsumerGroupOffsets, error) {
+	if err := validateTopic(topic, a.topicAdapters...); err != nil {
+		return nil, err
+	}
+
+	query, err := a.offsetsAdapter.ConsumerGroupsQuery(ConsumerGroupsQueryParams{
+		Topic:         topic,
+		MessagesTable: a.schemaAdapter.MessagesTable(topic),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not generate consumer groups query: %w", err)
+	}
+
+	rows, err := a.db.QueryContext(ctx, query.Query, query.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query consumer groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []ConsumerGroupOffsets
+	for rows.Next() {
+		var group ConsumerGroupOffsets
+		var transactionID stdSQL.NullString
+
+		if err := rows.Scan(&group.ConsumerGroup, &group.OffsetAcked, &transactionID, &group.Lag); err != nil {
+			return nil, fmt.Errorf("could not scan consumer group: %w", err)
+		}
+
+		if transactionID.Valid {
+			if err := group.LastProcessedTransactionID.Scan(transactionID.String); err != nil {
+				return nil, fmt.Errorf("could not scan transaction id: %w", err)
+			}
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// ResetToEarliest moves the consumer group to the first message in the topic.
+func (a *OffsetsAdmin) ResetToEarliest(ctx context.Context, topic string, consumerGroup string) error {
+	return a.seek(ctx, SeekQueryParams{
+		Topic:         topic,
+		ConsumerGroup: consumerGroup,
+		SeekTo:        SeekToEarliest,
+	})
+}
+
+// ResetToLatest moves the consumer group after the last message in the topic,
+// so only messages published later are consumed.
+func (a *OffsetsAdmin) ResetToLatest(ctx context.Context, topic string, consumerGroup string) error {
+	return a.seek(ctx, SeekQueryParams{
+		Topic:         topic,
+		ConsumerGroup: consumerGroup,
+		SeekTo:        SeekToLatest,
+	})
+}
+
+// SeekToTimestamp moves the consumer group to the first message created at or after timestamp.
+// The order of messages is approximate, as messages are ordered by offset, not by the creation time.
+func (a *OffsetsAdmin) SeekToTimestamp(ctx context.Context, topic string, consumerGroup string, timestamp time.Time) error {
+	return a.seek(ctx, SeekQueryParams{
+		Topic:         topic,
+		ConsumerGroup: consumerGroup,
+		SeekTo:        SeekToTimestamp,
+		Timestamp:     timestamp,
+	})
+}
+
+// SeekToMessage moves the consumer group to the message with messageUUID, so it's the next consumed message.
+// It returns ErrMessageNotFound if there is no such message.
+func (a *OffsetsAdmin) SeekToMessage(ctx context.Context, topic string, consumerGroup string, messageUUID string) error {
+	if err := validateTopic(topic, a.topicAdapters...); err != nil {
+		return err
+	}
+
+	query, err := a.offsetsAdapter.MessageExistsQuery(MessageExistsQueryParams{
+		Topic:         topic,
+		MessagesTable: a.schemaAdapter.MessagesTable(topic),
+		MessageUUID:   messageUUID,
+	})
+	if err != nil {
+		return fmt.Errorf("could not generate message exists query: %w", err)
+	}
+
+	rows, err := a.db.QueryContext(ctx, query.Query, query.Args...)
+	if err != nil {
+		return fmt.Errorf("could not query message: %w", err)
+	}
+
+	var exists bool
+	if rows.Next() {
+		err = rows.Scan(&exists)
+	}
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not scan message: %w", err)
+	}
+
+	if !exists {
+		return ErrMessageNotFound
+	}
+
+	return a.seek(ctx, SeekQueryParams{
+		Topic:         topic,
+		ConsumerGroup: consumerGroup,
+		SeekTo:        SeekToMessageUUID,
+		MessageUUID:   messageUUID,
+	})
+}
+
+func (a *OffsetsAdmin) seek(ctx context.Context, params SeekQueryParams) error {
+	if err := validateTopic(params.Topic, a.topicAdapters...); err != nil {
+		return err
+	}
+
+	params.MessagesTable = a.schemaAdapter.MessagesTable(params.Topic)
+
+	query, err := a.offsetsAdapter.SeekQuery(params)
+	if err != nil {
+		return fmt.Errorf("could not generate seek query: %w", err)
+	}
+
+	if _, err := a.db.ExecContext(ctx, query.Query, query.Args...); err != nil {
+		return fmt.Errorf("could not move consumer group %s: %w", params.ConsumerGroup, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package sql_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+type messagesTableSchemaAdapter interface {
+	sql.SchemaAdapter
+	MessagesTable(topic string) string
+}
+
+func TestOffsetsAdmin(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name           string
+		DB             func(t *testing.T) sql.Beginner
+		SchemaAdapter  messagesTableSchemaAdapter
+		OffsetsAdapter sql.OffsetsAdapter
+
+		// AgeQuery moves created_at of the first 5 messages 2 hours back.
+		AgeQuery func(table string) string
+	}{
+		{
+			Name:           "sqlite",
+			DB:             newSQLite,
+			SchemaAdapter:  newSQLiteSchemaAdapter(1),
+			OffsetsAdapter: newSQLiteOffsetsAdapter(),
+			AgeQuery: func(table string) string {
+				return `UPDATE ` + table + ` SET "created_at" = datetime("created_at", '-2 hours')
+					WHERE "offset" IN (SELECT "offset" FROM ` + table + ` ORDER BY "offset" LIMIT 5)`
+			},
+		},
+		{
+			Name:           "postgresql",
+			DB:             newPostgreSQL,
+			SchemaAdapter:  newPostgresSchemaAdapter(1),
+			OffsetsAdapter: newPostgresOffsetsAdapter(),
+			AgeQuery: func(table string) string {
+				return `UPDATE ` + table + ` SET created_at = created_at - INTERVAL '2 hours'
+					WHERE "offset" IN (SELECT "offset" FROM ` + table + ` ORDER BY transaction_id, "offset" LIMIT 5)`
+			},
+		},
+		{
+			Name:           "mysql",
+			DB:             newMySQL,
+			SchemaAdapter:  newMySQLSchemaAdapter(1),
+			OffsetsAdapter: newMySQLOffsetsAdapter(),
+			AgeQuery: func(table string) string {
+				return "UPDATE " + table + " SET `created_at` = `created_at` - INTERVAL 2 HOUR ORDER BY `offset` LIMIT 5"
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			db := tc.DB(t)
+			topic := "offsets_admin_" + watermill.NewShortUUID()
+			ctx := context.Background()
+
+			publisher, subscriber := newPubSub(t, db, "a", tc.SchemaAdapter, tc.OffsetsAdapter)
+			require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+			var uuids []string
+			for i := 0; i < 10; i++ {
+				msg := message.NewMessage(watermill.NewUUID(), []byte(fmt.Sprintf("%d", i)))
+				require.NoError(t, publisher.Publish(topic, msg))
+				uuids = append(uuids, msg.UUID)
+			}
+
+			consumeOffsetsAdminTestMessages(t, subscriber, topic, 4)
+
+			admin, err := sql.NewOffsetsAdmin(db, tc.SchemaAdapter, tc.OffsetsAdapter)
+			require.NoError(t, err)
+
+			assertLag := func(t *testing.T, expected map[string]int64) {
+				t.Helper()
+
+				groups, err := admin.ConsumerGroups(ctx, topic)
+				require.NoError(t, err)
+
+				lags := map[string]int64{}
+				for _, group := range groups {
+					lags[group.ConsumerGroup] = group.Lag
+				}
+				assert.Equal(t, expected, lags)
+			}
+
+			assertLag(t, map[string]int64{"a": 6})
+
+			require.NoError(t, admin.SeekToMessage(ctx, topic, "a", uuids[7]))
+			assertLag(t, map[string]int64{"a": 3})
+
+			_, subscriber = newPubSub(t, db, "a", tc.SchemaAdapter, tc.OffsetsAdapter)
+			received := consumeOffsetsAdminTestMessages(t, subscriber, topic, 1)
+			assert.Equal(t, uuids[7], received[0].UUID)
+
+			require.NoError(t, admin.ResetToEarliest(ctx, topic, "b"))
+			assertLag(t, map[string]int64{"a": 2, "b": 10})
+
+			require.NoError(t, admin.ResetToLatest(ctx, topic, "a"))
+			assertLag(t, map[string]int64{"a": 0, "b": 10})
+
+			_, err = db.ExecContext(ctx, tc.AgeQuery(tc.SchemaAdapter.MessagesTable(topic)))
+			require.NoError(t, err)
+
+			require.NoError(t, admin.SeekToTimestamp(ctx, topic, "c", time.Now().Add(-time.Hour)))
+			assertLag(t, map[string]int64{"a": 0, "b": 10, "c": 5})
+
+			err = admin.SeekToMessage(ctx, topic, "a", watermill.NewUUID())
+			assert.ErrorIs(t, err, sql.ErrMessageNotFound)
+		})
+	}
+}
+
+func TestNewOffsetsAdmin_unsupported_adapter(t *testing.T) {
+	t.Parallel()
+
+	_, err := sql.NewOffsetsAdmin(newSQLite(t), newSQLiteSchemaAdapter(0), sql.PostgreSQLQueueOffsetsAdapter{})
+	assert.Error(t, err)
+}
+
+// consumeOffsetsAdminTestMessages acks count messages, and closes the subscriber after the acks are stored.
+func consumeOffsetsAdminTestMessages(t *testing.T, subscriber message.Subscriber, topic string, count int) []*message.Message {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := subscriber.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	var received []*message.Message
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-messages:
+			received = append(received, msg)
+			msg.Ack()
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for message")
+		}
+	}
+
+	require.NoError(t, subscriber.Close())
+
+	return received
+}
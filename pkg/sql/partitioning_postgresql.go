@@ -0,0 +1,336 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+const (
+	postgreSQLPartitionSuffixFormat = "20060102_150405"
+	postgreSQLMaxIdentifierLength   = 63
+)
+
+// PostgreSQLPartitioningConfig configures partitioning of the messages table of DefaultPostgreSQLSchema.
+//
+// The messages table is partitioned by range of created_at, with one partition per Interval.
+// Partitions are created by SchemaInitializingQueries and PostgreSQLPartitionManager.
+// Messages can't be inserted when there is no partition for the current time,
+// so PostgreSQLPartitionManager should run, unless the schema is initialized often enough.
+//
+// Partitioning can be enabled only for new tables, it doesn't migrate existing ones.
+// The created_at column of partitioned tables is TIMESTAMPTZ (instead of TIMESTAMP), so partitions
+// of messages don't depend on the time zones of the sessions inserting them and managing partitions.
+// Requires PostgreSQL 12 or newer.
+type PostgreSQLPartitioningConfig struct {
+	// Interval is the time range of a single partition, aligned to the Unix epoch in UTC.
+	// Partitioning is enabled when Interval is greater than 0. Must be a whole number of minutes.
+	Interval time.Duration
+
+	// PrecreatedPartitions is the number of partitions created ahead of the current one. Defaults to 3.
+	PrecreatedPartitions int
+
+	// Retention is the time after which partitions are dropped by PostgreSQLPartitionManager.
+	// A partition is dropped when all its messages are older than Retention, whether they were consumed or not.
+	// If 0, partitions are never dropped.
+	Retention time.Duration
+}
+
+func (c PostgreSQLPartitioningConfig) enabled() bool {
+	return c.Interval > 0
+}
+
+func (c PostgreSQLPartitioningConfig) precreatedPartitions() int {
+	if c.PrecreatedPartitions == 0 {
+		return 3
+	}
+
+	return c.PrecreatedPartitions
+}
+
+func (c PostgreSQLPartitioningConfig) validate() error {
+	if c.Interval < 0 {
+		return errors.New("partitioning interval must be non-negative")
+	}
+	if c.Interval%time.Minute != 0 {
+		return errors.New("partitioning interval must be a whole number of minutes")
+	}
+	if c.PrecreatedPartitions < 0 {
+		return errors.New("precreated partitions must be non-negative")
+	}
+	if c.Retention < 0 {
+		return errors.New("partitions retention must be non-negative")
+	}
+
+	return nil
+}
+
+// partitionStart returns the start of the partition containing t.
+func (c PostgreSQLPartitioningConfig) partitionStart(t time.Time) time.Time {
+	interval := int64(c.Interval / time.Second)
+	unix := t.Unix()
+
+	return time.Unix(unix-unix%interval, 0).UTC()
+}
+
+// MessagesPartitionTable returns the name of the partition of the messages table starting at from.
+func (s DefaultPostgreSQLSchema) MessagesPartitionTable(topic string, from time.Time) (string, error) {
+	identifier, err := parsePostgreSQLIdentifier(s.MessagesTable(topic))
+	if err != nil {
+		return "", err
+	}
+
+	table := identifier[len(identifier)-1]
+	suffix := "_p" + from.UTC().Format(postgreSQLPartitionSuffixFormat)
+
+	// longer names are truncated by PostgreSQL, which would remove the suffix, and could make names of different tables equal
+	if len(table)+len(suffix) > postgreSQLMaxIdentifierLength {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(table))
+
+		hash := fmt.Sprintf("_%08x", h.Sum32())
+		table = table[:postgreSQLMaxIdentifierLength-len(suffix)-len(hash)] + hash
+	}
+
+	identifier[len(identifier)-1] = table + suffix
+
+	return identifier.Sanitize(), nil
+}
+
+// partitionQueries returns queries creating the partition containing now and PrecreatedPartitions partitions after it.
+// The partition before is created too, in case of clock differences between the application and the database.
+func (s DefaultPostgreSQLSchema) partitionQueries(topic string, now time.Time) ([]Query, error) {
+	config := s.Partitioning
+	start := config.partitionStart(now).Add(-config.Interval)
+
+	var queries []Query
+
+	for i := 0; i < config.precreatedPartitions()+2; i++ {
+		from := start.Add(config.Interval * time.Duration(i))
+		to := from.Add(config.Interval)
+
+		partition, err := s.MessagesPartitionTable(topic, from)
+		if err != nil {
+			return nil, err
+		}
+
+		// created_at of partitioned tables is TIMESTAMPTZ, and the bounds are in UTC with an explicit offset,
+		// so they are the same points in time for all sessions, regardless of their time zones
+		queries = append(queries, Query{
+			Query: fmt.Sprintf(
+				`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM (TIMESTAMPTZ '%s') TO (TIMESTAMPTZ '%s');`,
+				partition,
+				s.MessagesTable(topic),
+				from.Format(time.RFC3339),
+				to.Format(time.RFC3339),
+			),
+		})
+	}
+
+	return queries, nil
+}
+
+// PostgreSQLPartitionManagerConfig is a configuration for PostgreSQLPartitionManager.
+type PostgreSQLPartitionManagerConfig struct {
+	// DB is a database connection. Required.
+	DB ContextExecutor
+
+	// SchemaAdapter is the schema adapter with Partitioning enabled. Required.
+	SchemaAdapter DefaultPostgreSQLSchema
+
+	// Topics are the topics to manage partitions of. Required.
+	Topics []string
+
+	// CheckInterval is the time between creating and dropping partitions.
+	// It should be shorter than SchemaAdapter.Partitioning.Interval. Defaults to 1 minute.
+	CheckInterval time.Duration
+
+	Logger watermill.LoggerAdapter
+}
+
+func (c *PostgreSQLPartitionManagerConfig) setDefaults() {
+	if c.CheckInterval == 0 {
+		c.CheckInterval = time.Minute
+	}
+
+	if c.Logger == nil {
+		c.Logger = watermill.NopLogger{}
+	}
+}
+
+func (c PostgreSQLPartitionManagerConfig) validate() error {
+	if c.DB == nil {
+		return errors.New("missing db")
+	}
+	if !c.SchemaAdapter.Partitioning.enabled() {
+		return errors.New("partitioning is not enabled in the schema adapter")
+	}
+	if err := c.SchemaAdapter.Partitioning.validate(); err != nil {
+		return err
+	}
+	if len(c.Topics) == 0 {
+		return errors.New("missing topics")
+	}
+	for _, topic := range c.Topics {
+		if err := validateTopicName(topic); err != nil {
+			return err
+		}
+	}
+	if c.CheckInterval < 0 {
+		return errors.New("check interval must be non-negative")
+	}
+
+	return nil
+}
+
+// PostgreSQLPartitionManager periodically creates future partitions of the messages tables
+// and drops partitions older than PostgreSQLPartitioningConfig.Retention.
+type PostgreSQLPartitionManager struct {
+	config PostgreSQLPartitionManagerConfig
+	logger watermill.LoggerAdapter
+}
+
+func NewPostgreSQLPartitionManager(config PostgreSQLPartitionManagerConfig) (*PostgreSQLPartitionManager, error) {
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &PostgreSQLPartitionManager{
+		config: config,
+		logger: config.Logger,
+	}, nil
+}
+
+// Run manages partitions every CheckInterval, until ctx is canceled.
+// Errors are logged, and partitions are managed again after CheckInterval.
+func (m *PostgreSQLPartitionManager) Run(ctx context.Context) error {
+	for {
+		if err := m.ManagePartitions(ctx); err != nil && ctx.Err() == nil {
+			m.logger.Error("Could not manage partitions", err, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(m.config.CheckInterval):
+		}
+	}
+}
+
+// ManagePartitions creates future partitions and drops expired partitions of all topics once.
+// Messages tables must already exist.
+func (m *PostgreSQLPartitionManager) ManagePartitions(ctx context.Context) error {
+	now := time.Now()
+
+	for _, topic := range m.config.Topics {
+		if err := m.createPartitions(ctx, topic, now); err != nil {
+			return fmt.Errorf("could not create partitions of topic %s: %w", topic, err)
+		}
+
+		if m.config.SchemaAdapter.Partitioning.Retention > 0 {
+			if err := m.dropExpiredPartitions(ctx, topic, now); err != nil {
+				return fmt.Errorf("could not drop partitions of topic %s: %w", topic, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *PostgreSQLPartitionManager) createPartitions(ctx context.Context, topic string, now time.Time) error {
+	queries, err := m.config.SchemaAdapter.partitionQueries(topic, now)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range queries {
+		if _, err := m.config.DB.ExecContext(ctx, query.Query, query.Args...); err != nil {
+			return fmt.Errorf("could not create partition: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (m *PostgreSQLPartitionManager) dropExpiredPartitions(ctx context.Context, topic string, now time.Time) error {
+	config := m.config.SchemaAdapter.Partitioning
+	table := m.config.SchemaAdapter.MessagesTable(topic)
+
+	partitions, err := m.partitions(ctx, table)
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		from, ok := postgreSQLPartitionStart(partition)
+		if !ok {
+			// not created by this manager
+			continue
+		}
+
+		if from.Add(config.Interval).After(now.Add(-config.Retention)) {
+			continue
+		}
+
+		expected, err := m.config.SchemaAdapter.MessagesPartitionTable(topic, from)
+		if err != nil {
+			return err
+		}
+
+		if _, err := m.config.DB.ExecContext(ctx, `DROP TABLE IF EXISTS `+expected); err != nil {
+			return fmt.Errorf("could not drop partition %s: %w", expected, err)
+		}
+
+		m.logger.Info("Dropped expired partition", watermill.LogFields{
+			"topic":     topic,
+			"partition": expected,
+		})
+	}
+
+	return nil
+}
+
+func (m *PostgreSQLPartitionManager) partitions(ctx context.Context, table string) ([]string, error) {
+	rows, err := m.config.DB.QueryContext(
+		ctx,
+		`SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass`,
+		table,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []string
+	for rows.Next() {
+		var partition string
+		if err := rows.Scan(&partition); err != nil {
+			return nil, fmt.Errorf("could not scan partition: %w", err)
+		}
+
+		partitions = append(partitions, partition)
+	}
+
+	return partitions, nil
+}
+
+// postgreSQLPartitionStart parses the start time from the partition name created by MessagesPartitionTable.
+func postgreSQLPartitionStart(partition string) (time.Time, bool) {
+	i := strings.LastIndex(partition, "_p")
+	if i == -1 {
+		return time.Time{}, false
+	}
+
+	from, err := time.Parse(postgreSQLPartitionSuffixFormat, partition[i+2:])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return from, true
+}
@@ -0,0 +1,258 @@
+package sql_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/tests"
+)
+
+func TestDefaultPostgreSQLSchema_partitioning_queries(t *testing.T) {
+	t.Parallel()
+
+	schemaAdapter := sql.DefaultPostgreSQLSchema{
+		InitializeSchemaWithoutTransaction: true,
+		Partitioning: sql.PostgreSQLPartitioningConfig{
+			Interval:             time.Hour,
+			PrecreatedPartitions: 2,
+		},
+	}
+
+	queries, err := schemaAdapter.SchemaInitializingQueries(sql.SchemaInitializingQueriesParams{Topic: "topic"})
+	require.NoError(t, err)
+
+	// the messages table, the previous, the current, and 2 precreated partitions
+	require.Len(t, queries, 5)
+	assert.Contains(t, queries[0].Query, `PRIMARY KEY ("transaction_id", "offset", "created_at")`)
+	assert.Contains(t, queries[0].Query, `PARTITION BY RANGE ("created_at")`)
+	assert.Contains(t, queries[0].Query, `"created_at" TIMESTAMPTZ NOT NULL`)
+
+	current := time.Now().UTC().Truncate(time.Hour)
+	for i, query := range queries[1:] {
+		from := current.Add(time.Duration(i-1) * time.Hour)
+
+		partition, err := schemaAdapter.MessagesPartitionTable("topic", from)
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(query.Query, "CREATE TABLE IF NOT EXISTS "+partition+` PARTITION OF "watermill_topic"`), query.Query)
+		assert.Contains(t, query.Query, from.Format(time.RFC3339))
+	}
+}
+
+func TestDefaultPostgreSQLSchema_invalid_partitioning(t *testing.T) {
+	t.Parallel()
+
+	schemaAdapter := sql.DefaultPostgreSQLSchema{
+		Partitioning: sql.PostgreSQLPartitioningConfig{
+			Interval: time.Second,
+		},
+	}
+
+	_, err := schemaAdapter.SchemaInitializingQueries(sql.SchemaInitializingQueriesParams{Topic: "topic"})
+	assert.Error(t, err)
+}
+
+func TestDefaultPostgreSQLSchema_MessagesPartitionTable(t *testing.T) {
+	t.Parallel()
+
+	from := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		Name          string
+		Table         string
+		ExpectedTable string
+	}{
+		{
+			Name:          "default",
+			Table:         `"watermill_topic"`,
+			ExpectedTable: `"watermill_topic_p20240102_030000"`,
+		},
+		{
+			Name:          "schema",
+			Table:         `"events"."watermill_topic"`,
+			ExpectedTable: `"events"."watermill_topic_p20240102_030000"`,
+		},
+		{
+			Name:          "long",
+			Table:         `"watermill_` + strings.Repeat("a", 50) + `"`,
+			ExpectedTable: `"watermill_` + strings.Repeat("a", 27) + `_756fc3b1_p20240102_030000"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			schemaAdapter := sql.DefaultPostgreSQLSchema{
+				GenerateMessagesTableName: func(topic string) string {
+					return tc.Table
+				},
+			}
+
+			partition, err := schemaAdapter.MessagesPartitionTable("topic", from)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpectedTable, partition)
+		})
+	}
+}
+
+func newPostgresPartitionedSchemaAdapter() sql.DefaultPostgreSQLSchema {
+	return sql.DefaultPostgreSQLSchema{
+		InitializeSchemaLock: rand.Intn(1000000),
+		GenerateMessagesTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_partitioned_%s"`, topic)
+		},
+		GeneratePayloadType: func(topic string) string {
+			return "BYTEA"
+		},
+		Partitioning: sql.PostgreSQLPartitioningConfig{
+			Interval:  time.Hour,
+			Retention: 24 * time.Hour,
+		},
+	}
+}
+
+func createPostgreSQLPartitionedPubSubWithConsumerGroup(t *testing.T, consumerGroup string) (message.Publisher, message.Subscriber) {
+	return newPubSub(
+		t,
+		newPostgreSQL(t),
+		consumerGroup,
+		newPostgresPartitionedSchemaAdapter(),
+		newPostgresOffsetsAdapter(),
+	)
+}
+
+func createPostgreSQLPartitionedPubSub(t *testing.T) (message.Publisher, message.Subscriber) {
+	return createPostgreSQLPartitionedPubSubWithConsumerGroup(t, "test")
+}
+
+func TestPostgreSQLPartitionedPublishSubscribe(t *testing.T) {
+	t.Parallel()
+
+	features := tests.Features{
+		ConsumerGroups:      true,
+		ExactlyOnceDelivery: true,
+		GuaranteedOrder:     true,
+		Persistent:          true,
+	}
+
+	tests.TestPubSub(
+		t,
+		features,
+		createPostgreSQLPartitionedPubSub,
+		createPostgreSQLPartitionedPubSubWithConsumerGroup,
+	)
+}
+
+func TestPostgreSQLPartitionManager(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	topic := "partitions_" + watermill.NewShortUUID()
+	schemaAdapter := newPostgresPartitionedSchemaAdapter()
+
+	_, subscriber := newPubSub(t, db, "test", schemaAdapter, newPostgresOffsetsAdapter())
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	expiredFrom := time.Now().UTC().Truncate(time.Hour).Add(-48 * time.Hour)
+	expiredPartition, err := schemaAdapter.MessagesPartitionTable(topic, expiredFrom)
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(context.Background(), fmt.Sprintf(
+		`CREATE TABLE %s PARTITION OF %s FOR VALUES FROM (TIMESTAMPTZ '%s') TO (TIMESTAMPTZ '%s')`,
+		expiredPartition,
+		schemaAdapter.MessagesTable(topic),
+		expiredFrom.Format(time.RFC3339),
+		expiredFrom.Add(time.Hour).Format(time.RFC3339),
+	))
+	require.NoError(t, err)
+
+	manager, err := sql.NewPostgreSQLPartitionManager(sql.PostgreSQLPartitionManagerConfig{
+		DB:            db,
+		SchemaAdapter: schemaAdapter,
+		Topics:        []string{topic},
+		Logger:        logger,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, manager.ManagePartitions(context.Background()))
+
+	partitions := func() []string {
+		rows, err := db.QueryContext(
+			context.Background(),
+			`SELECT c.oid::regclass::text FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass`,
+			schemaAdapter.MessagesTable(topic),
+		)
+		require.NoError(t, err)
+		defer rows.Close()
+
+		var partitions []string
+		for rows.Next() {
+			var partition string
+			require.NoError(t, rows.Scan(&partition))
+			partitions = append(partitions, partition)
+		}
+
+		return partitions
+	}()
+
+	// the previous, the current, and 3 precreated partitions
+	assert.Len(t, partitions, 5)
+	assert.NotContains(t, partitions, expiredPartition)
+}
+
+func TestDefaultPostgreSQLSchema_partitioning_session_time_zone(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	topic := "partitions_" + watermill.NewShortUUID()
+	schemaAdapter := newPostgresPartitionedSchemaAdapter()
+
+	_, subscriber := newPubSub(t, db, "test", schemaAdapter, newPostgresOffsetsAdapter())
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	require.NoError(t, err)
+
+	// the local time of UTC+14 is after the precreated partitions, so the message would not fit
+	// into any partition if created_at was stored in the session time zone
+	_, err = tx.ExecContext(context.Background(), `SET LOCAL TIME ZONE 'Pacific/Kiritimati'`)
+	require.NoError(t, err)
+
+	publisher, err := sql.NewPublisher(tx, sql.PublisherConfig{SchemaAdapter: schemaAdapter}, logger)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+	require.NoError(t, publisher.Publish(topic, msg))
+	require.NoError(t, tx.Commit())
+
+	rows, err := db.QueryContext(
+		context.Background(),
+		`SELECT c.relname FROM `+schemaAdapter.MessagesTable(topic)+` m JOIN pg_class c ON c.oid = m.tableoid WHERE m.uuid = $1`,
+		msg.UUID,
+	)
+	require.NoError(t, err)
+
+	var partition string
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&partition))
+	require.NoError(t, rows.Close())
+
+	// the partition is chosen by the UTC time, like its name
+	now := time.Now().UTC()
+	expected := map[string]bool{}
+	for _, from := range []time.Time{now.Add(-time.Hour).Truncate(time.Hour), now.Truncate(time.Hour)} {
+		name, err := schemaAdapter.MessagesPartitionTable(topic, from)
+		require.NoError(t, err)
+		expected[strings.Trim(name, `"`)] = true
+	}
+	assert.True(t, expected[partition], "message stored in partition %s", partition)
+}
@@ -24,17 +24,70 @@ type PublisherConfig struct {
 	// AutoInitializeSchema is forbidden if using an ongoing transaction as database handle;
 	// That could result in an implicit commit of the transaction by a CREATE TABLE statement.
 	AutoInitializeSchema bool
+
+	// SchemaVersions configures how AutoInitializeSchema handles the schema versions recorded by SchemaMigrator,
+	// for example applying pending migrations of existing topics. See SchemaVersionsConfig.
+	SchemaVersions SchemaVersionsConfig
+
+	// Batching enables buffering messages published to the same topic, and writing them in a single transaction
+	// owned by the publisher. Publish returns when the transaction is committed.
+	// It may increase throughput a lot when many messages are published concurrently.
+	//
+	// Batching requires a database handle which can begin transactions, so it can't be used with *sql.Tx.
+	Batching PublisherBatchingConfig
+
+	// MaxMessagesPerInsert is the maximum number of messages inserted with a single query.
+	// Publish calls with more messages are split into multiple queries executed in a single transaction,
+	// preserving the order of messages. It keeps queries within the database's limits,
+	// like 65535 bind parameters in PostgreSQL or max_allowed_packet in MySQL.
+	//
+	// When the database handle is a transaction, the queries are executed in it.
+	// Defaults to 1000.
+	MaxMessagesPerInsert int
+
+	// CopyFromMinMessages enables inserting messages with the COPY protocol, when a Publish call (or a batch,
+	// with Batching enabled) contains at least CopyFromMinMessages messages. It's useful for large backfills.
+	//
+	// It's used only with pgx database handles (BeginnerFromPgx or TxFromPgx) and schema adapters
+	// implementing PgxCopyFromInserter, like DefaultPostgreSQLSchema and PostgreSQLQueueSchema.
+	// Disabled by default.
+	CopyFromMinMessages int
+
+	// Metrics is optional. When set, it's notified about published messages and insert queries.
+	Metrics Metrics
+
+	// Tracing enables OpenTelemetry tracing. Disabled by default.
+	Tracing TracingConfig
 }
 
 func (c PublisherConfig) validate() error {
 	if c.SchemaAdapter == nil {
 		return errors.New("schema adapter is nil")
 	}
+	if c.MaxMessagesPerInsert <= 0 {
+		return errors.New("max messages per insert must be positive")
+	}
+	if c.CopyFromMinMessages < 0 {
+		return errors.New("copy from min messages must be non-negative")
+	}
+	if err := c.Batching.validate(); err != nil {
+		return fmt.Errorf("invalid batching config: %w", err)
+	}
 
 	return nil
 }
 
 func (c *PublisherConfig) setDefaults() {
+	c.Batching.setDefaults()
+	c.Tracing.setDefaults()
+
+	if c.Metrics == nil {
+		c.Metrics = NopMetrics{}
+	}
+
+	if c.MaxMessagesPerInsert == 0 {
+		c.MaxMessagesPerInsert = 1000
+	}
 }
 
 // Publisher inserts the Messages as rows into a SQL table..
@@ -49,6 +102,12 @@ type Publisher struct {
 
 	initializedTopics sync.Map
 	logger            watermill.LoggerAdapter
+	tracer            tracer
+
+	batchers     map[string]*topicBatcher
+	batchersLock sync.Mutex
+	batchersWg   sync.WaitGroup
+	batchersStop chan struct{}
 }
 
 func NewPublisher(db ContextExecutor, config PublisherConfig, logger watermill.LoggerAdapter) (*Publisher, error) {
@@ -71,6 +130,10 @@ func NewPublisher(db ContextExecutor, config PublisherConfig, logger watermill.L
 			"an ongoing transaction; this may result in an implicit commit")
 	}
 
+	if _, ok := db.(Beginner); config.Batching.enabled() && !ok {
+		return nil, errors.New("batching requires a database handle which can begin transactions")
+	}
+
 	return &Publisher{
 		config: config,
 		db:     db,
@@ -80,6 +143,10 @@ func NewPublisher(db ContextExecutor, config PublisherConfig, logger watermill.L
 		closed:    false,
 
 		logger: logger,
+		tracer: newTracer(config.Tracing),
+
+		batchers:     map[string]*topicBatcher{},
+		batchersStop: make(chan struct{}),
 	}, nil
 }
 
@@ -88,7 +155,21 @@ func NewPublisher(db ContextExecutor, config PublisherConfig, logger watermill.L
 // Publish is blocking until all rows have been added to the Publisher's transaction.
 // Publisher doesn't guarantee publishing messages in a single transaction,
 // but the constructor accepts both *sql.DB and *sql.Tx, so transactions may be handled upstream by the user.
+//
+// The queries use the context of the first message (see message.Message.SetContext).
+// Use PublishWithContext to pass the context explicitly.
 func (p *Publisher) Publish(topic string, messages ...*message.Message) (err error) {
+	ctx := context.Background()
+	if len(messages) > 0 {
+		ctx = messages[0].Context()
+	}
+
+	return p.PublishWithContext(ctx, topic, messages...)
+}
+
+// PublishWithContext works like Publish, but uses ctx for the queries,
+// so its deadline and cancellation apply to the insert and the schema initialization.
+func (p *Publisher) PublishWithContext(ctx context.Context, topic string, messages ...*message.Message) (err error) {
 	if p.closed {
 		return ErrPublisherClosed
 	}
@@ -96,14 +177,70 @@ func (p *Publisher) Publish(topic string, messages ...*message.Message) (err err
 	p.publishWg.Add(1)
 	defer p.publishWg.Done()
 
-	if err := validateTopicName(topic); err != nil {
+	if err := validateTopic(topic, p.config.SchemaAdapter); err != nil {
+		return err
+	}
+
+	ctx, span := p.tracer.startPublish(ctx, topic, messages)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if err := p.initializeSchema(ctx, topic); err != nil {
+		return err
+	}
+
+	if p.config.Batching.enabled() {
+		err = p.publishBatched(ctx, topic, messages)
+	} else {
+		err = p.insert(ctx, p.db, topic, messages)
+	}
+	if err != nil {
 		return err
 	}
 
-	if err := p.initializeSchema(topic); err != nil {
+	p.config.Metrics.MessagesPublished(topic, len(messages))
+
+	return nil
+}
+
+func (p *Publisher) insert(ctx context.Context, db ContextExecutor, topic string, messages message.Messages) error {
+	copyStart := time.Now()
+	copied, err := p.copyFrom(ctx, db, topic, messages)
+	if copied {
+		p.config.Metrics.QueryDuration(MetricsQueryInsert, topic, time.Since(copyStart), err)
+	}
+	if err != nil || copied {
 		return err
 	}
 
+	if len(messages) <= p.config.MaxMessagesPerInsert {
+		return p.insertChunk(ctx, db, topic, messages)
+	}
+
+	insertChunks := func(ctx context.Context, db ContextExecutor) error {
+		for start := 0; start < len(messages); start += p.config.MaxMessagesPerInsert {
+			end := min(start+p.config.MaxMessagesPerInsert, len(messages))
+
+			if err := p.insertChunk(ctx, db, topic, messages[start:end]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// transactions don't implement Beginner, so the chunks are inserted in the caller's transaction
+	if beginner, ok := db.(Beginner); ok {
+		return runInTx(ctx, beginner, func(ctx context.Context, tx Tx) error {
+			return insertChunks(ctx, tx)
+		})
+	}
+
+	return insertChunks(ctx, db)
+}
+
+func (p *Publisher) insertChunk(ctx context.Context, db ContextExecutor, topic string, messages message.Messages) error {
 	insertQuery, err := p.config.SchemaAdapter.InsertQuery(InsertQueryParams{
 		Topic: topic,
 		Msgs:  messages,
@@ -117,7 +254,9 @@ func (p *Publisher) Publish(topic string, messages ...*message.Message) (err err
 		"query_args": sqlArgsToLog(insertQuery.Args),
 	})
 
-	_, err = p.db.ExecContext(context.Background(), insertQuery.Query, insertQuery.Args...)
+	insertStart := time.Now()
+	_, err = db.ExecContext(ctx, insertQuery.Query, insertQuery.Args...)
+	p.config.Metrics.QueryDuration(MetricsQueryInsert, topic, time.Since(insertStart), err)
 	if err != nil {
 		return fmt.Errorf("could not insert message as row: %w", err)
 	}
@@ -125,7 +264,7 @@ func (p *Publisher) Publish(topic string, messages ...*message.Message) (err err
 	return nil
 }
 
-func (p *Publisher) initializeSchema(topic string) error {
+func (p *Publisher) initializeSchema(ctx context.Context, topic string) error {
 	if !p.config.AutoInitializeSchema {
 		return nil
 	}
@@ -134,7 +273,7 @@ func (p *Publisher) initializeSchema(topic string) error {
 		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*60)
 	defer cancel()
 
 	if err := initializeSchema(
@@ -144,6 +283,8 @@ func (p *Publisher) initializeSchema(topic string) error {
 		p.db,
 		p.config.SchemaAdapter,
 		nil,
+		"",
+		p.config.SchemaVersions,
 	); err != nil {
 		return fmt.Errorf("cannot initialize schema: %w", err)
 	}
@@ -165,6 +306,9 @@ func (p *Publisher) Close() error {
 	close(p.closeCh)
 	p.publishWg.Wait()
 
+	close(p.batchersStop)
+	p.batchersWg.Wait()
+
 	return nil
 }
 
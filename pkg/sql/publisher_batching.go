@@ -0,0 +1,244 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// ErrPublishOutcomeUnknown is returned by a batching Publisher when the context is canceled
+// after the messages were included in a batch, which may still be committed.
+// Publishing the messages again may create duplicates.
+var ErrPublishOutcomeUnknown = errors.New("publish outcome unknown, messages may be published")
+
+// PublisherBatchingConfig configures publishing messages in batches.
+//
+// If the context of a Publish call is done before its messages are included in a batch, the messages are not
+// published and the context error is returned. If it's done after they are included, the batch may be
+// committed anyway, so ErrPublishOutcomeUnknown is returned.
+type PublisherBatchingConfig struct {
+	// MaxBatchSize is the maximum number of messages written in a single transaction.
+	// The batch may be bigger, if a single Publish call contains more messages.
+	// Batching is enabled when MaxBatchSize is greater than 0.
+	MaxBatchSize int
+
+	// MaxLatency is the maximum time a message waits for other messages before the batch is written.
+	// Defaults to 10ms.
+	MaxLatency time.Duration
+
+	// WriteTimeout is the maximum time of writing a batch. The batch is not canceled together with
+	// the contexts of the Publish calls, because it contains messages of other calls as well.
+	// Defaults to 60s.
+	WriteTimeout time.Duration
+}
+
+func (c PublisherBatchingConfig) enabled() bool {
+	return c.MaxBatchSize > 0
+}
+
+func (c *PublisherBatchingConfig) setDefaults() {
+	if c.enabled() && c.MaxLatency == 0 {
+		c.MaxLatency = time.Millisecond * 10
+	}
+	if c.enabled() && c.WriteTimeout == 0 {
+		c.WriteTimeout = time.Second * 60
+	}
+}
+
+func (c PublisherBatchingConfig) validate() error {
+	if c.MaxBatchSize < 0 {
+		return errors.New("max batch size must be non-negative")
+	}
+	if c.MaxLatency < 0 {
+		return errors.New("max latency must be non-negative")
+	}
+	if c.WriteTimeout < 0 {
+		return errors.New("write timeout must be non-negative")
+	}
+
+	return nil
+}
+
+const (
+	publishRequestPending int32 = iota
+	publishRequestIncluded
+	publishRequestAbandoned
+)
+
+type publishRequest struct {
+	ctx      context.Context
+	messages message.Messages
+	result   chan error
+
+	// state is publishRequestPending until the batcher includes the request in a batch,
+	// or the caller abandons it because its context is done
+	state atomic.Int32
+}
+
+// topicBatcher collects messages published to a single topic and writes them in one transaction.
+type topicBatcher struct {
+	topic    string
+	requests chan *publishRequest
+}
+
+func (p *Publisher) publishBatched(ctx context.Context, topic string, messages message.Messages) error {
+	batcher := p.topicBatcher(topic)
+
+	request := &publishRequest{
+		ctx:      ctx,
+		messages: messages,
+		// buffered, so the batcher doesn't block if the caller stopped waiting
+		result: make(chan error, 1),
+	}
+
+	select {
+	case batcher.requests <- request:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-request.result:
+		return err
+	case <-ctx.Done():
+		if request.state.CompareAndSwap(publishRequestPending, publishRequestAbandoned) {
+			// the batcher skips abandoned requests, so the messages are not published
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("%w: %w", ErrPublishOutcomeUnknown, ctx.Err())
+	}
+}
+
+func (p *Publisher) topicBatcher(topic string) *topicBatcher {
+	p.batchersLock.Lock()
+	defer p.batchersLock.Unlock()
+
+	if batcher, ok := p.batchers[topic]; ok {
+		return batcher
+	}
+
+	batcher := &topicBatcher{
+		topic:    topic,
+		requests: make(chan *publishRequest),
+	}
+	p.batchers[topic] = batcher
+
+	p.batchersWg.Add(1)
+	go func() {
+		defer p.batchersWg.Done()
+		p.runBatcher(batcher)
+	}()
+
+	return batcher
+}
+
+func (p *Publisher) runBatcher(batcher *topicBatcher) {
+	logger := p.logger.With(watermill.LogFields{
+		"topic": batcher.topic,
+	})
+
+	for {
+		var batch []*publishRequest
+		messagesCount := 0
+
+		select {
+		case request := <-batcher.requests:
+			batch = append(batch, request)
+			messagesCount += len(request.messages)
+		case <-p.batchersStop:
+			return
+		}
+
+		timer := time.NewTimer(p.config.Batching.MaxLatency)
+
+	CollectLoop:
+		for messagesCount < p.config.Batching.MaxBatchSize {
+			select {
+			case request := <-batcher.requests:
+				batch = append(batch, request)
+				messagesCount += len(request.messages)
+			case <-timer.C:
+				break CollectLoop
+			case <-p.closeCh:
+				// the publisher is closing, so there is no point in waiting for more messages
+				break CollectLoop
+			}
+		}
+
+		timer.Stop()
+
+		batch = includeRequests(batch)
+		if len(batch) == 0 {
+			continue
+		}
+
+		err := p.writeBatch(batcher.topic, batch)
+		if err != nil {
+			logger.Error("Could not write batch", err, watermill.LogFields{
+				"messages_count": messagesCount,
+			})
+		}
+
+		for _, request := range batch {
+			request.result <- err
+		}
+	}
+}
+
+// includeRequests returns requests which are included in the batch.
+// Requests whose context is already done are skipped, so their messages are not published after the caller
+// got an error. Included requests can't be abandoned anymore, so their callers get the outcome of the batch.
+func includeRequests(batch []*publishRequest) []*publishRequest {
+	included := batch[:0]
+	for _, request := range batch {
+		if err := request.ctx.Err(); err != nil {
+			if request.state.CompareAndSwap(publishRequestPending, publishRequestAbandoned) {
+				request.result <- err
+			}
+			continue
+		}
+
+		if !request.state.CompareAndSwap(publishRequestPending, publishRequestIncluded) {
+			// abandoned by the caller
+			continue
+		}
+
+		included = append(included, request)
+	}
+
+	return included
+}
+
+// writeBatch writes messages of all requests in a single transaction, preserving their order.
+func (p *Publisher) writeBatch(topic string, batch []*publishRequest) error {
+	var messages message.Messages
+	links := make([]trace.Link, 0, len(batch))
+	for _, request := range batch {
+		messages = append(messages, request.messages...)
+		links = append(links, trace.LinkFromContext(request.ctx))
+	}
+
+	// the batch is not canceled together with any of the callers, its span is linked to their spans instead
+	ctx, span := p.tracer.startPublishBatch(topic, len(messages), links)
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.Batching.WriteTimeout)
+	defer cancel()
+
+	err := runInTx(ctx, p.db.(Beginner), func(ctx context.Context, tx Tx) error {
+		return p.insert(ctx, tx, topic, messages)
+	})
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("could not publish batch: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,410 @@
+package sql_test
+
+import (
+	"context"
+	stdSQL "database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/subscriber"
+	"github.com/ThreeDotsLabs/watermill/pubsub/tests"
+)
+
+func TestPublisher_context(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        newSQLiteSchemaAdapter(0),
+		AutoInitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	topic := "publisher_context_" + watermill.NewShortUUID()
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("publish_with_canceled_context", func(t *testing.T) {
+		err := pub.PublishWithContext(canceledCtx, topic, message.NewMessage(watermill.NewUUID(), []byte("{}")))
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("publish_with_canceled_message_context", func(t *testing.T) {
+		msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+		msg.SetContext(canceledCtx)
+
+		err := pub.Publish(topic, msg)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("publish_with_context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+		require.NoError(t, pub.PublishWithContext(ctx, topic, msg))
+
+		sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+			PollInterval:     10 * time.Millisecond,
+			SchemaAdapter:    newSQLiteSchemaAdapter(0),
+			OffsetsAdapter:   newSQLiteOffsetsAdapter(),
+			InitializeSchema: true,
+		}, logger)
+		require.NoError(t, err)
+
+		messages, err := sub.Subscribe(ctx, topic)
+		require.NoError(t, err)
+
+		select {
+		case received := <-messages:
+			assert.Equal(t, msg.UUID, received.UUID)
+			received.Ack()
+		case <-ctx.Done():
+			t.Fatal("message not received")
+		}
+	})
+}
+
+func TestPublisher_batching(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        newSQLiteSchemaAdapter(0),
+		AutoInitializeSchema: true,
+		Batching: sql.PublisherBatchingConfig{
+			MaxBatchSize: 50,
+			MaxLatency:   50 * time.Millisecond,
+		},
+	}, logger)
+	require.NoError(t, err)
+
+	topic := "publisher_batching_" + watermill.NewShortUUID()
+
+	publishersCount := 20
+	messagesPerPublisher := 10
+
+	var published message.Messages
+	var publishedLock sync.Mutex
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < publishersCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < messagesPerPublisher; j++ {
+				msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+				if !assert.NoError(t, pub.Publish(topic, msg)) {
+					return
+				}
+
+				publishedLock.Lock()
+				published = append(published, msg)
+				publishedLock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, pub.Close())
+
+	sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		PollInterval:     10 * time.Millisecond,
+		SchemaAdapter:    newSQLiteSchemaAdapter(0),
+		OffsetsAdapter:   newSQLiteOffsetsAdapter(),
+		InitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	received, all := subscriber.BulkRead(messages, len(published), 10*time.Second)
+	require.True(t, all)
+
+	tests.AssertAllMessagesReceived(t, published, received)
+}
+
+func TestPublisher_batching_requires_beginner(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, tx.Rollback())
+	}()
+
+	_, err = sql.NewPublisher(tx, sql.PublisherConfig{
+		SchemaAdapter: newSQLiteSchemaAdapter(0),
+		Batching: sql.PublisherBatchingConfig{
+			MaxBatchSize: 50,
+		},
+	}, logger)
+	require.ErrorContains(t, err, "batching requires a database handle which can begin transactions")
+}
+
+func TestPublisher_batching_invalid_write_timeout(t *testing.T) {
+	t.Parallel()
+
+	_, err := sql.NewPublisher(newSQLite(t), sql.PublisherConfig{
+		SchemaAdapter: newSQLiteSchemaAdapter(0),
+		Batching: sql.PublisherBatchingConfig{
+			MaxBatchSize: 50,
+			WriteTimeout: -time.Second,
+		},
+	}, logger)
+	require.ErrorContains(t, err, "write timeout must be non-negative")
+}
+
+func TestPublisher_batching_context_canceled(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        newSQLiteSchemaAdapter(0),
+		AutoInitializeSchema: true,
+		Batching: sql.PublisherBatchingConfig{
+			MaxBatchSize: 50,
+			MaxLatency:   300 * time.Millisecond,
+		},
+	}, logger)
+	require.NoError(t, err)
+
+	topic := "publisher_batching_canceled_" + watermill.NewShortUUID()
+
+	firstMsg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+	require.NoError(t, pub.Publish(topic, firstMsg))
+
+	// the request is abandoned while the batch is collected, so it's not published
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	canceledMsg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+	err = pub.PublishWithContext(ctx, topic, canceledMsg)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, sql.ErrPublishOutcomeUnknown)
+
+	lastMsg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+	require.NoError(t, pub.Publish(topic, lastMsg))
+
+	require.NoError(t, pub.Close())
+
+	sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		PollInterval:     10 * time.Millisecond,
+		SchemaAdapter:    newSQLiteSchemaAdapter(0),
+		OffsetsAdapter:   newSQLiteOffsetsAdapter(),
+		InitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	subCtx, subCancel := context.WithCancel(context.Background())
+	defer subCancel()
+
+	messages, err := sub.Subscribe(subCtx, topic)
+	require.NoError(t, err)
+
+	received, all := subscriber.BulkRead(messages, 3, time.Second)
+	require.False(t, all, "canceled message should not be published")
+	tests.AssertAllMessagesReceived(t, message.Messages{firstMsg, lastMsg}, received)
+}
+
+// failingExecBeginner fails the n-th query executed in a transaction.
+type failingExecBeginner struct {
+	sql.Beginner
+	failOnExec int
+}
+
+func (b failingExecBeginner) BeginTx(ctx context.Context, options *stdSQL.TxOptions) (sql.Tx, error) {
+	tx, err := b.Beginner.BeginTx(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingExecTx{Tx: tx, failOnExec: b.failOnExec}, nil
+}
+
+type failingExecTx struct {
+	sql.Tx
+	failOnExec int
+	execs      int
+}
+
+func (t *failingExecTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	t.execs++
+	if t.execs == t.failOnExec {
+		return nil, errors.New("exec failed")
+	}
+
+	return t.Tx.ExecContext(ctx, query, args...)
+}
+
+func TestPublisher_max_messages_per_insert(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	schemaAdapter := newSQLiteSchemaAdapter(0)
+
+	topic := "publisher_chunks_" + watermill.NewShortUUID()
+
+	sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		PollInterval:   10 * time.Millisecond,
+		SchemaAdapter:  schemaAdapter,
+		OffsetsAdapter: newSQLiteOffsetsAdapter(),
+	}, logger)
+	require.NoError(t, err)
+	require.NoError(t, sub.SubscribeInitialize(topic))
+
+	var messages message.Messages
+	for i := 0; i < 25; i++ {
+		messages = append(messages, message.NewMessage(watermill.NewUUID(), []byte("{}")))
+	}
+
+	failingPub, err := sql.NewPublisher(failingExecBeginner{Beginner: db, failOnExec: 2}, sql.PublisherConfig{
+		SchemaAdapter:        schemaAdapter,
+		MaxMessagesPerInsert: 10,
+	}, logger)
+	require.NoError(t, err)
+
+	require.Error(t, failingPub.Publish(topic, messages...))
+
+	rows, err := db.QueryContext(context.Background(), `SELECT COUNT(*) FROM `+schemaAdapter.MessagesTable(topic))
+	require.NoError(t, err)
+	require.True(t, rows.Next())
+	var count int
+	require.NoError(t, rows.Scan(&count))
+	require.NoError(t, rows.Close())
+	assert.Equal(t, 0, count, "all chunks should be inserted in a single transaction")
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        schemaAdapter,
+		MaxMessagesPerInsert: 10,
+	}, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, pub.Publish(topic, messages...))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	receivedMessages, all := subscriber.BulkRead(received, len(messages), 10*time.Second)
+	require.True(t, all)
+
+	tests.AssertAllMessagesReceived(t, messages, receivedMessages)
+	for i, msg := range messages {
+		assert.Equal(t, msg.UUID, receivedMessages[i].UUID, "order of messages should be preserved")
+	}
+}
+
+// copyFromCountingSchemaAdapter counts calls of CopyFrom, to check that messages are inserted with COPY.
+type copyFromCountingSchemaAdapter struct {
+	sql.SchemaAdapter
+	copies atomic.Int32
+}
+
+func (a *copyFromCountingSchemaAdapter) CopyFrom(ctx context.Context, tx pgx.Tx, params sql.InsertQueryParams) error {
+	a.copies.Add(1)
+	return a.SchemaAdapter.(sql.PgxCopyFromInserter).CopyFrom(ctx, tx, params)
+}
+
+func TestPublisher_copy_from(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name           string
+		SchemaAdapter  sql.SchemaAdapter
+		OffsetsAdapter sql.OffsetsAdapter
+	}{
+		{
+			Name:           "default",
+			SchemaAdapter:  newPostgresSchemaAdapter(0),
+			OffsetsAdapter: newPostgresOffsetsAdapter(),
+		},
+		{
+			Name: "queue",
+			SchemaAdapter: sql.PostgreSQLQueueSchema{
+				GeneratePayloadType: func(topic string) string {
+					return "BYTEA"
+				},
+			},
+			OffsetsAdapter: sql.PostgreSQLQueueOffsetsAdapter{},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newPgx(t)
+
+			copyFromCounter := &copyFromCountingSchemaAdapter{SchemaAdapter: tc.SchemaAdapter}
+
+			pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+				SchemaAdapter:        copyFromCounter,
+				AutoInitializeSchema: true,
+				CopyFromMinMessages:  100,
+				// without COPY, the messages would be inserted with 10 queries
+				MaxMessagesPerInsert: 100,
+			}, logger)
+			require.NoError(t, err)
+
+			topic := "copy_from_" + watermill.NewShortUUID()
+
+			var messages message.Messages
+			for i := 0; i < 1000; i++ {
+				msg := message.NewMessage(watermill.NewUUID(), []byte(`{"i":1}`))
+				msg.Metadata.Set("key", "value")
+				messages = append(messages, msg)
+			}
+
+			require.NoError(t, pub.Publish(topic, messages...))
+			assert.EqualValues(t, 1, copyFromCounter.copies.Load(), "messages should be inserted with a single COPY")
+
+			sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+				PollInterval:   10 * time.Millisecond,
+				SchemaAdapter:  tc.SchemaAdapter,
+				OffsetsAdapter: tc.OffsetsAdapter,
+			}, logger)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			received, err := sub.Subscribe(ctx, topic)
+			require.NoError(t, err)
+
+			receivedMessages, all := subscriber.BulkRead(received, len(messages), 30*time.Second)
+			require.True(t, all)
+
+			tests.AssertAllMessagesReceived(t, messages, receivedMessages)
+			for i, msg := range messages {
+				assert.Equal(t, msg.UUID, receivedMessages[i].UUID, "order of messages should be preserved")
+				assert.Equal(t, "value", receivedMessages[i].Metadata.Get("key"))
+			}
+		})
+	}
+}
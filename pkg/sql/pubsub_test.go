@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -23,6 +26,7 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
 )
 
 var (
@@ -130,6 +134,33 @@ func newPgx(t *testing.T) sql.Beginner {
 	return sql.BeginnerFromPgx(db)
 }
 
+var (
+	sqliteDB     *stdSQL.DB
+	sqliteDBOnce sync.Once
+)
+
+func newSQLite(t *testing.T) sql.Beginner {
+	// all tests share one database file, so publishers and subscribers created by separate constructors
+	// see the same messages, like with MySQL and PostgreSQL
+	sqliteDBOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "watermill-sql")
+		require.NoError(t, err)
+
+		// immediate transactions serialize writers up front instead of failing with "database is locked"
+		dsn := fmt.Sprintf(
+			"file:%s?_txlock=immediate&_pragma=busy_timeout(60000)&_pragma=journal_mode(WAL)",
+			filepath.Join(dir, "watermill.db"),
+		)
+		sqliteDB, err = stdSQL.Open("sqlite", dsn)
+		require.NoError(t, err)
+	})
+
+	err := sqliteDB.Ping()
+	require.NoError(t, err)
+
+	return sql.BeginnerFromStdSQL(sqliteDB)
+}
+
 func createMySQLPubSubWithConsumerGroup(t *testing.T, consumerGroup string) (message.Publisher, message.Subscriber) {
 	return newPubSub(
 		t,
@@ -165,6 +196,37 @@ func createMySQLPubSub(t *testing.T) (message.Publisher, message.Subscriber) {
 	return createMySQLPubSubWithConsumerGroup(t, "test")
 }
 
+func createSQLitePubSubWithConsumerGroup(t *testing.T, consumerGroup string) (message.Publisher, message.Subscriber) {
+	return newPubSub(
+		t,
+		newSQLite(t),
+		consumerGroup,
+		newSQLiteSchemaAdapter(0),
+		newSQLiteOffsetsAdapter(),
+	)
+}
+
+func newSQLiteOffsetsAdapter() sql.DefaultSQLiteOffsetsAdapter {
+	return sql.DefaultSQLiteOffsetsAdapter{
+		GenerateMessagesOffsetsTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_offsets_%s"`, topic)
+		},
+	}
+}
+
+func newSQLiteSchemaAdapter(batchSize int) *sql.DefaultSQLiteSchema {
+	return &sql.DefaultSQLiteSchema{
+		GenerateMessagesTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_%s"`, topic)
+		},
+		SubscribeBatchSize: batchSize,
+	}
+}
+
+func createSQLitePubSub(t *testing.T) (message.Publisher, message.Subscriber) {
+	return createSQLitePubSubWithConsumerGroup(t, "test")
+}
+
 func createPostgreSQLPubSubWithConsumerGroup(t *testing.T, consumerGroup string) (message.Publisher, message.Subscriber) {
 	return newPubSub(
 		t,
@@ -236,6 +298,35 @@ func createPgxPubSubWithConsumerGroup(t *testing.T, consumerGroup string) (messa
 	return newPubSub(t, newPgx(t), consumerGroup, schemaAdapter, offsetsAdapter)
 }
 
+func createPostgreSQLSharedTablePubSubWithConsumerGroup(t *testing.T, consumerGroup string) (message.Publisher, message.Subscriber) {
+	return newPubSub(
+		t,
+		newPostgreSQL(t),
+		consumerGroup,
+		newPostgresSharedTableSchemaAdapter(),
+		newPostgresSharedTableOffsetsAdapter(),
+	)
+}
+
+func newPostgresSharedTableSchemaAdapter() sql.PostgreSQLSharedTableSchema {
+	return sql.PostgreSQLSharedTableSchema{
+		TableName: "test_shared_messages",
+		GeneratePayloadType: func(topic string) string {
+			return "BYTEA"
+		},
+	}
+}
+
+func newPostgresSharedTableOffsetsAdapter() sql.PostgreSQLSharedTableOffsetsAdapter {
+	return sql.PostgreSQLSharedTableOffsetsAdapter{
+		TableName: "test_shared_offsets",
+	}
+}
+
+func createPostgreSQLSharedTablePubSub(t *testing.T) (message.Publisher, message.Subscriber) {
+	return createPostgreSQLSharedTablePubSubWithConsumerGroup(t, "test")
+}
+
 func createPostgreSQLPubSub(t *testing.T) (message.Publisher, message.Subscriber) {
 	return createPostgreSQLPubSubWithConsumerGroup(t, "test")
 }
@@ -287,6 +378,55 @@ func createPostgreSQLQueue(t *testing.T, db sql.Beginner) (message.Publisher, me
 	return publisher, subscriber
 }
 
+func createPostgreSQLQueueLease(t *testing.T, db sql.Beginner) (message.Publisher, message.Subscriber) {
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		GeneratePayloadType: func(topic string) string {
+			return "BYTEA"
+		},
+		GenerateMessagesTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_lease_%s"`, topic)
+		},
+		LeaseDuration: time.Minute,
+	}
+	offsetsAdapter := sql.PostgreSQLQueueOffsetsAdapter{
+		GenerateMessagesTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_lease_%s"`, topic)
+		},
+	}
+
+	return newPubSub(t, db, "", schemaAdapter, offsetsAdapter)
+}
+
+func createPostgreSQLQueueWithConsumerGroup(t *testing.T, consumerGroup string) (message.Publisher, message.Subscriber) {
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		GeneratePayloadType: func(topic string) string {
+			return "BYTEA"
+		},
+		GenerateMessagesTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_groups_%s"`, topic)
+		},
+		GenerateConsumerGroupsTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_groups_consumer_groups_%s"`, topic)
+		},
+		GenerateAcksTableName: func(topic string) string {
+			return fmt.Sprintf(`"test_groups_acks_%s"`, topic)
+		},
+		ConsumerGroups: true,
+	}
+	offsetsAdapter := sql.PostgreSQLQueueOffsetsAdapter{
+		GenerateMessagesTableName:       schemaAdapter.GenerateMessagesTableName,
+		GenerateConsumerGroupsTableName: schemaAdapter.GenerateConsumerGroupsTableName,
+		GenerateAcksTableName:           schemaAdapter.GenerateAcksTableName,
+		DeleteOnAck:                     true,
+	}
+
+	return newPubSub(t, newPostgreSQL(t), consumerGroup, schemaAdapter, offsetsAdapter)
+}
+
+func createPostgreSQLQueueConsumerGroups(t *testing.T) (message.Publisher, message.Subscriber) {
+	return createPostgreSQLQueueWithConsumerGroup(t, "test")
+}
+
 func TestMySQLPublishSubscribe(t *testing.T) {
 	t.Parallel()
 
@@ -305,6 +445,70 @@ func TestMySQLPublishSubscribe(t *testing.T) {
 	)
 }
 
+// TestSQLitePublishSubscribe runs the Pub/Sub test suite without tests.TestTopic.
+// SQLite has a single write lock per database, which is held by the subscriber until the message is acked,
+// so a second topic can't deliver until the first one's message is processed.
+// TestTopic reads the topics in the opposite order than they are subscribed, so it would always time out.
+func TestSQLitePublishSubscribe(t *testing.T) {
+	t.Parallel()
+
+	features := tests.Features{
+		ConsumerGroups:      true,
+		ExactlyOnceDelivery: true,
+		GuaranteedOrder:     true,
+		Persistent:          true,
+	}
+
+	testFuncs := []struct {
+		Name string
+		Func func(t *testing.T, tCtx tests.TestContext, pubSubConstructor tests.PubSubConstructor)
+	}{
+		{Name: "TestPublishSubscribe", Func: tests.TestPublishSubscribe},
+		{Name: "TestConcurrentSubscribe", Func: tests.TestConcurrentSubscribe},
+		{Name: "TestConcurrentSubscribeMultipleTopics", Func: tests.TestConcurrentSubscribeMultipleTopics},
+		{Name: "TestResendOnError", Func: tests.TestResendOnError},
+		{Name: "TestNoAck", Func: tests.TestNoAck},
+		{Name: "TestContinueAfterSubscribeClose", Func: tests.TestContinueAfterSubscribeClose},
+		{Name: "TestConcurrentClose", Func: tests.TestConcurrentClose},
+		{Name: "TestContinueAfterErrors", Func: tests.TestContinueAfterErrors},
+		{Name: "TestPublishSubscribeInOrder", Func: tests.TestPublishSubscribeInOrder},
+		{Name: "TestPublisherClose", Func: tests.TestPublisherClose},
+		{Name: "TestMessageCtx", Func: tests.TestMessageCtx},
+		{Name: "TestSubscribeCtx", Func: tests.TestSubscribeCtx},
+		{Name: "TestNewSubscriberReceivesOldMessages", Func: tests.TestNewSubscriberReceivesOldMessages},
+	}
+
+	for i := range testFuncs {
+		tf := testFuncs[i]
+
+		t.Run(tf.Name, func(t *testing.T) {
+			t.Parallel()
+
+			tf.Func(
+				t,
+				tests.TestContext{
+					TestID:   tests.NewTestID(),
+					Features: features,
+				},
+				createSQLitePubSub,
+			)
+		})
+	}
+
+	t.Run("TestConsumerGroups", func(t *testing.T) {
+		t.Parallel()
+
+		tests.TestConsumerGroups(
+			t,
+			tests.TestContext{
+				TestID:   tests.NewTestID(),
+				Features: features,
+			},
+			createSQLitePubSubWithConsumerGroup,
+		)
+	})
+}
+
 func TestPostgreSQLPublishSubscribe(t *testing.T) {
 	t.Parallel()
 
@@ -359,6 +563,24 @@ func TestPgxPublishSubscribe(t *testing.T) {
 	)
 }
 
+func TestPostgreSQLSharedTablePublishSubscribe(t *testing.T) {
+	t.Parallel()
+
+	features := tests.Features{
+		ConsumerGroups:      true,
+		ExactlyOnceDelivery: true,
+		GuaranteedOrder:     true,
+		Persistent:          true,
+	}
+
+	tests.TestPubSub(
+		t,
+		features,
+		createPostgreSQLSharedTablePubSub,
+		createPostgreSQLSharedTablePubSubWithConsumerGroup,
+	)
+}
+
 func TestPostgreSQLQueue(t *testing.T) {
 	t.Parallel()
 
@@ -379,6 +601,44 @@ func TestPostgreSQLQueue(t *testing.T) {
 	)
 }
 
+func TestPostgreSQLQueueLease(t *testing.T) {
+	t.Parallel()
+
+	features := tests.Features{
+		ConsumerGroups:      false,
+		ExactlyOnceDelivery: false,
+		GuaranteedOrder:     false,
+		Persistent:          true,
+	}
+
+	tests.TestPubSub(
+		t,
+		features,
+		func(t *testing.T) (message.Publisher, message.Subscriber) {
+			return createPostgreSQLQueueLease(t, newPostgreSQL(t))
+		},
+		nil,
+	)
+}
+
+func TestPostgreSQLQueueConsumerGroups(t *testing.T) {
+	t.Parallel()
+
+	features := tests.Features{
+		ConsumerGroups:      true,
+		ExactlyOnceDelivery: true,
+		GuaranteedOrder:     true,
+		Persistent:          true,
+	}
+
+	tests.TestPubSub(
+		t,
+		features,
+		createPostgreSQLQueueConsumerGroups,
+		createPostgreSQLQueueWithConsumerGroup,
+	)
+}
+
 func TestPgxPostgreSQLQueue(t *testing.T) {
 	t.Parallel()
 
@@ -850,3 +1110,121 @@ func extractDurationFromAnalyze(t *testing.T, s string) time.Duration {
 
 	return parsed
 }
+
+func TestPostgreSQLQueue_skip_locked_competing_consumers(t *testing.T) {
+	t.Parallel()
+
+	messagesCount := 200
+	subscribersCount := 4
+	processingTime := 10 * time.Millisecond
+
+	db := newPostgreSQL(t)
+
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		GeneratePayloadType: func(topic string) string {
+			return "BYTEA"
+		},
+		SubscribeBatchSize: 10,
+		SkipLocked:         true,
+	}
+	offsetsAdapter := sql.PostgreSQLQueueOffsetsAdapter{
+		DeleteOnAck: true,
+	}
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        schemaAdapter,
+		AutoInitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	topic := "topic_" + watermill.NewUUID()
+
+	var messagesToPublish message.Messages
+	for i := 0; i < messagesCount; i++ {
+		messagesToPublish = append(messagesToPublish, message.NewMessage(watermill.NewUUID(), []byte("{}")))
+	}
+	require.NoError(t, pub.Publish(topic, messagesToPublish...))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type receivedMessage struct {
+		msg             *message.Message
+		subscriberIndex int
+	}
+	received := make(chan receivedMessage)
+
+	var inFlight, maxInFlight int64
+
+	for i := 0; i < subscribersCount; i++ {
+		sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+			PollInterval:   time.Millisecond,
+			SchemaAdapter:  schemaAdapter,
+			OffsetsAdapter: offsetsAdapter,
+		}, logger)
+		require.NoError(t, err)
+
+		messages, err := sub.Subscribe(ctx, topic)
+		require.NoError(t, err)
+
+		subscriberIndex := i
+		go func() {
+			for msg := range messages {
+				current := atomic.AddInt64(&inFlight, 1)
+				for {
+					previousMax := atomic.LoadInt64(&maxInFlight)
+					if current <= previousMax || atomic.CompareAndSwapInt64(&maxInFlight, previousMax, current) {
+						break
+					}
+				}
+
+				time.Sleep(processingTime)
+				atomic.AddInt64(&inFlight, -1)
+				msg.Ack()
+
+				select {
+				case received <- receivedMessage{msg: msg, subscriberIndex: subscriberIndex}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	var receivedMessages message.Messages
+	seen := map[string]struct{}{}
+	messagesPerSubscriber := map[int]int{}
+
+ReceiveLoop:
+	for {
+		select {
+		case r := <-received:
+			_, duplicate := seen[r.msg.UUID]
+			require.False(t, duplicate, "message %s received twice", r.msg.UUID)
+			seen[r.msg.UUID] = struct{}{}
+
+			receivedMessages = append(receivedMessages, r.msg)
+			messagesPerSubscriber[r.subscriberIndex]++
+
+			if len(receivedMessages) == messagesCount {
+				break ReceiveLoop
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatalf("received %d of %d messages", len(receivedMessages), messagesCount)
+		}
+	}
+
+	// checking if no message is delivered again
+	select {
+	case r := <-received:
+		t.Fatalf("message %s received twice", r.msg.UUID)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	tests.AssertAllMessagesReceived(t, messagesToPublish, receivedMessages)
+
+	t.Logf("messages per subscriber: %v, max messages processed concurrently: %d", messagesPerSubscriber, maxInFlight)
+
+	assert.Greater(t, len(messagesPerSubscriber), 1, "messages should be consumed by more than one subscriber")
+	assert.Greater(t, atomic.LoadInt64(&maxInFlight), int64(1), "subscribers should process messages concurrently")
+}
@@ -7,17 +7,58 @@ import (
 )
 
 // PostgreSQLQueueOffsetsAdapter is an OffsetsAdapter for the PostgreSQLQueueSchema.
+//
+// When subscribing with a consumer group, PostgreSQLQueueSchema must have ConsumerGroups enabled.
 type PostgreSQLQueueOffsetsAdapter struct {
 	// DeleteOnAck determines whether the message should be deleted from the table when it is acknowledged.
 	// If false, the message will be marked as acked.
+	//
+	// With consumer groups, the message is deleted once all consumer groups acked it, by a trigger created
+	// during the schema initialization (see SubscriberConfig.InitializeSchema) or by SchemaMigrator.
 	DeleteOnAck bool
 
 	// GenerateMessagesTableName may be used to override how the messages table name is generated.
 	GenerateMessagesTableName func(topic string) string
+
+	// GenerateConsumerGroupsTableName may be used to override how the consumer groups table name is generated.
+	// It must match GenerateConsumerGroupsTableName of PostgreSQLQueueSchema.
+	GenerateConsumerGroupsTableName func(topic string) string
+
+	// GenerateAcksTableName may be used to override how the acks table name is generated.
+	// It must match GenerateAcksTableName of PostgreSQLQueueSchema.
+	GenerateAcksTableName func(topic string) string
+
+	// GenerateDeliveryAttemptsTableName may be used to override how the delivery attempts table name is generated.
+	// It must match GenerateDeliveryAttemptsTableName of PostgreSQLQueueSchema.
+	GenerateDeliveryAttemptsTableName func(topic string) string
+
+	// Namespace sets the PostgreSQL schema and the prefix of the default table names.
+	// It must match Namespace of PostgreSQLQueueSchema, which creates the tables.
+	Namespace PostgreSQLNamespace
 }
 
+// SchemaInitializingQueries registers the consumer group, so it receives messages published from now on.
+// With DeleteOnAck, it also creates the trigger deleting messages acked by all consumer groups.
 func (a PostgreSQLQueueOffsetsAdapter) SchemaInitializingQueries(params OffsetsSchemaInitializingQueriesParams) ([]Query, error) {
-	return []Query{}, nil
+	queries := a.deleteAckedTriggerQueries(params.Topic)
+
+	if params.ConsumerGroup != "" {
+		queries = append(queries, a.registerConsumerGroupQuery(params.Topic, params.ConsumerGroup))
+	}
+
+	return queries, nil
+}
+
+// SchemaMigrations returns migrations of the objects created by the adapter, see SchemaMigrator.
+// The messages table is created by PostgreSQLQueueSchema, so it has migrations only with DeleteOnAck.
+func (a PostgreSQLQueueOffsetsAdapter) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	if !a.DeleteOnAck {
+		return nil, nil
+	}
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create delete acked trigger", Queries: a.deleteAckedTriggerQueries(topic)},
+	}, nil
 }
 
 func (a PostgreSQLQueueOffsetsAdapter) NextOffsetQuery(params NextOffsetQueryParams) (Query, error) {
@@ -25,39 +66,219 @@ func (a PostgreSQLQueueOffsetsAdapter) NextOffsetQuery(params NextOffsetQueryPar
 }
 
 func (a PostgreSQLQueueOffsetsAdapter) AckMessageQuery(params AckMessageQueryParams) (Query, error) {
+	offsets := make([]int64, len(params.Rows))
+	for i, row := range params.Rows {
+		offsets[i] = row.Offset
+	}
+
 	if params.ConsumerGroup != "" {
-		panic("consumer groups are not supported in PostgreSQLQueueOffsetsAdapter")
+		return a.consumerGroupAckMessageQuery(params, offsets), nil
 	}
 
 	var ackQuery string
 
 	table := a.MessagesTable(params.Topic)
+	attempts := a.deliveryAttemptsCTE(params.Topic, "''", `= ANY($1)`)
+	args := []any{pq.Array(offsets)}
+
+	// a leased message may be acked only by the subscriber holding the lease,
+	// otherwise it could be acked after the lease expired and another subscriber claimed it
+	var leaseCondition string
+	if params.ConsumerULID != nil {
+		leaseCondition = ` AND locked_by = $2`
+		args = append(args, params.ConsumerULID)
+	}
 
 	if a.DeleteOnAck {
-		ackQuery = fmt.Sprintf(`DELETE FROM %s WHERE "offset" = ANY($1)`, table)
+		ackQuery = fmt.Sprintf(`WITH %s DELETE FROM %s WHERE "offset" = ANY($1)%s`, attempts, table, leaseCondition)
 	} else {
-		ackQuery = fmt.Sprintf(`UPDATE %s SET acked = TRUE WHERE "offset" = ANY($1)`, table)
+		ackQuery = fmt.Sprintf(
+			`WITH %s UPDATE %s t SET acked = TRUE, %s WHERE "offset" = ANY($1)%s`,
+			attempts,
+			table,
+			foldDeliveryAttempts,
+			leaseCondition,
+		)
 	}
 
-	offsets := make([]int64, len(params.Rows))
-	for i, row := range params.Rows {
-		offsets[i] = row.Offset
+	return Query{ackQuery, args}, nil
+}
+
+// consumerGroupAckMessageQuery acks the messages for the consumer group and decrements pending_groups.
+// The message is marked as acked when the last consumer group acks it.
+// Concurrent acks of the same message are serialized by the row lock taken by UPDATE,
+// so exactly one consumer group marks it as acked.
+func (a PostgreSQLQueueOffsetsAdapter) consumerGroupAckMessageQuery(params AckMessageQueryParams, offsets []int64) Query {
+	acksTable := a.AcksTable(params.Topic)
+
+	var ackGroupQuery string
+	if a.DeleteOnAck {
+		// ack rows are not needed anymore, the message is deleted by the trigger created in deleteAckedTriggerQueries
+		ackGroupQuery = `DELETE FROM ` + acksTable + ` WHERE consumer_group = $1 AND "offset" = ANY($2) RETURNING "offset"`
+	} else {
+		ackGroupQuery = `UPDATE ` + acksTable + ` t SET acked = TRUE, ` + foldDeliveryAttempts + `
+			WHERE consumer_group = $1 AND "offset" = ANY($2) AND acked = FALSE RETURNING "offset"`
 	}
 
-	return Query{ackQuery, []any{pq.Array(offsets)}}, nil
+	ackQuery := `
+		WITH ` + a.deliveryAttemptsCTE(params.Topic, "$1", "= ANY($2)") + `,
+		acked AS (
+			` + ackGroupQuery + `
+		)
+		UPDATE ` + a.MessagesTable(params.Topic) + `
+		SET pending_groups = pending_groups - 1, acked = pending_groups <= 1
+		WHERE "offset" IN (SELECT "offset" FROM acked)`
+
+	return Query{ackQuery, []any{params.ConsumerGroup, pq.Array(offsets)}}
 }
 
 func (a PostgreSQLQueueOffsetsAdapter) MessagesTable(topic string) string {
 	if a.GenerateMessagesTableName != nil {
 		return a.GenerateMessagesTableName(topic)
 	}
-	return fmt.Sprintf(`"watermill_%s"`, topic)
+	return a.Namespace.table(topic)
+}
+
+func (a PostgreSQLQueueOffsetsAdapter) ConsumerGroupsTable(topic string) string {
+	if a.GenerateConsumerGroupsTableName != nil {
+		return a.GenerateConsumerGroupsTableName(topic)
+	}
+	return a.Namespace.table("consumer_groups_" + topic)
+}
+
+func (a PostgreSQLQueueOffsetsAdapter) AcksTable(topic string) string {
+	if a.GenerateAcksTableName != nil {
+		return a.GenerateAcksTableName(topic)
+	}
+	return a.Namespace.table("acks_" + topic)
 }
 
+func (a PostgreSQLQueueOffsetsAdapter) DeliveryAttemptsTable(topic string) string {
+	if a.GenerateDeliveryAttemptsTableName != nil {
+		return a.GenerateDeliveryAttemptsTableName(topic)
+	}
+	return a.Namespace.table("delivery_attempts_" + topic)
+}
+
+// ConsumedMessageQuery is not used, delivery attempts are recorded by DeliveryAttemptQuery.
 func (a PostgreSQLQueueOffsetsAdapter) ConsumedMessageQuery(params ConsumedMessageQueryParams) (Query, error) {
 	return Query{}, nil
 }
 
+// DeliveryAttemptQuery records the delivery attempt in the delivery attempts table.
+// The messages (or acks) row can't be updated, because it's locked by the consuming transaction.
+// Recorded attempts are added to the delivery_attempts column by NotAckedMessageQuery and AckMessageQuery.
+func (a PostgreSQLQueueOffsetsAdapter) DeliveryAttemptQuery(params DeliveryAttemptQueryParams) (Query, error) {
+	attemptQuery := `
+		INSERT INTO ` + a.DeliveryAttemptsTable(params.Topic) + ` AS d (consumer_group, "offset", delivery_attempts, last_attempt_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (consumer_group, "offset") DO UPDATE
+		SET delivery_attempts = d.delivery_attempts + 1, last_attempt_at = NOW()
+		RETURNING delivery_attempts`
+
+	return Query{attemptQuery, []any{params.ConsumerGroup, params.Row.Offset}}, nil
+}
+
+func (a PostgreSQLQueueOffsetsAdapter) NotAckedMessageQuery(params NotAckedMessageQueryParams) (Query, error) {
+	if params.ConsumerGroup != "" {
+		notAckedQuery := fmt.Sprintf(
+			`WITH %s UPDATE %s t SET last_error = COALESCE(NULLIF($1, ''), last_error), %s WHERE consumer_group = $2 AND "offset" = $3`,
+			a.deliveryAttemptsCTE(params.Topic, "$2", "= $3"),
+			a.AcksTable(params.Topic),
+			foldDeliveryAttempts,
+		)
+
+		return Query{notAckedQuery, []any{params.Reason, params.ConsumerGroup, params.Row.Offset}}, nil
+	}
+
+	args := []any{params.Reason, params.Row.Offset}
+
+	// the lease is released only if it's still held by the subscriber
+	var leaseCondition string
+	if params.ConsumerULID != nil {
+		leaseCondition = ` AND locked_by = $3`
+		args = append(args, params.ConsumerULID)
+	}
+
+	// releasing the lease makes the message visible again in the lease mode, it's no-op otherwise
+	notAckedQuery := fmt.Sprintf(
+		`WITH %s UPDATE %s t SET last_error = COALESCE(NULLIF($1, ''), last_error), %s, locked_until = NULL, locked_by = NULL WHERE "offset" = $2%s`,
+		a.deliveryAttemptsCTE(params.Topic, "''", "= $2"),
+		a.MessagesTable(params.Topic),
+		foldDeliveryAttempts,
+		leaseCondition,
+	)
+
+	return Query{notAckedQuery, args}, nil
+}
+
+// deliveryAttemptsCTE returns the "attempts" CTE, which deletes attempts recorded by DeliveryAttemptQuery,
+// so they can be added to the delivery_attempts column with foldDeliveryAttempts.
+func (a PostgreSQLQueueOffsetsAdapter) deliveryAttemptsCTE(topic string, consumerGroup string, offsetCondition string) string {
+	return `attempts AS (
+			DELETE FROM ` + a.DeliveryAttemptsTable(topic) + `
+			WHERE consumer_group = ` + consumerGroup + ` AND "offset" ` + offsetCondition + `
+			RETURNING "offset", delivery_attempts, last_attempt_at
+		)`
+}
+
+// foldDeliveryAttempts sets the delivery tracking columns of the updated table (aliased as t)
+// from the "attempts" CTE, see deliveryAttemptsCTE.
+const foldDeliveryAttempts = `
+	delivery_attempts = t.delivery_attempts + COALESCE((SELECT a.delivery_attempts FROM attempts a WHERE a."offset" = t."offset"), 0),
+	last_attempt_at = COALESCE((SELECT a.last_attempt_at FROM attempts a WHERE a."offset" = t."offset"), t.last_attempt_at)`
+
+// BeforeSubscribingQueries registers the consumer group, if it wasn't registered when initializing the schema.
+// It doesn't execute DDL statements, so subscribers may start at the same time without InitializeSchema.
 func (a PostgreSQLQueueOffsetsAdapter) BeforeSubscribingQueries(params BeforeSubscribingQueriesParams) ([]Query, error) {
-	return []Query{}, nil
+	if params.ConsumerGroup == "" {
+		return []Query{}, nil
+	}
+
+	return []Query{a.registerConsumerGroupQuery(params.Topic, params.ConsumerGroup)}, nil
+}
+
+func (a PostgreSQLQueueOffsetsAdapter) registerConsumerGroupQuery(topic string, consumerGroup string) Query {
+	return Query{
+		Query: `INSERT INTO ` + a.ConsumerGroupsTable(topic) + ` (consumer_group) VALUES ($1) ON CONFLICT DO NOTHING`,
+		Args:  []any{consumerGroup},
+	}
+}
+
+// deleteAckedTriggerQueries returns queries creating the trigger which deletes a message
+// when the last consumer group acks it (see consumerGroupAckMessageQuery), if DeleteOnAck is enabled.
+// The trigger function is created in Namespace, so adapters in different namespaces don't replace
+// each other's function.
+func (a PostgreSQLQueueOffsetsAdapter) deleteAckedTriggerQueries(topic string) []Query {
+	if !a.DeleteOnAck {
+		return nil
+	}
+
+	function := a.Namespace.table("delete_acked")
+
+	createFunction := `
+		CREATE OR REPLACE FUNCTION ` + function + `() RETURNS trigger AS $$
+		BEGIN
+			EXECUTE format('DELETE FROM %s WHERE "offset" = $1', TG_RELID::regclass) USING NEW."offset";
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+	`
+
+	createTrigger := `
+		CREATE OR REPLACE TRIGGER watermill_delete_acked
+		AFTER UPDATE OF acked ON ` + a.MessagesTable(topic) + `
+		FOR EACH ROW WHEN (NEW.acked) EXECUTE FUNCTION ` + function + `();
+	`
+
+	return []Query{{Query: createFunction}, {Query: createTrigger}}
+}
+
+// Capabilities supports consumer groups, which are checked by PostgreSQLQueueSchema.
+func (a PostgreSQLQueueOffsetsAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectPostgreSQL,
+		AcksPerMessage: true,
+		ConsumerGroups: true,
+	}
 }
@@ -2,34 +2,97 @@ package sql
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 )
 
+const (
+	// DeliveryAttemptsMetadataKey is the metadata key with the number of times the message was consumed,
+	// including the current delivery. It's set by PostgreSQLQueueSchema.
+	DeliveryAttemptsMetadataKey = "_watermill_delivery_attempts"
+
+	// LastErrorMetadataKey is the metadata key with the reason why the previous delivery of the message failed.
+	// It's set by PostgreSQLQueueSchema, if the message was not acked before.
+	LastErrorMetadataKey = "_watermill_last_error"
+)
+
 type GenerateWhereClauseParams struct {
-	Topic string
+	Topic         string
+	ConsumerGroup string
 }
 
 // PostgreSQLQueueSchema is a schema adapter for PostgreSQL that allows filtering messages by some condition.
-// It DOES NOT support consumer groups.
+// It supports consumer groups when ConsumerGroups is enabled.
 // It supports deleting messages on ack.
+//
+// It tracks the number of delivery attempts, the time of the last attempt, and the reason of the last failure
+// (with PostgreSQLQueueOffsetsAdapter). Each delivery attempt is recorded in the delivery attempts table
+// before the message is delivered, outside the consuming transaction, so it's persisted even if the subscriber
+// crashes. Recorded attempts are added to the delivery_attempts column when the message is acked, nacked,
+// or not acked within the ack deadline. A nacked message is selected again after the consuming transaction
+// is committed, so it requires a second database connection.
+// In the lease mode (see LeaseDuration), delivery attempts are persisted when the message is claimed.
+//
+// Because the attempts are recorded by a separate connection, the consuming transaction uses the Read Committed
+// isolation level, so the ack queries see them (see SubscribeIsolationLevel). This is a behavior change:
+// earlier versions used Repeatable Read unless ConsumerGroups, SkipLocked, or the lease mode was enabled.
+// Each query of the consuming transaction sees data committed before the query started,
+// instead of a snapshot taken by the first query of the transaction.
 type PostgreSQLQueueSchema struct {
 	// GenerateWhereClause is a function that returns a where clause and arguments for the SELECT query.
 	// It may be used to filter messages by some condition.
 	// If empty, no where clause will be added.
+	//
+	// Besides the message columns, it can use the delivery tracking columns,
+	// for example "delivery_attempts < 5" skips messages which were already consumed 5 times.
+	// Attempts of deliveries interrupted by a crash are added to delivery_attempts only when the message
+	// is acked, nacked, or not acked within the ack deadline later. SubscriberConfig.MaxDeliveryAttempts
+	// counts them immediately, so it should be used to stop delivering messages which crash the subscriber.
+	//
+	// With ConsumerGroups, the where clause is evaluated against the messages table only.
+	// Delivery tracking is stored per consumer group in the acks table, so it can't be used for filtering.
 	GenerateWhereClause func(params GenerateWhereClauseParams) (string, []any)
 
 	// GeneratePayloadType is the type of the payload column in the messages table.
 	// By default, it's JSON. If your payload is not JSON, you can use BYTEA.
+	//
+	// JSONB may be used for JSON payloads which don't need to be stored byte for byte.
+	// JSONB normalizes the payload: whitespace and the order of keys are not preserved,
+	// and only the last value of duplicate keys is kept.
 	GeneratePayloadType func(topic string) string
 
+	// GenerateMetadataType is the type of the metadata column in the messages table.
+	// By default, it's JSON (or JSONB, see JSONB).
+	GenerateMetadataType func(topic string) string
+
+	// Marshaler converts messages to values of the messages table columns, and back.
+	// Defaults to DefaultMarshaler.
+	Marshaler Marshaler
+
+	// JSONB creates the metadata column as JSONB instead of JSON, unless its type is set with GenerateMetadataType.
+	// JSONB columns are faster to query (for example, with metadata->>'key'), and can be indexed with GIN indexes.
+	// The payload column is not affected, because JSONB doesn't preserve the payload byte for byte,
+	// see GeneratePayloadType.
+	// Existing tables are not converted, see JSONBMigrationQueries.
+	JSONB bool
+
+	// GenerateIndexes returns indexes of the messages table created with the table,
+	// for example PostgreSQLMetadataGINIndex or PostgreSQLMetadataKeyIndex.
+	// Indexes are not dropped when they are removed from the list.
+	GenerateIndexes func(topic string) []PostgreSQLIndex
+
 	// GenerateMessagesTableName may be used to override how the messages table name is generated.
 	GenerateMessagesTableName func(topic string) string
 
+	// Namespace sets the PostgreSQL schema and the prefix of the default table names.
+	// It should be the same as Namespace of PostgreSQLQueueOffsetsAdapter.
+	Namespace PostgreSQLNamespace
+
 	// SubscribeBatchSize is the number of messages to be queried at once.
 	//
 	// Higher value, increases a chance of message re-delivery in case of crash or networking issues.
@@ -37,6 +100,57 @@ type PostgreSQLQueueSchema struct {
 	//
 	// Default value is 100.
 	SubscribeBatchSize int
+
+	// NotifyOnInsert creates a trigger which sends a NOTIFY to the topic's channel after messages are inserted.
+	// It allows Subscriber with PostgreSQLNotifier to wake up without waiting for PollInterval.
+	// The trigger function is created in Namespace, named with its TablePrefix (watermill_notify_insert by default).
+	// Requires PostgreSQL 14 or newer.
+	NotifyOnInsert bool
+
+	// SkipLocked adds SKIP LOCKED to the SELECT ... FOR UPDATE query, so multiple subscribers of the same topic
+	// process disjoint batches of messages concurrently, instead of waiting for each other's locks.
+	// Each message is still delivered exactly once, but order of messages is not guaranteed
+	// when there is more than one subscriber.
+	SkipLocked bool
+
+	// LeaseDuration enables the lease mode, when it's non-zero.
+	//
+	// By default, selected messages are locked with FOR UPDATE in a transaction which is open
+	// until the whole batch is processed. In the lease mode, messages are claimed by setting
+	// the locked_until and locked_by columns, and the claiming transaction is committed immediately.
+	// Each message is then processed without a transaction (see SubscriberConfig.LeasedMessageTx),
+	// and acked in its own short transaction, if it's still leased by the subscriber.
+	// Nacked messages are released, so they can be consumed again.
+	// Messages which are not acked before their lease expires (for example, because the subscriber crashed)
+	// become visible for other subscribers again.
+	//
+	// LeaseDuration should be longer than the subscriber's AckDeadline multiplied by SubscribeBatchSize,
+	// otherwise messages may be delivered more than once.
+	// Order of messages is not guaranteed after a message is nacked.
+	LeaseDuration time.Duration
+
+	// ConsumerGroups enables delivering each message to every registered consumer group.
+	//
+	// A consumer group is registered when it subscribes to the topic for the first time,
+	// and receives messages published after that. Acks, delivery attempts, and the last error are tracked
+	// per consumer group in a separate table. A message is marked as acked (or deleted, with DeleteOnAck
+	// in PostgreSQLQueueOffsetsAdapter) only once all consumer groups registered when it was published acked it.
+	// Messages published before any consumer group was registered are never delivered.
+	//
+	// Subscribing without a consumer group is not possible when ConsumerGroups is enabled.
+	// It can't be used together with LeaseDuration.
+	ConsumerGroups bool
+
+	// GenerateConsumerGroupsTableName may be used to override how the consumer groups table name is generated.
+	// It's used only when ConsumerGroups is enabled.
+	GenerateConsumerGroupsTableName func(topic string) string
+
+	// GenerateAcksTableName may be used to override how the acks table name is generated.
+	// It's used only when ConsumerGroups is enabled.
+	GenerateAcksTableName func(topic string) string
+
+	// GenerateDeliveryAttemptsTableName may be used to override how the delivery attempts table name is generated.
+	GenerateDeliveryAttemptsTableName func(topic string) string
 }
 
 func (s PostgreSQLQueueSchema) SchemaInitializingQueries(params SchemaInitializingQueriesParams) ([]Query, error) {
@@ -45,23 +159,134 @@ func (s PostgreSQLQueueSchema) SchemaInitializingQueries(params SchemaInitializi
 			"offset" SERIAL PRIMARY KEY,
 			"uuid" VARCHAR(36) NOT NULL,
 			"payload" ` + s.payloadColumnType(params.Topic) + ` DEFAULT NULL,
-			"metadata" JSON DEFAULT NULL,
+			"metadata" ` + s.metadataColumnType(params.Topic) + ` DEFAULT NULL,
 			"acked" BOOLEAN NOT NULL DEFAULT FALSE,
+			"created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			"delivery_attempts" INTEGER NOT NULL DEFAULT 0,
+			"last_attempt_at" TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+			"last_error" TEXT DEFAULT NULL,
+			"locked_until" TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+			"locked_by" BYTEA DEFAULT NULL` + marshalerColumnsDefinitions(s.marshaler().ExtraColumns(params.Topic), `"`) + `
+		);
+	`
+
+	queries := append(
+		s.Namespace.schemaInitializingQueries(),
+		Query{Query: createMessagesTable},
+		Query{Query: s.addDeliveryTrackingColumnsQuery(params.Topic)},
+		Query{Query: s.createDeliveryAttemptsTableQuery(params.Topic)},
+	)
+	if s.GenerateIndexes != nil {
+		indexQueries, err := postgreSQLIndexQueries(s.MessagesTable(params.Topic), s.GenerateIndexes(params.Topic))
+		if err != nil {
+			return nil, fmt.Errorf("invalid index: %w", err)
+		}
+
+		queries = append(queries, indexQueries...)
+	}
+	if s.ConsumerGroups {
+		queries = append(queries, s.consumerGroupsInitializingQueries(params.Topic)...)
+	}
+	if s.NotifyOnInsert {
+		queries = append(queries, postgreSQLNotifyOnInsertQueries(s.Namespace, s.MessagesTable(params.Topic), params.Topic)...)
+	}
+
+	return queries, nil
+}
+
+// SchemaMigrations returns migrations of the queue tables, see SchemaMigrator.
+func (s PostgreSQLQueueSchema) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := s.SchemaInitializingQueries(SchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	addAckedColumn := `
+		ALTER TABLE ` + s.MessagesTable(topic) + `
+			ADD COLUMN IF NOT EXISTS "acked" BOOLEAN NOT NULL DEFAULT FALSE;
+	`
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create messages table", Queries: createQueries},
+		{Version: 2, Description: "add acked column", Queries: []Query{{Query: addAckedColumn}}},
+		{Version: 3, Description: "add delivery tracking and lease columns", Queries: []Query{{Query: s.addDeliveryTrackingColumnsQuery(topic)}}},
+		{Version: 4, Description: "create delivery attempts table", Queries: []Query{{Query: s.createDeliveryAttemptsTableQuery(topic)}}},
+	}, nil
+}
+
+// createDeliveryAttemptsTableQuery creates the table with delivery attempts recorded outside the consuming transaction.
+// consumer_group is empty when ConsumerGroups is disabled.
+func (s PostgreSQLQueueSchema) createDeliveryAttemptsTableQuery(topic string) string {
+	return `
+		CREATE TABLE IF NOT EXISTS ` + s.DeliveryAttemptsTable(topic) + ` (
+			"consumer_group" VARCHAR(255) NOT NULL,
+			"offset" INTEGER NOT NULL,
+			"delivery_attempts" INTEGER NOT NULL,
+			"last_attempt_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY ("consumer_group", "offset")
+		);
+	`
+}
+
+// addDeliveryTrackingColumnsQuery adds columns missing in tables created before delivery tracking and leases were added.
+func (s PostgreSQLQueueSchema) addDeliveryTrackingColumnsQuery(topic string) string {
+	return `
+		ALTER TABLE ` + s.MessagesTable(topic) + `
+			ADD COLUMN IF NOT EXISTS "delivery_attempts" INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS "last_attempt_at" TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+			ADD COLUMN IF NOT EXISTS "last_error" TEXT DEFAULT NULL,
+			ADD COLUMN IF NOT EXISTS "locked_until" TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+			ADD COLUMN IF NOT EXISTS "locked_by" BYTEA DEFAULT NULL;
+	`
+}
+
+func (s PostgreSQLQueueSchema) consumerGroupsInitializingQueries(topic string) []Query {
+	addPendingGroupsColumn := `
+		ALTER TABLE ` + s.MessagesTable(topic) + `
+			ADD COLUMN IF NOT EXISTS "pending_groups" INTEGER NOT NULL DEFAULT 0;
+	`
+
+	createConsumerGroupsTable := `
+		CREATE TABLE IF NOT EXISTS ` + s.ConsumerGroupsTable(topic) + ` (
+			"consumer_group" VARCHAR(255) NOT NULL PRIMARY KEY,
 			"created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);
 	`
 
-	return []Query{{Query: createMessagesTable}}, nil
+	createAcksTable := `
+		CREATE TABLE IF NOT EXISTS ` + s.AcksTable(topic) + ` (
+			"consumer_group" VARCHAR(255) NOT NULL,
+			"offset" INTEGER NOT NULL,
+			"acked" BOOLEAN NOT NULL DEFAULT FALSE,
+			"delivery_attempts" INTEGER NOT NULL DEFAULT 0,
+			"last_attempt_at" TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+			"last_error" TEXT DEFAULT NULL,
+			PRIMARY KEY ("consumer_group", "offset")
+		);
+	`
+
+	return []Query{
+		{Query: addPendingGroupsColumn},
+		{Query: createConsumerGroupsTable},
+		{Query: createAcksTable},
+	}
 }
 
 func (s PostgreSQLQueueSchema) InsertQuery(params InsertQueryParams) (Query, error) {
+	if s.ConsumerGroups {
+		return s.consumerGroupsInsertQuery(params)
+	}
+
+	extraColumns := s.marshaler().ExtraColumns(params.Topic)
+
 	insertQuery := fmt.Sprintf(
-		`INSERT INTO %s (uuid, payload, metadata) VALUES %s`,
+		`INSERT INTO %s (uuid, payload, metadata%s) VALUES %s`,
 		s.MessagesTable(params.Topic),
-		queueInsertMarkers(len(params.Msgs)),
+		marshalerColumnsList(extraColumns, `"`, ""),
+		postgreSQLInsertMarkers(len(params.Msgs), 3+len(extraColumns)),
 	)
 
-	args, err := defaultInsertArgs(params.Msgs)
+	args, err := marshalMessages(s.marshaler(), params.Topic, params.Msgs)
 	if err != nil {
 		return Query{}, err
 	}
@@ -69,13 +294,57 @@ func (s PostgreSQLQueueSchema) InsertQuery(params InsertQueryParams) (Query, err
 	return Query{insertQuery, args}, nil
 }
 
-func queueInsertMarkers(count int) string {
+// consumerGroupsInsertQuery inserts the messages together with an ack row for each registered consumer group.
+// Both statements use the same snapshot, so pending_groups always matches the number of inserted ack rows.
+func (s PostgreSQLQueueSchema) consumerGroupsInsertQuery(params InsertQueryParams) (Query, error) {
+	extraColumns := s.marshaler().ExtraColumns(params.Topic)
+
+	insertQuery := fmt.Sprintf(
+		`WITH inserted AS (
+			INSERT INTO %s (uuid, payload, metadata%s, pending_groups)
+			SELECT v.uuid, v.payload, v.metadata%s, (SELECT COUNT(*) FROM %s)
+			FROM (VALUES %s) AS v (uuid, payload, metadata%s)
+			RETURNING "offset"
+		)
+		INSERT INTO %s (consumer_group, "offset")
+		SELECT g.consumer_group, i."offset" FROM inserted i CROSS JOIN %s g`,
+		s.MessagesTable(params.Topic),
+		marshalerColumnsList(extraColumns, `"`, ""),
+		marshalerColumnsList(extraColumns, `"`, "v."),
+		s.ConsumerGroupsTable(params.Topic),
+		s.consumerGroupsInsertMarkers(params.Topic, len(params.Msgs)),
+		marshalerColumnsList(extraColumns, `"`, ""),
+		s.AcksTable(params.Topic),
+		s.ConsumerGroupsTable(params.Topic),
+	)
+
+	args, err := marshalMessages(s.marshaler(), params.Topic, params.Msgs)
+	if err != nil {
+		return Query{}, err
+	}
+
+	return Query{insertQuery, args}, nil
+}
+
+// consumerGroupsInsertMarkers returns VALUES markers with explicit casts,
+// because types of columns can't be inferred from the VALUES list.
+func (s PostgreSQLQueueSchema) consumerGroupsInsertMarkers(topic string, count int) string {
 	result := strings.Builder{}
 
+	types := []string{"VARCHAR(36)", s.payloadColumnType(topic), s.metadataColumnType(topic)}
+	for _, column := range s.marshaler().ExtraColumns(topic) {
+		types = append(types, column.Type)
+	}
+
 	index := 1
 	for i := 0; i < count; i++ {
-		result.WriteString(fmt.Sprintf("($%d,$%d,$%d),", index, index+1, index+2))
-		index += 3
+		markers := make([]string, len(types))
+		for j, columnType := range types {
+			markers[j] = fmt.Sprintf("$%d::%s", index, columnType)
+			index++
+		}
+
+		result.WriteString("(" + strings.Join(markers, ",") + "),")
 	}
 
 	return strings.TrimRight(result.String(), ",")
@@ -90,12 +359,20 @@ func (s PostgreSQLQueueSchema) batchSize() int {
 }
 
 func (s PostgreSQLQueueSchema) SelectQuery(params SelectQueryParams) (Query, error) {
-	if params.ConsumerGroup != "" {
-		return Query{}, errors.New("consumer groups are not supported in PostgreSQLQueueSchema")
+	if s.ConsumerGroups {
+		if params.ConsumerGroup == "" {
+			return Query{}, errors.New("consumer group is required when ConsumerGroups is enabled in PostgreSQLQueueSchema")
+		}
+		if s.LeaseDuration > 0 {
+			return Query{}, errors.New("lease mode is not supported with ConsumerGroups in PostgreSQLQueueSchema")
+		}
+	} else if params.ConsumerGroup != "" {
+		return Query{}, errors.New("consumer groups are not supported in PostgreSQLQueueSchema without ConsumerGroups enabled")
 	}
 
 	whereParams := GenerateWhereClauseParams{
-		Topic: params.Topic,
+		Topic:         params.Topic,
+		ConsumerGroup: params.ConsumerGroup,
 	}
 
 	var where string
@@ -108,35 +385,131 @@ func (s PostgreSQLQueueSchema) SelectQuery(params SelectQueryParams) (Query, err
 		}
 	}
 
+	if s.LeaseDuration > 0 {
+		return s.leaseQuery(params, where, args), nil
+	}
+
+	if s.ConsumerGroups {
+		return s.consumerGroupSelectQuery(params, where, args), nil
+	}
+
+	lock := "FOR UPDATE"
+	if s.SkipLocked {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+
 	selectQuery := `
-		SELECT "offset", uuid, payload, metadata FROM ` + s.MessagesTable(params.Topic) + `
+		SELECT "offset", uuid, payload, metadata, delivery_attempts, last_error` +
+		marshalerColumnsList(s.marshaler().ExtraColumns(params.Topic), `"`, "") + `
+		FROM ` + s.MessagesTable(params.Topic) + `
 		WHERE acked = false ` + where + `
 		ORDER BY
 			"offset" ASC
 		LIMIT ` + fmt.Sprintf("%d", s.batchSize()) + `
-		FOR UPDATE`
+		` + lock
 
 	return Query{selectQuery, args}, nil
 }
 
+// consumerGroupSelectQuery selects messages not acked by the consumer group.
+// Only the consumer group's ack rows are locked, so consumer groups don't block each other.
+func (s PostgreSQLQueueSchema) consumerGroupSelectQuery(params SelectQueryParams, where string, args []any) Query {
+	table := s.MessagesTable(params.Topic)
+
+	// the where clause is evaluated against the messages table only, to not make column names ambiguous
+	if where != "" {
+		where = `AND a."offset" IN (SELECT "offset" FROM ` + table + ` WHERE TRUE ` + where + `)`
+	}
+
+	lock := "FOR UPDATE OF a"
+	if s.SkipLocked {
+		lock = "FOR UPDATE OF a SKIP LOCKED"
+	}
+
+	selectQuery := `
+		SELECT a."offset", m.uuid, m.payload, m.metadata, a.delivery_attempts, a.last_error` +
+		marshalerColumnsList(s.marshaler().ExtraColumns(params.Topic), `"`, "m.") + `
+		FROM ` + s.AcksTable(params.Topic) + ` a
+		JOIN ` + table + ` m ON m."offset" = a."offset"
+		WHERE a.consumer_group = $` + strconv.Itoa(len(args)+1) + ` AND a.acked = false ` + where + `
+		ORDER BY
+			a."offset" ASC
+		LIMIT ` + fmt.Sprintf("%d", s.batchSize()) + `
+		` + lock
+
+	args = append(args, params.ConsumerGroup)
+
+	return Query{selectQuery, args}
+}
+
+// leaseQuery claims the next messages, skipping messages which are being claimed by other subscribers.
+// delivery_attempts is incremented in the same query, so it's persisted even if the subscriber crashes.
+func (s PostgreSQLQueueSchema) leaseQuery(params SelectQueryParams, where string, args []any) Query {
+	table := s.MessagesTable(params.Topic)
+
+	leaseSecondsArg := len(args) + 1
+	lockedByArg := len(args) + 2
+
+	leaseQuery := `
+		WITH claimed AS (
+			UPDATE ` + table + `
+			SET
+				locked_until = NOW() + make_interval(secs => $` + strconv.Itoa(leaseSecondsArg) + `),
+				locked_by = $` + strconv.Itoa(lockedByArg) + `,
+				delivery_attempts = delivery_attempts + 1,
+				last_attempt_at = NOW()
+			WHERE "offset" IN (
+				SELECT "offset" FROM ` + table + `
+				WHERE acked = false AND (locked_until IS NULL OR locked_until < NOW()) ` + where + `
+				ORDER BY
+					"offset" ASC
+				LIMIT ` + fmt.Sprintf("%d", s.batchSize()) + `
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING "offset", uuid, payload, metadata, delivery_attempts - 1, last_error` +
+		marshalerColumnsList(s.marshaler().ExtraColumns(params.Topic), `"`, "") + `
+		)
+		SELECT * FROM claimed
+		ORDER BY
+			"offset" ASC`
+
+	args = append(args, s.LeaseDuration.Seconds(), params.ConsumerULID)
+
+	return Query{leaseQuery, args}
+}
+
 func (s PostgreSQLQueueSchema) UnmarshalMessage(params UnmarshalMessageParams) (Row, error) {
 	r := Row{}
-
-	err := params.Row.Scan(&r.Offset, &r.UUID, &r.Payload, &r.Metadata)
+	var deliveryAttempts int
+	var lastError sql.NullString
+
+	err := unmarshalRow(
+		s.marshaler(),
+		params.Topic,
+		params.Row,
+		&r,
+		&r.Offset, &r.UUID, &r.Payload, &r.Metadata, &deliveryAttempts, &lastError,
+	)
 	if err != nil {
-		return Row{}, fmt.Errorf("could not scan message row: %w", err)
+		return Row{}, err
 	}
 
-	msg := message.NewMessage(string(r.UUID), r.Payload)
+	msg := r.Msg
+	if msg.Metadata == nil {
+		msg.Metadata = message.Metadata{}
+	}
 
-	if r.Metadata != nil {
-		err = json.Unmarshal(r.Metadata, &msg.Metadata)
-		if err != nil {
-			return Row{}, fmt.Errorf("could not unmarshal metadata as JSON: %w", err)
-		}
+	// the current delivery is not counted yet, Subscriber updates the number
+	// with attempts recorded by DeliveryAttemptQuery of PostgreSQLQueueOffsetsAdapter
+	msg.Metadata.Set(DeliveryAttemptsMetadataKey, strconv.Itoa(deliveryAttempts+1))
+	if lastError.Valid {
+		msg.Metadata.Set(LastErrorMetadataKey, lastError.String)
 	}
 
-	r.Msg = msg
+	if r.ExtraData == nil {
+		r.ExtraData = map[string]any{}
+	}
+	r.ExtraData["delivery_attempts"] = deliveryAttempts
 
 	return r, nil
 }
@@ -145,12 +518,44 @@ func (s PostgreSQLQueueSchema) MessagesTable(topic string) string {
 	if s.GenerateMessagesTableName != nil {
 		return s.GenerateMessagesTableName(topic)
 	}
-	return fmt.Sprintf(`"watermill_%s"`, topic)
+	return s.Namespace.table(topic)
+}
+
+func (s PostgreSQLQueueSchema) ConsumerGroupsTable(topic string) string {
+	if s.GenerateConsumerGroupsTableName != nil {
+		return s.GenerateConsumerGroupsTableName(topic)
+	}
+	return s.Namespace.table("consumer_groups_" + topic)
+}
+
+func (s PostgreSQLQueueSchema) AcksTable(topic string) string {
+	if s.GenerateAcksTableName != nil {
+		return s.GenerateAcksTableName(topic)
+	}
+	return s.Namespace.table("acks_" + topic)
+}
+
+func (s PostgreSQLQueueSchema) DeliveryAttemptsTable(topic string) string {
+	if s.GenerateDeliveryAttemptsTableName != nil {
+		return s.GenerateDeliveryAttemptsTableName(topic)
+	}
+	return s.Namespace.table("delivery_attempts_" + topic)
 }
 
 func (s PostgreSQLQueueSchema) SubscribeIsolationLevel() sql.IsolationLevel {
-	// For Postgres Repeatable Read is enough.
-	return sql.LevelRepeatableRead
+	// Delivery attempts are recorded by a separate connection after the consuming transaction started,
+	// so they are not visible to the ack queries with Repeatable Read.
+	//
+	// Read Committed is also required by consumer groups (acking the same message at the same time would fail
+	// with a serialization error when decrementing pending_groups), and by SkipLocked and the lease mode
+	// (locking rows updated by other subscribers after the snapshot was taken would fail instead of skipping them).
+	// Messages locked by other subscribers are checked again after they are released,
+	// so acked messages are not selected.
+	return sql.LevelReadCommitted
+}
+
+func (s PostgreSQLQueueSchema) LeasesMessages() bool {
+	return s.LeaseDuration > 0
 }
 
 func (s PostgreSQLQueueSchema) payloadColumnType(topic string) string {
@@ -160,3 +565,32 @@ func (s PostgreSQLQueueSchema) payloadColumnType(topic string) string {
 
 	return s.GeneratePayloadType(topic)
 }
+
+func (s PostgreSQLQueueSchema) metadataColumnType(topic string) string {
+	if s.GenerateMetadataType == nil {
+		return s.jsonColumnType()
+	}
+
+	return s.GenerateMetadataType(topic)
+}
+
+func (s PostgreSQLQueueSchema) jsonColumnType() string {
+	if s.JSONB {
+		return "JSONB"
+	}
+
+	return "JSON"
+}
+
+func (s PostgreSQLQueueSchema) marshaler() Marshaler {
+	return marshalerOrDefault(s.Marshaler)
+}
+
+func (s PostgreSQLQueueSchema) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:               DialectPostgreSQL,
+		AcksPerMessage:        true,
+		ConsumerGroups:        s.ConsumerGroups,
+		ConsumerGroupRequired: s.ConsumerGroups,
+	}
+}
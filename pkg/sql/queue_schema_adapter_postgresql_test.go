@@ -78,3 +78,420 @@ func TestPostgreSQLQueueSchemaAdapter(t *testing.T) {
 		assert.Equal(t, id%2, 0)
 	}
 }
+
+func TestPostgreSQLQueueSchemaAdapter_delivery_attempts(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		GenerateWhereClause: func(params sql.GenerateWhereClauseParams) (string, []any) {
+			return "delivery_attempts < $1", []any{2}
+		},
+	}
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        schemaAdapter,
+		AutoInitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	ackDeadline := 500 * time.Millisecond
+
+	sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		PollInterval:     10 * time.Millisecond,
+		AckDeadline:      &ackDeadline,
+		SchemaAdapter:    schemaAdapter,
+		OffsetsAdapter:   sql.PostgreSQLQueueOffsetsAdapter{},
+		InitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	topic := watermill.NewUUID()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	err = pub.Publish(topic, message.NewMessage(watermill.NewUUID(), []byte("{}")))
+	require.NoError(t, err)
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "1", msg.Metadata.Get(sql.DeliveryAttemptsMetadataKey))
+		assert.Empty(t, msg.Metadata.Get(sql.LastErrorMetadataKey))
+		// not acking, waiting for the ack deadline
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected to receive message")
+	}
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "2", msg.Metadata.Get(sql.DeliveryAttemptsMetadataKey))
+		assert.Equal(t, "ack deadline exceeded", msg.Metadata.Get(sql.LastErrorMetadataKey))
+		// not acking again
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected to receive message after the ack deadline")
+	}
+
+	// the message was consumed twice, so it's skipped by the where clause
+	select {
+	case msg := <-messages:
+		t.Fatalf("message should be skipped, got delivery attempt %s", msg.Metadata.Get(sql.DeliveryAttemptsMetadataKey))
+	case <-time.After(ackDeadline * 3):
+	}
+}
+
+func TestPostgreSQLQueueSchemaAdapter_delivery_attempts_nacked(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		GenerateWhereClause: func(params sql.GenerateWhereClauseParams) (string, []any) {
+			return "delivery_attempts < $1", []any{3}
+		},
+	}
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        schemaAdapter,
+		AutoInitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		PollInterval:     10 * time.Millisecond,
+		ResendInterval:   10 * time.Millisecond,
+		SchemaAdapter:    schemaAdapter,
+		OffsetsAdapter:   sql.PostgreSQLQueueOffsetsAdapter{},
+		InitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	topic := watermill.NewUUID()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	err = pub.Publish(topic, message.NewMessage(watermill.NewUUID(), []byte("{}")))
+	require.NoError(t, err)
+
+	deliveryAttempts := func(t require.TestingT) int {
+		rows, err := db.QueryContext(
+			context.Background(),
+			`SELECT delivery_attempts FROM `+schemaAdapter.MessagesTable(topic),
+		)
+		require.NoError(t, err)
+
+		var attempts int
+		require.True(t, rows.Next())
+		require.NoError(t, rows.Scan(&attempts))
+		require.NoError(t, rows.Close())
+
+		return attempts
+	}
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case msg := <-messages:
+			assert.Equal(t, strconv.Itoa(i), msg.Metadata.Get(sql.DeliveryAttemptsMetadataKey))
+			// the previous nacks were persisted before the message was selected again
+			assert.Equal(t, i-1, deliveryAttempts(t))
+			msg.Nack()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected to receive message, attempt %d", i)
+		}
+	}
+
+	assert.EventuallyWithT(t, func(t *assert.CollectT) {
+		assert.Equal(t, 3, deliveryAttempts(t))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the message was nacked three times, so it's skipped by the where clause
+	select {
+	case msg := <-messages:
+		t.Fatalf("message should be skipped, got delivery attempt %s", msg.Metadata.Get(sql.DeliveryAttemptsMetadataKey))
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestPostgreSQLQueueSchemaAdapter_lease_expires(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		LeaseDuration: time.Second,
+	}
+	offsetsAdapter := sql.PostgreSQLQueueOffsetsAdapter{}
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        schemaAdapter,
+		AutoInitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	topic := watermill.NewUUID()
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+	require.NoError(t, pub.Publish(topic, msg))
+
+	// simulating a subscriber which claimed the message and crashed
+	leaseQuery, err := schemaAdapter.SelectQuery(sql.SelectQueryParams{
+		Topic:          topic,
+		OffsetsAdapter: offsetsAdapter,
+		ConsumerULID:   []byte("crashed"),
+	})
+	require.NoError(t, err)
+
+	rows, err := db.QueryContext(context.Background(), leaseQuery.Query, leaseQuery.Args...)
+	require.NoError(t, err)
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Close())
+
+	sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		PollInterval:   10 * time.Millisecond,
+		SchemaAdapter:  schemaAdapter,
+		OffsetsAdapter: offsetsAdapter,
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	select {
+	case received := <-messages:
+		assert.Equal(t, msg.UUID, received.UUID)
+		assert.Equal(t, "2", received.Metadata.Get(sql.DeliveryAttemptsMetadataKey))
+		assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond, "message should be leased")
+		received.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("message should be received after the lease expired")
+	}
+}
+
+func TestPostgreSQLQueueSchemaAdapter_lease_lost(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name            string
+		LeasedMessageTx bool
+	}{
+		{Name: "without_tx"},
+		{Name: "with_tx", LeasedMessageTx: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newPostgreSQL(t)
+
+			schemaAdapter := sql.PostgreSQLQueueSchema{
+				LeaseDuration: time.Minute,
+			}
+
+			pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+				SchemaAdapter:        schemaAdapter,
+				AutoInitializeSchema: true,
+			}, logger)
+			require.NoError(t, err)
+
+			sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+				PollInterval:    10 * time.Millisecond,
+				SchemaAdapter:   schemaAdapter,
+				OffsetsAdapter:  sql.PostgreSQLQueueOffsetsAdapter{},
+				LeasedMessageTx: tc.LeasedMessageTx,
+			}, logger)
+			require.NoError(t, err)
+
+			topic := watermill.NewUUID()
+
+			msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+			require.NoError(t, pub.Publish(topic, msg))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			messages, err := sub.Subscribe(ctx, topic)
+			require.NoError(t, err)
+
+			select {
+			case received := <-messages:
+				// simulating another subscriber which claimed the message after the lease expired
+				_, err = db.ExecContext(
+					context.Background(),
+					`UPDATE `+schemaAdapter.MessagesTable(topic)+` SET locked_by = $1`,
+					[]byte("other"),
+				)
+				require.NoError(t, err)
+
+				tx, ok := sql.TxFromContext(received.Context())
+				assert.Equal(t, tc.LeasedMessageTx, ok, "the handler should run in a transaction only with LeasedMessageTx")
+
+				if ok {
+					// rolled back, because the lease was lost
+					_, err := tx.ExecContext(
+						received.Context(),
+						`UPDATE `+schemaAdapter.MessagesTable(topic)+` SET metadata = '{"handled": "true"}'`,
+					)
+					require.NoError(t, err)
+				}
+
+				received.Ack()
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected to receive message")
+			}
+
+			// the ack is ignored, and the lease of the other subscriber is not released
+			assert.Never(t, func() bool {
+				rows, err := db.QueryContext(
+					context.Background(),
+					`SELECT acked OR locked_by IS NULL OR metadata::text LIKE '%handled%' FROM `+schemaAdapter.MessagesTable(topic),
+				)
+				if err != nil {
+					return true
+				}
+				defer rows.Close()
+
+				var changed bool
+				return !rows.Next() || rows.Scan(&changed) != nil || changed
+			}, 500*time.Millisecond, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestPostgreSQLQueueSchemaAdapter_consumer_groups(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+
+	schemaAdapter := sql.PostgreSQLQueueSchema{
+		ConsumerGroups: true,
+	}
+	offsetsAdapter := sql.PostgreSQLQueueOffsetsAdapter{
+		DeleteOnAck: true,
+	}
+
+	pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter: schemaAdapter,
+	}, logger)
+	require.NoError(t, err)
+
+	topic := watermill.NewUUID()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscribe := func(consumerGroup string) <-chan *message.Message {
+		sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+			ConsumerGroup:    consumerGroup,
+			PollInterval:     10 * time.Millisecond,
+			SchemaAdapter:    schemaAdapter,
+			OffsetsAdapter:   offsetsAdapter,
+			InitializeSchema: true,
+		}, logger)
+		require.NoError(t, err)
+
+		messages, err := sub.Subscribe(ctx, topic)
+		require.NoError(t, err)
+
+		return messages
+	}
+
+	messagesA := subscribe("a")
+	messagesB := subscribe("b")
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+	require.NoError(t, pub.Publish(topic, msg))
+
+	messageExists := func() bool {
+		rows, err := db.QueryContext(
+			context.Background(),
+			`SELECT 1 FROM `+schemaAdapter.MessagesTable(topic)+` WHERE uuid = $1`,
+			msg.UUID,
+		)
+		require.NoError(t, err)
+		defer rows.Close()
+
+		return rows.Next()
+	}
+
+	select {
+	case received := <-messagesA:
+		assert.Equal(t, msg.UUID, received.UUID)
+		received.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received by consumer group a")
+	}
+
+	// consumer group b didn't ack the message yet
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, messageExists())
+
+	select {
+	case received := <-messagesB:
+		assert.Equal(t, msg.UUID, received.UUID)
+		received.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received by consumer group b")
+	}
+
+	assert.Eventually(t, func() bool {
+		return !messageExists()
+	}, 5*time.Second, 10*time.Millisecond, "message should be deleted after all consumer groups acked it")
+
+	select {
+	case received := <-messagesA:
+		t.Fatalf("message %s should be delivered to consumer group a once", received.UUID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPostgreSQLQueueOffsetsAdapter_DeleteOnAck_queries(t *testing.T) {
+	t.Parallel()
+
+	offsetsAdapter := sql.PostgreSQLQueueOffsetsAdapter{
+		DeleteOnAck: true,
+		Namespace:   sql.PostgreSQLNamespace{Schema: "events", TablePrefix: "wm_"},
+	}
+
+	// subscribing doesn't execute DDL statements, which could fail when subscribers start at the same time
+	queries, err := offsetsAdapter.BeforeSubscribingQueries(sql.BeforeSubscribingQueriesParams{
+		Topic:         "topic",
+		ConsumerGroup: "group",
+	})
+	require.NoError(t, err)
+	require.Len(t, queries, 1)
+	assert.Contains(t, queries[0].Query, "INSERT INTO")
+
+	queries, err = offsetsAdapter.SchemaInitializingQueries(sql.OffsetsSchemaInitializingQueriesParams{
+		Topic:         "topic",
+		ConsumerGroup: "group",
+	})
+	require.NoError(t, err)
+	require.Len(t, queries, 3)
+	assert.Contains(t, queries[0].Query, `CREATE OR REPLACE FUNCTION "events"."wm_delete_acked"()`)
+	assert.Contains(t, queries[1].Query, `EXECUTE FUNCTION "events"."wm_delete_acked"()`)
+
+	migrations, err := offsetsAdapter.SchemaMigrations("topic")
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+	assert.Equal(t, queries[:2], migrations[0].Queries)
+
+	migrations, err = sql.PostgreSQLQueueOffsetsAdapter{}.SchemaMigrations("topic")
+	require.NoError(t, err)
+	assert.Empty(t, migrations)
+}
@@ -20,8 +20,16 @@ func initializeSchema(
 	db ContextExecutor,
 	schemaAdapter SchemaAdapter,
 	offsetsAdapter OffsetsAdapter,
+	consumerGroup string,
+	versions SchemaVersionsConfig,
 ) error {
-	err := validateTopicName(topic)
+	err := validateTopic(topic, schemaAdapter, offsetsAdapter)
+	if err != nil {
+		return err
+	}
+
+	// migrations are applied first, because the initializing queries may depend on the current layout
+	recordVersions, err := initializeSchemaVersions(ctx, topic, logger, db, schemaAdapter, offsetsAdapter, versions)
 	if err != nil {
 		return err
 	}
@@ -35,7 +43,8 @@ func initializeSchema(
 
 	if offsetsAdapter != nil {
 		queries, err := offsetsAdapter.SchemaInitializingQueries(OffsetsSchemaInitializingQueriesParams{
-			Topic: topic,
+			Topic:         topic,
+			ConsumerGroup: consumerGroup,
 		})
 		if err != nil {
 			return fmt.Errorf("could not generate offset adapter's schema initializing queries: %w", err)
@@ -54,7 +63,11 @@ func initializeSchema(
 		}
 	}
 
-	return initialise(ctx, db, initializingQueries)
+	if err := initialise(ctx, db, initializingQueries); err != nil {
+		return err
+	}
+
+	return recordVersions(ctx)
 }
 
 func initialise(ctx context.Context, db ContextExecutor, initializingQueries []Query) error {
@@ -2,8 +2,6 @@ package sql
 
 import (
 	"database/sql"
-	"encoding/json"
-	"fmt"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 )
@@ -17,10 +15,12 @@ type SelectQueryParams struct {
 	Topic          string
 	ConsumerGroup  string
 	OffsetsAdapter OffsetsAdapter
+	ConsumerULID   []byte
 }
 
 type UnmarshalMessageParams struct {
-	Row Scanner
+	Topic string
+	Row   Scanner
 }
 
 type SchemaInitializingQueriesParams struct {
@@ -49,6 +49,17 @@ type SchemaAdapter interface {
 	SubscribeIsolationLevel() sql.IsolationLevel
 }
 
+// LeasesMessages may be implemented by SchemaAdapter which claims messages in SelectQuery instead of locking them.
+//
+// When LeasesMessages returns true, the Subscriber commits the transaction right after SelectQuery,
+// and delivers each message without a transaction (see SubscriberConfig.LeasedMessageTx).
+// The message is acked in its own short transaction, and AckMessageQuery must check that the message
+// is still leased by the consumer (see AckMessageQueryParams.ConsumerULID).
+// When the message is not acked, OffsetsAdapter should release it with NotAckedMessageQuery.
+type LeasesMessages interface {
+	LeasesMessages() bool
+}
+
 // Deprecated: Use DefaultMySQLSchema instead.
 type DefaultSchema = DefaultMySQLSchema
 
@@ -60,19 +71,9 @@ type Row struct {
 
 	Msg *message.Message
 
-	ExtraData map[string]any
-}
-
-func defaultInsertArgs(msgs message.Messages) ([]interface{}, error) {
-	var args []interface{}
-	for _, msg := range msgs {
-		metadata, err := json.Marshal(msg.Metadata)
-		if err != nil {
-			return nil, fmt.Errorf("could not marshal metadata into JSON for message %s: %w", msg.UUID, err)
-		}
+	// Skipped is set by SchemaAdapter for messages which should not be delivered to the consumer group,
+	// for example because they don't match its filter. They are acked together with the delivered messages.
+	Skipped bool
 
-		args = append(args, msg.UUID, []byte(msg.Payload), metadata)
-	}
-
-	return args, nil
+	ExtraData map[string]any
 }
@@ -2,11 +2,8 @@ package sql
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"strings"
-
-	"github.com/ThreeDotsLabs/watermill/message"
 )
 
 // DefaultMySQLSchema is a default implementation of SchemaAdapter based on MySQL.
@@ -39,10 +36,23 @@ type DefaultMySQLSchema struct {
 	// GenerateMessagesTableName may be used to override how the messages table name is generated.
 	GenerateMessagesTableName func(topic string) string
 
+	// ParseMessagesTableName reverses GenerateMessagesTableName: it returns the topic of the messages table name
+	// (without quotes), or false if the table doesn't belong to any topic. It's used by Subscriber.SubscribePattern,
+	// and required by it when GenerateMessagesTableName is set.
+	ParseMessagesTableName func(table string) (string, bool)
+
 	// GeneratePayloadType is the type of the payload column in the messages table.
 	// By default, it's JSON. If your payload is not JSON, you can use BYTEA.
 	GeneratePayloadType func(topic string) string
 
+	// GenerateMetadataType is the type of the metadata column in the messages table.
+	// By default, it's JSON.
+	GenerateMetadataType func(topic string) string
+
+	// Marshaler converts messages to values of the messages table columns, and back.
+	// Defaults to DefaultMarshaler.
+	Marshaler Marshaler
+
 	// SubscribeBatchSize is the number of messages to be queried at once.
 	//
 	// Higher value, increases a chance of message re-delivery in case of crash or networking issues.
@@ -59,21 +69,37 @@ func (s DefaultMySQLSchema) SchemaInitializingQueries(params SchemaInitializingQ
 		"`uuid` VARCHAR(36) NOT NULL,",
 		"`created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,",
 		"`payload` " + s.PayloadColumnType(params.Topic) + " DEFAULT NULL,",
-		"`metadata` JSON DEFAULT NULL",
+		"`metadata` " + s.MetadataColumnType(params.Topic) + " DEFAULT NULL" +
+			marshalerColumnsDefinitions(s.marshaler().ExtraColumns(params.Topic), "`"),
 		");",
 	}, "\n")
 
 	return []Query{{Query: createMessagesTable}}, nil
 }
 
+// SchemaMigrations returns migrations of the messages table, see SchemaMigrator.
+func (s DefaultMySQLSchema) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := s.SchemaInitializingQueries(SchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create messages table", Queries: createQueries},
+	}, nil
+}
+
 func (s DefaultMySQLSchema) InsertQuery(params InsertQueryParams) (Query, error) {
+	extraColumns := s.marshaler().ExtraColumns(params.Topic)
+
 	insertQuery := fmt.Sprintf(
-		`INSERT INTO %s (uuid, payload, metadata) VALUES %s`,
+		`INSERT INTO %s (uuid, payload, metadata%s) VALUES %s`,
 		s.MessagesTable(params.Topic),
-		strings.TrimRight(strings.Repeat(`(?,?,?),`, len(params.Msgs)), ","),
+		marshalerColumnsList(extraColumns, "`", ""),
+		questionMarkInsertMarkers(len(params.Msgs), 3+len(extraColumns)),
 	)
 
-	args, err := defaultInsertArgs(params.Msgs)
+	args, err := marshalMessages(s.marshaler(), params.Topic, params.Msgs)
 	if err != nil {
 		return Query{}, err
 	}
@@ -101,7 +127,9 @@ func (s DefaultMySQLSchema) SelectQuery(params SelectQueryParams) (Query, error)
 
 	// It's important to wrap offset with "`" for MariaDB.
 	// See https://github.com/ThreeDotsLabs/watermill/issues/377
-	selectQuery := "SELECT `offset`, `uuid`, `payload`, `metadata` FROM " + s.MessagesTable(params.Topic) +
+	selectQuery := "SELECT `offset`, `uuid`, `payload`, `metadata`" +
+		marshalerColumnsList(s.marshaler().ExtraColumns(params.Topic), "`", "") +
+		" FROM " + s.MessagesTable(params.Topic) +
 		" WHERE `offset` > (" + nextOffsetQuery.Query + ") ORDER BY `offset` ASC" +
 		` LIMIT ` + fmt.Sprintf("%d", s.batchSize())
 
@@ -110,22 +138,11 @@ func (s DefaultMySQLSchema) SelectQuery(params SelectQueryParams) (Query, error)
 
 func (s DefaultMySQLSchema) UnmarshalMessage(params UnmarshalMessageParams) (Row, error) {
 	r := Row{}
-	err := params.Row.Scan(&r.Offset, &r.UUID, &r.Payload, &r.Metadata)
+	err := unmarshalRow(s.marshaler(), params.Topic, params.Row, &r, &r.Offset, &r.UUID, &r.Payload, &r.Metadata)
 	if err != nil {
-		return Row{}, fmt.Errorf("could not scan message row: %w", err)
-	}
-
-	msg := message.NewMessage(string(r.UUID), r.Payload)
-
-	if r.Metadata != nil {
-		err = json.Unmarshal(r.Metadata, &msg.Metadata)
-		if err != nil {
-			return Row{}, fmt.Errorf("could not unmarshal metadata as JSON: %w", err)
-		}
+		return Row{}, err
 	}
 
-	r.Msg = msg
-
 	return r, nil
 }
 
@@ -144,7 +161,71 @@ func (s DefaultMySQLSchema) PayloadColumnType(topic string) string {
 	return s.GeneratePayloadType(topic)
 }
 
+func (s DefaultMySQLSchema) MetadataColumnType(topic string) string {
+	if s.GenerateMetadataType == nil {
+		return "JSON"
+	}
+
+	return s.GenerateMetadataType(topic)
+}
+
+func (s DefaultMySQLSchema) marshaler() Marshaler {
+	return marshalerOrDefault(s.Marshaler)
+}
+
 func (s DefaultMySQLSchema) SubscribeIsolationLevel() sql.IsolationLevel {
 	// MySQL requires serializable isolation level for not losing messages.
 	return sql.LevelSerializable
 }
+
+func (s DefaultMySQLSchema) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectMySQL,
+		ConsumerGroups: true,
+	}
+}
+
+func (s DefaultMySQLSchema) CleanupQuery(params CleanupQueryParams) (Query, error) {
+	var conditions []string
+	var args []any
+
+	if params.MaxAge > 0 {
+		conditions = append(conditions, "`created_at` < NOW() - INTERVAL ? SECOND")
+		args = append(args, int64(params.MaxAge.Seconds()))
+	}
+
+	if params.OnlyAcked {
+		offsetsTable, err := cleanupOffsetsTable(params)
+		if err != nil {
+			return Query{}, err
+		}
+
+		// MIN is NULL if there are no consumer groups, so nothing is deleted
+		conditions = append(conditions, "`offset` <= (SELECT MIN(COALESCE(offset_acked, 0)) FROM "+offsetsTable+")")
+	}
+
+	cleanupQuery := "DELETE FROM " + s.MessagesTable(params.Topic) +
+		" WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY `offset` ASC LIMIT " + fmt.Sprintf("%d", params.BatchSize)
+
+	return Query{cleanupQuery, args}, nil
+}
+
+// MessagesTablesQuery lists tables of the current database which have the columns of the messages table.
+func (s DefaultMySQLSchema) MessagesTablesQuery() (Query, error) {
+	if err := validateMessagesTableNameParser(s.GenerateMessagesTableName, s.ParseMessagesTableName); err != nil {
+		return Query{}, err
+	}
+
+	return Query{
+		Query: `
+			SELECT table_name FROM information_schema.columns
+			WHERE table_schema = DATABASE() AND column_name IN ('offset', 'uuid', 'payload', 'metadata')
+			GROUP BY table_name HAVING COUNT(*) = 4
+			ORDER BY table_name`,
+	}, nil
+}
+
+func (s DefaultMySQLSchema) TopicFromMessagesTable(table string) (string, bool) {
+	return topicFromMessagesTable(s.ParseMessagesTableName, "watermill_", table)
+}
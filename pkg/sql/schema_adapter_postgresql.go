@@ -3,14 +3,12 @@ package sql
 import (
 	"database/sql"
 	"database/sql/driver"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"hash/fnv"
 	"strconv"
 	"strings"
-
-	"github.com/ThreeDotsLabs/watermill/message"
+	"time"
 )
 
 // DefaultPostgreSQLSchema is a default implementation of SchemaAdapter based on PostgreSQL.
@@ -18,10 +16,43 @@ type DefaultPostgreSQLSchema struct {
 	// GenerateMessagesTableName may be used to override how the messages table name is generated.
 	GenerateMessagesTableName func(topic string) string
 
+	// ParseMessagesTableName reverses GenerateMessagesTableName: it returns the topic of the messages table name
+	// (without quotes), or false if the table doesn't belong to any topic. It's used by Subscriber.SubscribePattern,
+	// and required by it when GenerateMessagesTableName is set.
+	ParseMessagesTableName func(table string) (string, bool)
+
+	// Namespace sets the PostgreSQL schema and the prefix of the default table names.
+	// It should be the same as Namespace of the offsets adapter.
+	Namespace PostgreSQLNamespace
+
 	// GeneratePayloadType is the type of the payload column in the messages table.
 	// By default, it's JSON. If your payload is not JSON, you can use BYTEA.
+	//
+	// JSONB may be used for JSON payloads which don't need to be stored byte for byte.
+	// JSONB normalizes the payload: whitespace and the order of keys are not preserved,
+	// and only the last value of duplicate keys is kept.
 	GeneratePayloadType func(topic string) string
 
+	// GenerateMetadataType is the type of the metadata column in the messages table.
+	// By default, it's JSON (or JSONB, see JSONB).
+	GenerateMetadataType func(topic string) string
+
+	// Marshaler converts messages to values of the messages table columns, and back.
+	// Defaults to DefaultMarshaler.
+	Marshaler Marshaler
+
+	// JSONB creates the metadata column as JSONB instead of JSON, unless its type is set with GenerateMetadataType.
+	// JSONB columns are faster to query (for example, with metadata->>'key'), and can be indexed with GIN indexes.
+	// The payload column is not affected, because JSONB doesn't preserve the payload byte for byte,
+	// see GeneratePayloadType.
+	// Existing tables are not converted, see JSONBMigrationQueries.
+	JSONB bool
+
+	// GenerateIndexes returns indexes of the messages table created with the table,
+	// for example PostgreSQLMetadataGINIndex or PostgreSQLMetadataKeyIndex.
+	// Indexes are not dropped when they are removed from the list.
+	GenerateIndexes func(topic string) []PostgreSQLIndex
+
 	// SubscribeBatchSize is the number of messages to be queried at once.
 	//
 	// Higher value, increases a chance of message re-delivery in case of crash or networking issues.
@@ -39,6 +70,19 @@ type DefaultPostgreSQLSchema struct {
 	// InitializeSchemaLock is a PostgreSQL advisory lock to be acquired before initializing the schema.
 	// If empty and InitializeSchemaWithoutTransaction is false, a default will be used.
 	InitializeSchemaLock int
+
+	// NotifyOnInsert creates a trigger which sends a NOTIFY to the topic's channel after messages are inserted.
+	// It allows Subscriber with PostgreSQLNotifier to wake up without waiting for PollInterval.
+	// The trigger function is created in Namespace, named with its TablePrefix (watermill_notify_insert by default).
+	// Requires PostgreSQL 14 or newer.
+	NotifyOnInsert bool
+
+	// GenerateFilter may be used to deliver only some messages of the topic to the consumer group, see PostgreSQLFilter.
+	GenerateFilter func(params GenerateWhereClauseParams) PostgreSQLFilter
+
+	// Partitioning enables partitioning of the messages table by created_at.
+	// See PostgreSQLPartitioningConfig for details.
+	Partitioning PostgreSQLPartitioningConfig
 }
 
 func (s DefaultPostgreSQLSchema) SchemaInitializingQueries(params SchemaInitializingQueriesParams) ([]Query, error) {
@@ -48,19 +92,57 @@ func (s DefaultPostgreSQLSchema) SchemaInitializingQueries(params SchemaInitiali
 	//
 	// it's intended that transaction_id is first in the index, because we are using it alone
 	// in the WHERE clause
+	//
+	// the primary key of a partitioned table must contain the partition key, so created_at is added
+	// at the end, and the index still can be used by SelectQuery in each partition
+	primaryKey := `"transaction_id", "offset"`
+	partitionBy := ""
+	createdAtType := "TIMESTAMP"
+	if s.Partitioning.enabled() {
+		if err := s.Partitioning.validate(); err != nil {
+			return nil, fmt.Errorf("invalid partitioning config: %w", err)
+		}
+
+		primaryKey += `, "created_at"`
+		partitionBy = ` PARTITION BY RANGE ("created_at")`
+
+		// partition bounds are points in time, so the partition of a message doesn't depend on the session time zone
+		createdAtType = "TIMESTAMPTZ"
+	}
+
 	createMessagesTable := ` 
 		CREATE TABLE IF NOT EXISTS ` + s.MessagesTable(params.Topic) + ` (
 			"offset" BIGSERIAL,
 			"uuid" VARCHAR(36) NOT NULL,
-			"created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			"created_at" ` + createdAtType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			"payload" ` + s.PayloadColumnType(params.Topic) + ` DEFAULT NULL,
-			"metadata" JSON DEFAULT NULL,
-			"transaction_id" xid8 NOT NULL,
-			PRIMARY KEY ("transaction_id", "offset")
-		);
+			"metadata" ` + s.MetadataColumnType(params.Topic) + ` DEFAULT NULL,
+			"transaction_id" xid8 NOT NULL` + marshalerColumnsDefinitions(s.marshaler().ExtraColumns(params.Topic), `"`) + `,
+			PRIMARY KEY (` + primaryKey + `)
+		)` + partitionBy + `;
 	`
 
-	queries := []Query{{Query: createMessagesTable}}
+	queries := append(s.Namespace.schemaInitializingQueries(), Query{Query: createMessagesTable})
+	if s.Partitioning.enabled() {
+		partitionQueries, err := s.partitionQueries(params.Topic, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		queries = append(queries, partitionQueries...)
+	}
+	if s.GenerateIndexes != nil {
+		indexQueries, err := postgreSQLIndexQueries(s.MessagesTable(params.Topic), s.GenerateIndexes(params.Topic))
+		if err != nil {
+			return nil, fmt.Errorf("invalid index: %w", err)
+		}
+
+		queries = append(queries, indexQueries...)
+	}
+	if s.NotifyOnInsert {
+		queries = append(queries, postgreSQLNotifyOnInsertQueries(s.Namespace, s.MessagesTable(params.Topic), params.Topic)...)
+	}
+
 	if !s.InitializeSchemaWithoutTransaction {
 		lock := DefaultSchemaInitializationLock("watermill")
 		if s.InitializeSchemaLock > 0 {
@@ -75,14 +157,58 @@ func (s DefaultPostgreSQLSchema) SchemaInitializingQueries(params SchemaInitiali
 	return queries, nil
 }
 
+// SchemaMigrations returns migrations of the messages table, see SchemaMigrator.
+func (s DefaultPostgreSQLSchema) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := s.SchemaInitializingQueries(SchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	table := s.MessagesTable(topic)
+
+	// tables created before transaction_id was added have only the "offset" primary key,
+	// existing messages get transaction_id 0, so they are ordered before new messages
+	addTransactionID := fmt.Sprintf(`
+		DO $$
+		DECLARE
+			primary_key TEXT;
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_attribute
+				WHERE attrelid = '%[1]s'::regclass AND attname = 'transaction_id' AND NOT attisdropped
+			) THEN
+				ALTER TABLE %[2]s ADD COLUMN "transaction_id" xid8 NOT NULL DEFAULT '0';
+
+				SELECT conname INTO primary_key FROM pg_constraint
+				WHERE conrelid = '%[1]s'::regclass AND contype = 'p';
+				IF primary_key IS NOT NULL THEN
+					EXECUTE format('ALTER TABLE %%s DROP CONSTRAINT %%I', '%[1]s'::regclass, primary_key);
+				END IF;
+
+				ALTER TABLE %[2]s ADD PRIMARY KEY ("transaction_id", "offset");
+			END IF;
+		END $$;`,
+		strings.ReplaceAll(table, "'", "''"),
+		table,
+	)
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create messages table", Queries: createQueries},
+		{Version: 2, Description: "add transaction_id column", Queries: []Query{{Query: addTransactionID}}},
+	}, nil
+}
+
 func (s DefaultPostgreSQLSchema) InsertQuery(params InsertQueryParams) (Query, error) {
+	extraColumns := s.marshaler().ExtraColumns(params.Topic)
+
 	insertQuery := fmt.Sprintf(
-		`INSERT INTO %s (uuid, payload, metadata, transaction_id) VALUES %s`,
+		`INSERT INTO %s (uuid, payload, metadata%s, transaction_id) VALUES %s`,
 		s.MessagesTable(params.Topic),
-		defaultInsertMarkers(len(params.Msgs)),
+		marshalerColumnsList(extraColumns, `"`, ""),
+		defaultInsertMarkers(len(params.Msgs), 3+len(extraColumns)),
 	)
 
-	args, err := defaultInsertArgs(params.Msgs)
+	args, err := marshalMessages(s.marshaler(), params.Topic, params.Msgs)
 	if err != nil {
 		return Query{}, err
 	}
@@ -90,13 +216,24 @@ func (s DefaultPostgreSQLSchema) InsertQuery(params InsertQueryParams) (Query, e
 	return Query{insertQuery, args}, nil
 }
 
-func defaultInsertMarkers(count int) string {
+func defaultInsertMarkers(count int, columns int) string {
+	return postgreSQLInsertMarkers(count, columns, "pg_current_xact_id()")
+}
+
+// postgreSQLInsertMarkers returns markers of count rows with the number of columns, followed by the extra expressions.
+func postgreSQLInsertMarkers(count int, columns int, extra ...string) string {
 	result := strings.Builder{}
 
 	index := 1
 	for i := 0; i < count; i++ {
-		result.WriteString(fmt.Sprintf("($%d,$%d,$%d,pg_current_xact_id()),", index, index+1, index+2))
-		index += 3
+		markers := make([]string, 0, columns+len(extra))
+		for j := 0; j < columns; j++ {
+			markers = append(markers, "$"+strconv.Itoa(index))
+			index++
+		}
+		markers = append(markers, extra...)
+
+		result.WriteString("(" + strings.Join(markers, ",") + "),")
 	}
 
 	return strings.TrimRight(result.String(), ",")
@@ -123,6 +260,23 @@ func (s DefaultPostgreSQLSchema) SelectQuery(params SelectQueryParams) (Query, e
 		return Query{}, err
 	}
 
+	args := nextOffsetQuery.Args
+
+	// the filter is evaluated after messages are sorted and limited, so it's not evaluated for all pending messages
+	columns := "*"
+	if s.GenerateFilter != nil {
+		filter := s.GenerateFilter(GenerateWhereClauseParams{
+			Topic:         params.Topic,
+			ConsumerGroup: params.ConsumerGroup,
+		})
+
+		condition, filterArgs := filter.condition(len(args))
+
+		columns = `"offset", transaction_id, uuid, payload, metadata, COALESCE((` + condition + `), FALSE)` +
+			marshalerColumnsList(s.marshaler().ExtraColumns(params.Topic), `"`, "")
+		args = append(args, filterArgs...)
+	}
+
 	// We are using subquery to avoid problems with query planner mis-estimating
 	// and performing expensive index scans, read more:
 	// - https://pganalyze.com/blog/5mins-postgres-planner-order-by-limit
@@ -175,12 +329,13 @@ func (s DefaultPostgreSQLSchema) SelectQuery(params SelectQueryParams) (Query, e
 	// Execution Time: 0.786 ms
 
 	selectQuery := `
-	SELECT * FROM (
+	SELECT ` + columns + ` FROM (
 		WITH last_processed AS (
 			` + nextOffsetQuery.Query + `
 		)
 
-		SELECT "offset", transaction_id::text, uuid, payload, metadata FROM ` + s.MessagesTable(params.Topic) + `
+		SELECT "offset", transaction_id::text, uuid, payload, metadata` +
+		marshalerColumnsList(s.marshaler().ExtraColumns(params.Topic), `"`, "") + ` FROM ` + s.MessagesTable(params.Topic) + `
 
 		WHERE 
 		(
@@ -200,31 +355,31 @@ func (s DefaultPostgreSQLSchema) SelectQuery(params SelectQueryParams) (Query, e
 		"offset" ASC
 	LIMIT ` + fmt.Sprintf("%d", s.batchSize())
 
-	return Query{selectQuery, nextOffsetQuery.Args}, nil
+	return Query{selectQuery, args}, nil
 }
 
 func (s DefaultPostgreSQLSchema) UnmarshalMessage(params UnmarshalMessageParams) (Row, error) {
 	r := Row{}
 	var transactionID XID8
 
-	err := params.Row.Scan(&r.Offset, &transactionID, &r.UUID, &r.Payload, &r.Metadata)
-	if err != nil {
-		return Row{}, fmt.Errorf("could not scan message row: %w", err)
-	}
+	dest := []any{&r.Offset, &transactionID, &r.UUID, &r.Payload, &r.Metadata}
 
-	msg := message.NewMessage(string(r.UUID), r.Payload)
+	matched := true
+	if s.GenerateFilter != nil {
+		dest = append(dest, &matched)
+	}
 
-	if r.Metadata != nil {
-		err = json.Unmarshal(r.Metadata, &msg.Metadata)
-		if err != nil {
-			return Row{}, fmt.Errorf("could not unmarshal metadata as JSON: %w", err)
-		}
+	err := unmarshalRow(s.marshaler(), params.Topic, params.Row, &r, dest...)
+	if err != nil {
+		return Row{}, err
 	}
 
-	r.Msg = msg
-	r.ExtraData = map[string]any{
-		"transaction_id": transactionID,
+	r.Skipped = !matched
+
+	if r.ExtraData == nil {
+		r.ExtraData = map[string]any{}
 	}
+	r.ExtraData["transaction_id"] = transactionID
 
 	return r, nil
 }
@@ -233,7 +388,7 @@ func (s DefaultPostgreSQLSchema) MessagesTable(topic string) string {
 	if s.GenerateMessagesTableName != nil {
 		return s.GenerateMessagesTableName(topic)
 	}
-	return fmt.Sprintf(`"watermill_%s"`, topic)
+	return s.Namespace.table(topic)
 }
 
 func (s DefaultPostgreSQLSchema) PayloadColumnType(topic string) string {
@@ -244,6 +399,26 @@ func (s DefaultPostgreSQLSchema) PayloadColumnType(topic string) string {
 	return s.GeneratePayloadType(topic)
 }
 
+func (s DefaultPostgreSQLSchema) MetadataColumnType(topic string) string {
+	if s.GenerateMetadataType == nil {
+		return s.jsonColumnType()
+	}
+
+	return s.GenerateMetadataType(topic)
+}
+
+func (s DefaultPostgreSQLSchema) jsonColumnType() string {
+	if s.JSONB {
+		return "JSONB"
+	}
+
+	return "JSON"
+}
+
+func (s DefaultPostgreSQLSchema) marshaler() Marshaler {
+	return marshalerOrDefault(s.Marshaler)
+}
+
 func (s DefaultPostgreSQLSchema) SubscribeIsolationLevel() sql.IsolationLevel {
 	// For Postgres Repeatable Read is enough.
 	return sql.LevelRepeatableRead
@@ -292,3 +467,78 @@ func (x *XID8) Scan(src interface{}) error {
 func (x *XID8) Value() (driver.Value, error) {
 	return uint64(*x), nil
 }
+
+func (s DefaultPostgreSQLSchema) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectPostgreSQL,
+		ConsumerGroups: true,
+	}
+}
+
+func (s DefaultPostgreSQLSchema) CleanupQuery(params CleanupQueryParams) (Query, error) {
+	table := s.MessagesTable(params.Topic)
+
+	var conditions []string
+	var args []any
+
+	if params.MaxAge > 0 {
+		args = append(args, params.MaxAge.Seconds())
+		conditions = append(conditions, `created_at < CURRENT_TIMESTAMP - make_interval(secs => $`+strconv.Itoa(len(args))+`)`)
+	}
+
+	if params.OnlyAcked {
+		offsetsTable, err := cleanupOffsetsTable(params)
+		if err != nil {
+			return Query{}, err
+		}
+
+		// the same order as in SelectQuery, a message is acked if it's not after the last acked message
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM `+offsetsTable+`)`)
+		conditions = append(conditions, `NOT EXISTS (
+			SELECT 1 FROM `+offsetsTable+` o
+			WHERE (m.transaction_id, m."offset") > (o.last_processed_transaction_id, COALESCE(o.offset_acked, 0))
+		)`)
+	}
+
+	cleanupQuery := `
+		DELETE FROM ` + table + `
+		WHERE (transaction_id, "offset") IN (
+			SELECT transaction_id, "offset" FROM ` + table + ` m
+			WHERE ` + strings.Join(conditions, " AND ") + `
+			ORDER BY transaction_id, "offset"
+			LIMIT ` + strconv.Itoa(params.BatchSize) + `
+		)`
+
+	return Query{cleanupQuery, args}, nil
+}
+
+// MessagesTablesQuery lists tables of the Namespace's schema (or the current schema) which have the columns
+// of the messages table. Partitions of partitioned messages tables are skipped.
+func (s DefaultPostgreSQLSchema) MessagesTablesQuery() (Query, error) {
+	if err := validateMessagesTableNameParser(s.GenerateMessagesTableName, s.ParseMessagesTableName); err != nil {
+		return Query{}, err
+	}
+
+	var schema *string
+	if s.Namespace.Schema != "" {
+		schema = &s.Namespace.Schema
+	}
+
+	return Query{
+		Query: `
+			SELECT c.relname FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = COALESCE($1, current_schema()) AND c.relkind IN ('r', 'p') AND NOT c.relispartition
+			AND (
+				SELECT COUNT(*) FROM pg_attribute a
+				WHERE a.attrelid = c.oid AND NOT a.attisdropped
+				AND a.attname IN ('offset', 'uuid', 'payload', 'metadata', 'transaction_id')
+			) = 5
+			ORDER BY c.relname`,
+		Args: []any{schema},
+	}, nil
+}
+
+func (s DefaultPostgreSQLSchema) TopicFromMessagesTable(table string) (string, bool) {
+	return topicFromMessagesTable(s.ParseMessagesTableName, s.Namespace.prefix(), table)
+}
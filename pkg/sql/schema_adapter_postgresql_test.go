@@ -10,33 +10,74 @@ import (
 func TestDefaultInsertMarkers(t *testing.T) {
 	testCases := []struct {
 		Count          int
+		Columns        int
 		ExpectedOutput string
 	}{
 		{
 			Count:          0,
+			Columns:        3,
 			ExpectedOutput: "",
 		},
 		{
 			Count:          1,
+			Columns:        3,
 			ExpectedOutput: "($1,$2,$3,pg_current_xact_id())",
 		},
 		{
 			Count:          2,
+			Columns:        3,
 			ExpectedOutput: "($1,$2,$3,pg_current_xact_id()),($4,$5,$6,pg_current_xact_id())",
 		},
 		{
-			Count: 5,
+			Count:   5,
+			Columns: 3,
 			ExpectedOutput: "($1,$2,$3,pg_current_xact_id())," +
 				"($4,$5,$6,pg_current_xact_id())," +
 				"($7,$8,$9,pg_current_xact_id())," +
 				"($10,$11,$12,pg_current_xact_id())," +
 				"($13,$14,$15,pg_current_xact_id())",
 		},
+		{
+			Count:          2,
+			Columns:        4,
+			ExpectedOutput: "($1,$2,$3,$4,pg_current_xact_id()),($5,$6,$7,$8,pg_current_xact_id())",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d_%d", tc.Count, tc.Columns), func(t *testing.T) {
+			output := defaultInsertMarkers(tc.Count, tc.Columns)
+			assert.Equal(t, tc.ExpectedOutput, output)
+		})
+	}
+}
+
+func TestShiftPostgreSQLPlaceholders(t *testing.T) {
+	testCases := []struct {
+		Query          string
+		Offset         int
+		ExpectedOutput string
+	}{
+		{
+			Query:          "payload->>'type' = $1",
+			Offset:         0,
+			ExpectedOutput: "payload->>'type' = $1",
+		},
+		{
+			Query:          "payload->>'type' = $1 AND metadata->>'tenant' IN ($2, $10)",
+			Offset:         2,
+			ExpectedOutput: "payload->>'type' = $3 AND metadata->>'tenant' IN ($4, $12)",
+		},
+		{
+			Query:          `payload->>'$1' = $1 AND "$2" = 'it''s $2'`,
+			Offset:         1,
+			ExpectedOutput: `payload->>'$1' = $2 AND "$2" = 'it''s $2'`,
+		},
 	}
 
 	for _, tc := range testCases {
-		t.Run(fmt.Sprintf("%d", tc.Count), func(t *testing.T) {
-			output := defaultInsertMarkers(tc.Count)
+		t.Run(tc.Query, func(t *testing.T) {
+			output := shiftPostgreSQLPlaceholders(tc.Query, tc.Offset)
 			assert.Equal(t, tc.ExpectedOutput, output)
 		})
 	}
@@ -0,0 +1,216 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DefaultSQLiteSchema is a default implementation of SchemaAdapter based on SQLite.
+//
+// SQLite serializes all writes, so the database handle should begin transactions with an immediate lock
+// (for example, `_txlock=immediate` for modernc.org/sqlite or mattn/go-sqlite3) and set a busy timeout.
+// Otherwise, concurrent subscribers may fail with "database is locked" errors and need to retry.
+//
+// SQLite has a single write lock per database, and the subscriber holds it until the consumed batch is acked.
+// It means that only one batch of messages is processed at a time in the whole database, regardless of topic.
+type DefaultSQLiteSchema struct {
+	// GenerateMessagesTableName may be used to override how the messages table name is generated.
+	GenerateMessagesTableName func(topic string) string
+
+	// ParseMessagesTableName reverses GenerateMessagesTableName: it returns the topic of the messages table name
+	// (without quotes), or false if the table doesn't belong to any topic. It's used by Subscriber.SubscribePattern,
+	// and required by it when GenerateMessagesTableName is set.
+	ParseMessagesTableName func(table string) (string, bool)
+
+	// GeneratePayloadType is the type of the payload column in the messages table.
+	// By default, it's BLOB.
+	GeneratePayloadType func(topic string) string
+
+	// GenerateMetadataType is the type of the metadata column in the messages table.
+	// By default, it's TEXT.
+	GenerateMetadataType func(topic string) string
+
+	// Marshaler converts messages to values of the messages table columns, and back.
+	// Defaults to DefaultMarshaler.
+	Marshaler Marshaler
+
+	// SubscribeBatchSize is the number of messages to be queried at once.
+	//
+	// Higher value, increases a chance of message re-delivery in case of crash or networking issues.
+	// 1 is the safest value, but it may have a negative impact on performance when consuming a lot of messages.
+	//
+	// Default value is 100.
+	SubscribeBatchSize int
+}
+
+func (s DefaultSQLiteSchema) SchemaInitializingQueries(params SchemaInitializingQueriesParams) ([]Query, error) {
+	// AUTOINCREMENT guarantees that offsets are never reused, even after messages are deleted.
+	createMessagesTable := strings.Join([]string{
+		`CREATE TABLE IF NOT EXISTS ` + s.MessagesTable(params.Topic) + ` (`,
+		`"offset" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,`,
+		`"uuid" TEXT NOT NULL,`,
+		`"created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,`,
+		`"payload" ` + s.PayloadColumnType(params.Topic) + ` DEFAULT NULL,`,
+		`"metadata" ` + s.MetadataColumnType(params.Topic) + ` DEFAULT NULL` +
+			marshalerColumnsDefinitions(s.marshaler().ExtraColumns(params.Topic), `"`),
+		`);`,
+	}, "\n")
+
+	return []Query{{Query: createMessagesTable}}, nil
+}
+
+// SchemaMigrations returns migrations of the messages table, see SchemaMigrator.
+func (s DefaultSQLiteSchema) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := s.SchemaInitializingQueries(SchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create messages table", Queries: createQueries},
+	}, nil
+}
+
+func (s DefaultSQLiteSchema) InsertQuery(params InsertQueryParams) (Query, error) {
+	extraColumns := s.marshaler().ExtraColumns(params.Topic)
+
+	insertQuery := fmt.Sprintf(
+		`INSERT INTO %s ("uuid", "payload", "metadata"%s) VALUES %s`,
+		s.MessagesTable(params.Topic),
+		marshalerColumnsList(extraColumns, `"`, ""),
+		questionMarkInsertMarkers(len(params.Msgs), 3+len(extraColumns)),
+	)
+
+	args, err := marshalMessages(s.marshaler(), params.Topic, params.Msgs)
+	if err != nil {
+		return Query{}, err
+	}
+
+	return Query{insertQuery, args}, nil
+}
+
+func (s DefaultSQLiteSchema) batchSize() int {
+	if s.SubscribeBatchSize == 0 {
+		return 100
+	}
+
+	return s.SubscribeBatchSize
+}
+
+func (s DefaultSQLiteSchema) SelectQuery(params SelectQueryParams) (Query, error) {
+	nextOffsetParams := NextOffsetQueryParams{
+		Topic:         params.Topic,
+		ConsumerGroup: params.ConsumerGroup,
+	}
+	nextOffsetQuery, err := params.OffsetsAdapter.NextOffsetQuery(nextOffsetParams)
+	if err != nil {
+		return Query{}, err
+	}
+
+	selectQuery := `SELECT "offset", "uuid", "payload", "metadata"` +
+		marshalerColumnsList(s.marshaler().ExtraColumns(params.Topic), `"`, "") +
+		` FROM ` + s.MessagesTable(params.Topic) +
+		` WHERE "offset" > (` + nextOffsetQuery.Query + `) ORDER BY "offset" ASC` +
+		` LIMIT ` + fmt.Sprintf("%d", s.batchSize())
+
+	return Query{Query: selectQuery, Args: nextOffsetQuery.Args}, nil
+}
+
+func (s DefaultSQLiteSchema) UnmarshalMessage(params UnmarshalMessageParams) (Row, error) {
+	r := Row{}
+	err := unmarshalRow(s.marshaler(), params.Topic, params.Row, &r, &r.Offset, &r.UUID, &r.Payload, &r.Metadata)
+	if err != nil {
+		return Row{}, err
+	}
+
+	return r, nil
+}
+
+func (s DefaultSQLiteSchema) MessagesTable(topic string) string {
+	if s.GenerateMessagesTableName != nil {
+		return s.GenerateMessagesTableName(topic)
+	}
+	return fmt.Sprintf(`"watermill_%s"`, topic)
+}
+
+func (s DefaultSQLiteSchema) PayloadColumnType(topic string) string {
+	if s.GeneratePayloadType == nil {
+		return "BLOB"
+	}
+
+	return s.GeneratePayloadType(topic)
+}
+
+func (s DefaultSQLiteSchema) MetadataColumnType(topic string) string {
+	if s.GenerateMetadataType == nil {
+		return "TEXT"
+	}
+
+	return s.GenerateMetadataType(topic)
+}
+
+func (s DefaultSQLiteSchema) marshaler() Marshaler {
+	return marshalerOrDefault(s.Marshaler)
+}
+
+func (s DefaultSQLiteSchema) SubscribeIsolationLevel() sql.IsolationLevel {
+	// SQLite transactions are always serializable.
+	return sql.LevelSerializable
+}
+
+func (s DefaultSQLiteSchema) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectSQLite,
+		ConsumerGroups: true,
+	}
+}
+
+func (s DefaultSQLiteSchema) CleanupQuery(params CleanupQueryParams) (Query, error) {
+	table := s.MessagesTable(params.Topic)
+
+	var conditions []string
+	var args []any
+
+	if params.MaxAge > 0 {
+		conditions = append(conditions, `"created_at" < datetime('now', ?)`)
+		args = append(args, fmt.Sprintf("-%d seconds", int64(params.MaxAge.Seconds())))
+	}
+
+	if params.OnlyAcked {
+		offsetsTable, err := cleanupOffsetsTable(params)
+		if err != nil {
+			return Query{}, err
+		}
+
+		// MIN is NULL if there are no consumer groups, so nothing is deleted
+		conditions = append(conditions, `"offset" <= (SELECT MIN(COALESCE(offset_acked, 0)) FROM `+offsetsTable+`)`)
+	}
+
+	cleanupQuery := `DELETE FROM ` + table + ` WHERE "offset" IN (` +
+		`SELECT "offset" FROM ` + table + ` WHERE ` + strings.Join(conditions, " AND ") +
+		` ORDER BY "offset" ASC LIMIT ` + fmt.Sprintf("%d", params.BatchSize) + `)`
+
+	return Query{cleanupQuery, args}, nil
+}
+
+// MessagesTablesQuery lists tables of the database which have the columns of the messages table.
+func (s DefaultSQLiteSchema) MessagesTablesQuery() (Query, error) {
+	if err := validateMessagesTableNameParser(s.GenerateMessagesTableName, s.ParseMessagesTableName); err != nil {
+		return Query{}, err
+	}
+
+	return Query{
+		Query: `
+			SELECT m.name FROM sqlite_master m
+			WHERE m.type = 'table' AND (
+				SELECT COUNT(*) FROM pragma_table_info(m.name) c
+				WHERE c.name IN ('offset', 'uuid', 'payload', 'metadata')
+			) = 4
+			ORDER BY m.name`,
+	}, nil
+}
+
+func (s DefaultSQLiteSchema) TopicFromMessagesTable(table string) (string, bool) {
+	return topicFromMessagesTable(s.ParseMessagesTableName, "watermill_", table)
+}
@@ -81,6 +81,27 @@ func TestDefaultPostgreSQLSchema(t *testing.T) {
 	testOneMessage(t, publisher, subscriber)
 }
 
+// TestDefaultSQLiteSchema checks if the SQL schema defined in DefaultSQLiteSchema is correctly executed
+// and if message marshaling works as intended.
+func TestDefaultSQLiteSchema(t *testing.T) {
+	db := newSQLite(t)
+
+	publisher, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        sql.DefaultSQLiteSchema{},
+		AutoInitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	subscriber, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		SchemaAdapter:    sql.DefaultSQLiteSchema{},
+		OffsetsAdapter:   sql.DefaultSQLiteOffsetsAdapter{},
+		InitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	testOneMessage(t, publisher, subscriber)
+}
+
 func testOneMessage(t *testing.T, publisher message.Publisher, subscriber message.Subscriber) {
 	topic := "test_" + watermill.NewULID()
 
@@ -0,0 +1,660 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+const (
+	// SchemaMigrationComponentSchema is the component of migrations provided by SchemaAdapter.
+	SchemaMigrationComponentSchema = "schema"
+	// SchemaMigrationComponentOffsets is the component of migrations provided by OffsetsAdapter.
+	SchemaMigrationComponentOffsets = "offsets"
+)
+
+// SchemaMigration is a step upgrading the tables of a topic to the next schema version.
+type SchemaMigration struct {
+	// Version is the schema version after the migration. Versions of an adapter start with 1 and are consecutive.
+	Version int
+
+	// Description briefly describes the change, for example "add acked column".
+	Description string
+
+	// Queries are executed in a single transaction, in which the version is recorded.
+	Queries []Query
+}
+
+// SchemaMigrationsProvider may be implemented by SchemaAdapter and OffsetsAdapter to support SchemaMigrator.
+type SchemaMigrationsProvider interface {
+	// SchemaMigrations returns migrations of the tables of the topic, ordered by version.
+	//
+	// The first migration creates the tables, and the next ones upgrade tables created by older versions.
+	// Migrations must be safe to execute on tables which already have the new layout,
+	// because tables created before versions were recorded start from version 0.
+	SchemaMigrations(topic string) ([]SchemaMigration, error)
+}
+
+// SchemaMigrationStep is a migration of one of the components of the topic, returned by SchemaMigrator.Migrate.
+type SchemaMigrationStep struct {
+	// Component is SchemaMigrationComponentSchema or SchemaMigrationComponentOffsets.
+	Component string
+
+	Migration SchemaMigration
+}
+
+// SchemaMigratorConfig is a configuration for SchemaMigrator.
+type SchemaMigratorConfig struct {
+	// DB is a database connection. Required.
+	DB Beginner
+
+	// SchemaAdapter provides migrations of the messages tables, if it implements SchemaMigrationsProvider. Required.
+	SchemaAdapter SchemaAdapter
+
+	// OffsetsAdapter provides migrations of the offsets tables, if it implements SchemaMigrationsProvider. Optional.
+	OffsetsAdapter OffsetsAdapter
+
+	// Dialect is the SQL dialect of the versions table queries, for example DialectPostgreSQL.
+	// Defaults to the dialect of SchemaAdapter, if it implements CapabilitiesProvider.
+	Dialect string
+
+	// VersionsTable is the table storing applied schema versions, quoted like table names of the adapters.
+	// It's created if it doesn't exist. Defaults to "watermill_schema_versions", or to "schema_versions"
+	// in the PostgreSQLNamespace of SchemaAdapter (with its schema and table prefix), if it has one.
+	VersionsTable string
+
+	// DryRun makes Migrate return pending migrations without applying them, so their SQL can be reviewed.
+	// Nothing is committed in the dry-run mode, but with MySQL the versions table is created,
+	// because DDL statements can't be rolled back.
+	DryRun bool
+
+	Logger watermill.LoggerAdapter
+}
+
+func (c *SchemaMigratorConfig) setDefaults() {
+	if c.Dialect == "" {
+		if provider, ok := c.SchemaAdapter.(CapabilitiesProvider); ok {
+			c.Dialect = provider.Capabilities().Dialect
+		}
+	}
+	if c.VersionsTable == "" {
+		if provider, ok := c.SchemaAdapter.(postgreSQLNamespaceProvider); ok {
+			c.VersionsTable = provider.postgreSQLNamespace().table("schema_versions")
+		}
+	}
+	if c.VersionsTable == "" {
+		quote := `"`
+		if c.Dialect == DialectMySQL {
+			quote = "`"
+		}
+		c.VersionsTable = quote + "watermill_schema_versions" + quote
+	}
+	if c.Logger == nil {
+		c.Logger = watermill.NopLogger{}
+	}
+}
+
+func (c SchemaMigratorConfig) validate() error {
+	if c.DB == nil {
+		return errors.New("missing db")
+	}
+	if c.SchemaAdapter == nil {
+		return errors.New("missing schema adapter")
+	}
+
+	switch c.Dialect {
+	case DialectPostgreSQL, DialectMySQL, DialectSQLite:
+	case "":
+		return fmt.Errorf("missing dialect, schema adapter %T doesn't provide it", c.SchemaAdapter)
+	default:
+		return fmt.Errorf("unsupported dialect: %s", c.Dialect)
+	}
+
+	return nil
+}
+
+// SchemaMigrator upgrades tables of topics created by older versions of the adapters.
+//
+// Schema initialization of Publisher and Subscriber only creates tables which don't exist,
+// so tables of existing topics keep their old layout when an adapter changes it. SchemaMigrator records
+// the schema version of each topic in the versions table, and applies migrations of the adapters
+// (see SchemaMigrationsProvider) which were not applied yet.
+//
+// Objects enabled by options of the adapters (like indexes or NotifyOnInsert triggers) are not versioned,
+// they are still created by the schema initialization when they are enabled for existing topics.
+//
+// The schema initialization of Publisher and Subscriber may apply the migrations as well,
+// or warn about pending migrations, see SchemaVersionsConfig.
+type SchemaMigrator struct {
+	config SchemaMigratorConfig
+	logger watermill.LoggerAdapter
+}
+
+func NewSchemaMigrator(config SchemaMigratorConfig) (*SchemaMigrator, error) {
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &SchemaMigrator{
+		config: config,
+		logger: config.Logger,
+	}, nil
+}
+
+// Migrate applies migrations of the topic which were not applied yet, and returns them.
+// Each migration is applied in its own transaction, together with recording its version.
+//
+// In the dry-run mode (see SchemaMigratorConfig.DryRun), the pending migrations are returned without applying them.
+func (m *SchemaMigrator) Migrate(ctx context.Context, topic string) ([]SchemaMigrationStep, error) {
+	if err := validateTopic(topic, m.config.SchemaAdapter, m.config.OffsetsAdapter); err != nil {
+		return nil, err
+	}
+
+	components, err := m.migrations(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.config.DryRun {
+		return m.pendingMigrations(ctx, topic, components)
+	}
+
+	if _, err := m.config.DB.ExecContext(ctx, m.createVersionsTableQuery()); err != nil {
+		return nil, fmt.Errorf("could not create versions table: %w", err)
+	}
+
+	var applied []SchemaMigrationStep
+	for _, component := range components {
+		for _, migration := range component.migrations {
+			var ok bool
+			err := runInTx(ctx, m.config.DB, func(ctx context.Context, tx Tx) error {
+				var err error
+				ok, err = m.applyMigration(ctx, tx, topic, component.name, migration)
+				return err
+			})
+			if err != nil {
+				return applied, fmt.Errorf(
+					"could not apply %s migration %d (%s) of topic %s: %w",
+					component.name, migration.Version, migration.Description, topic, err,
+				)
+			}
+			if !ok {
+				continue
+			}
+
+			m.logger.Info("Applied schema migration", watermill.LogFields{
+				"topic":       topic,
+				"component":   component.name,
+				"version":     migration.Version,
+				"description": migration.Description,
+			})
+
+			applied = append(applied, SchemaMigrationStep{Component: component.name, Migration: migration})
+		}
+	}
+
+	return applied, nil
+}
+
+type componentMigrations struct {
+	name       string
+	migrations []SchemaMigration
+}
+
+func (m *SchemaMigrator) migrations(topic string) ([]componentMigrations, error) {
+	adapters := []struct {
+		name    string
+		adapter any
+	}{
+		{SchemaMigrationComponentSchema, m.config.SchemaAdapter},
+		{SchemaMigrationComponentOffsets, m.config.OffsetsAdapter},
+	}
+
+	var components []componentMigrations
+	for _, a := range adapters {
+		provider, ok := a.adapter.(SchemaMigrationsProvider)
+		if !ok {
+			continue
+		}
+
+		migrations, err := provider.SchemaMigrations(topic)
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s migrations: %w", a.name, err)
+		}
+
+		for i, migration := range migrations {
+			if migration.Version != i+1 {
+				return nil, fmt.Errorf("%s migration %d has version %d, expected %d", a.name, i, migration.Version, i+1)
+			}
+		}
+
+		components = append(components, componentMigrations{name: a.name, migrations: migrations})
+	}
+
+	return components, nil
+}
+
+// applyMigration applies the migration, if its version was not applied yet.
+// The version row is locked, so concurrent migrators of the topic wait for each other.
+func (m *SchemaMigrator) applyMigration(
+	ctx context.Context,
+	tx Tx,
+	topic string,
+	component string,
+	migration SchemaMigration,
+) (bool, error) {
+	if _, err := tx.ExecContext(ctx, m.insertVersionQuery(), component, topic); err != nil {
+		return false, fmt.Errorf("could not insert version: %w", err)
+	}
+
+	version, err := m.version(ctx, tx, m.selectVersionQuery(true), component, topic)
+	if err != nil {
+		return false, err
+	}
+
+	if version >= migration.Version {
+		return false, nil
+	}
+
+	for _, query := range migration.Queries {
+		if _, err := tx.ExecContext(ctx, query.Query, query.Args...); err != nil {
+			return false, fmt.Errorf("could not execute migration query: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, m.updateVersionQuery(), migration.Version, component, topic); err != nil {
+		return false, fmt.Errorf("could not update version: %w", err)
+	}
+
+	return true, nil
+}
+
+func (m *SchemaMigrator) pendingMigrations(ctx context.Context, topic string, components []componentMigrations) (steps []SchemaMigrationStep, err error) {
+	tx, err := m.config.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+	}()
+
+	// the versions table is created in the transaction, which is rolled back
+	if _, err := tx.ExecContext(ctx, m.createVersionsTableQuery()); err != nil {
+		return nil, fmt.Errorf("could not create versions table: %w", err)
+	}
+
+	return m.pendingSteps(ctx, tx, topic, components)
+}
+
+// checkVersions returns migrations of the topic which were not applied yet, or nothing if the versions table
+// doesn't exist, so versions are not recorded. Unlike the dry-run mode, it doesn't create the versions table,
+// even in a transaction. It must be called before the tables of the topic are initialized.
+//
+// Components without recorded versions, whose tables don't exist yet, are returned as created instead,
+// because the schema initialization creates them with the current layout, so their versions can be recorded
+// with recordCreatedVersions after the initialization.
+func (m *SchemaMigrator) checkVersions(ctx context.Context, topic string) (
+	pending []SchemaMigrationStep,
+	created []componentMigrations,
+	err error,
+) {
+	components, err := m.migrations(topic)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := m.config.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+	}()
+
+	exists, err := m.tableExists(ctx, tx, m.config.VersionsTable)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, nil
+	}
+
+	tablesExist := true
+	if provider, ok := m.config.SchemaAdapter.(messagesTableProvider); ok {
+		tablesExist, err = m.tableExists(ctx, tx, provider.MessagesTable(topic))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	_, sharedTables := m.config.SchemaAdapter.(sharedTablesSchemaAdapter)
+
+	for _, component := range components {
+		if len(component.migrations) == 0 {
+			continue
+		}
+
+		version, recorded, err := m.recordedVersion(ctx, tx, m.selectVersionQuery(false), component.name, topic)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !recorded {
+			isNew := !tablesExist
+			if sharedTables && tablesExist {
+				// the tables are shared by all topics, so they have the layout of the topics which were migrated
+				sharedVersion, err := m.maxVersion(ctx, tx, component.name)
+				if err != nil {
+					return nil, nil, err
+				}
+				isNew = sharedVersion >= component.migrations[len(component.migrations)-1].Version
+			}
+
+			if isNew {
+				created = append(created, component)
+				continue
+			}
+		}
+
+		for _, migration := range component.migrations {
+			if migration.Version > version {
+				pending = append(pending, SchemaMigrationStep{Component: component.name, Migration: migration})
+			}
+		}
+	}
+
+	return pending, created, nil
+}
+
+// recordCreatedVersions records the latest versions of components created by the schema initialization,
+// see checkVersions. Versions recorded in the meantime (for example, by SchemaMigrator) are not changed.
+func (m *SchemaMigrator) recordCreatedVersions(ctx context.Context, topic string, created []componentMigrations) error {
+	return runInTx(ctx, m.config.DB, func(ctx context.Context, tx Tx) error {
+		for _, component := range created {
+			if _, err := tx.ExecContext(ctx, m.insertVersionQuery(), component.name, topic); err != nil {
+				return fmt.Errorf("could not insert version: %w", err)
+			}
+
+			version, err := m.version(ctx, tx, m.selectVersionQuery(true), component.name, topic)
+			if err != nil {
+				return err
+			}
+			if version > 0 {
+				continue
+			}
+
+			latest := component.migrations[len(component.migrations)-1].Version
+			if _, err := tx.ExecContext(ctx, m.updateVersionQuery(), latest, component.name, topic); err != nil {
+				return fmt.Errorf("could not update version: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *SchemaMigrator) pendingSteps(ctx context.Context, tx Tx, topic string, components []componentMigrations) ([]SchemaMigrationStep, error) {
+	var steps []SchemaMigrationStep
+
+	for _, component := range components {
+		version, err := m.version(ctx, tx, m.selectVersionQuery(false), component.name, topic)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, migration := range component.migrations {
+			if migration.Version > version {
+				steps = append(steps, SchemaMigrationStep{Component: component.name, Migration: migration})
+			}
+		}
+	}
+
+	return steps, nil
+}
+
+// version returns the applied version of the component, or 0 if no migration was applied yet.
+func (m *SchemaMigrator) version(ctx context.Context, tx Tx, query string, component string, topic string) (int, error) {
+	version, _, err := m.recordedVersion(ctx, tx, query, component, topic)
+	return version, err
+}
+
+// recordedVersion returns the applied version of the component, and whether the version row exists.
+func (m *SchemaMigrator) recordedVersion(ctx context.Context, tx Tx, query string, component string, topic string) (int, bool, error) {
+	rows, err := tx.QueryContext(ctx, query, component, topic)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not query version: %w", err)
+	}
+
+	var version int
+	recorded := rows.Next()
+	if recorded {
+		err = rows.Scan(&version)
+	}
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("could not scan version: %w", err)
+	}
+
+	return version, recorded, nil
+}
+
+// maxVersion returns the highest version of the component recorded for any topic.
+func (m *SchemaMigrator) maxVersion(ctx context.Context, tx Tx, component string) (int, error) {
+	query := `SELECT COALESCE(MAX(version), 0) FROM ` + m.config.VersionsTable + ` WHERE component = ?`
+	if m.config.Dialect == DialectPostgreSQL {
+		query = `SELECT COALESCE(MAX(version), 0) FROM ` + m.config.VersionsTable + ` WHERE component = $1`
+	}
+
+	rows, err := tx.QueryContext(ctx, query, component)
+	if err != nil {
+		return 0, fmt.Errorf("could not query max version: %w", err)
+	}
+
+	var version int
+	if rows.Next() {
+		err = rows.Scan(&version)
+	}
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not scan max version: %w", err)
+	}
+
+	return version, nil
+}
+
+// tableExists checks if the table, quoted like table names of the adapters, exists.
+func (m *SchemaMigrator) tableExists(ctx context.Context, tx Tx, table string) (bool, error) {
+	var query string
+	var arg any
+
+	switch m.config.Dialect {
+	case DialectPostgreSQL:
+		query = `SELECT to_regclass($1) IS NOT NULL`
+		arg = table
+	case DialectMySQL:
+		query = `SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`
+		arg = strings.Trim(table, "`")
+	default:
+		query = `SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?`
+		arg = strings.Trim(table, `"`)
+	}
+
+	rows, err := tx.QueryContext(ctx, query, arg)
+	if err != nil {
+		return false, fmt.Errorf("could not query table %s: %w", table, err)
+	}
+
+	var exists bool
+	if rows.Next() {
+		err = rows.Scan(&exists)
+	}
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not scan table %s: %w", table, err)
+	}
+
+	return exists, nil
+}
+
+func (m *SchemaMigrator) createVersionsTableQuery() string {
+	return `
+		CREATE TABLE IF NOT EXISTS ` + m.config.VersionsTable + ` (
+			component VARCHAR(255) NOT NULL,
+			topic VARCHAR(255) NOT NULL,
+			version INTEGER NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (component, topic)
+		)`
+}
+
+func (m *SchemaMigrator) insertVersionQuery() string {
+	switch m.config.Dialect {
+	case DialectPostgreSQL:
+		return `INSERT INTO ` + m.config.VersionsTable + ` (component, topic, version) VALUES ($1, $2, 0) ON CONFLICT DO NOTHING`
+	case DialectMySQL:
+		return `INSERT IGNORE INTO ` + m.config.VersionsTable + ` (component, topic, version) VALUES (?, ?, 0)`
+	default:
+		return `INSERT OR IGNORE INTO ` + m.config.VersionsTable + ` (component, topic, version) VALUES (?, ?, 0)`
+	}
+}
+
+func (m *SchemaMigrator) selectVersionQuery(forUpdate bool) string {
+	query := `SELECT version FROM ` + m.config.VersionsTable + ` WHERE component = ? AND topic = ?`
+	if m.config.Dialect == DialectPostgreSQL {
+		query = `SELECT version FROM ` + m.config.VersionsTable + ` WHERE component = $1 AND topic = $2`
+	}
+
+	// SQLite locks the whole database for writing, so rows don't need to be locked
+	if forUpdate && m.config.Dialect != DialectSQLite {
+		query += ` FOR UPDATE`
+	}
+
+	return query
+}
+
+func (m *SchemaMigrator) updateVersionQuery() string {
+	if m.config.Dialect == DialectPostgreSQL {
+		return `UPDATE ` + m.config.VersionsTable + ` SET version = $1, updated_at = CURRENT_TIMESTAMP WHERE component = $2 AND topic = $3`
+	}
+
+	return `UPDATE ` + m.config.VersionsTable + ` SET version = ?, updated_at = CURRENT_TIMESTAMP WHERE component = ? AND topic = ?`
+}
+
+// SchemaVersionsConfig configures how the schema initialization of Publisher and Subscriber
+// handles the schema versions recorded by SchemaMigrator.
+//
+// By default, the initialization logs an error when the versions table exists and the topic has migrations
+// which were not applied yet, because its tables may have an old layout. The check is skipped
+// when the versions table doesn't exist, because SchemaMigrator is not used then.
+//
+// When the versions table exists and the initialization creates the tables of a new topic,
+// the latest versions are recorded for it, because the tables are created with the current layout.
+type SchemaVersionsConfig struct {
+	// Migrate applies pending migrations of the adapters with SchemaMigrator before the schema is initialized,
+	// so tables of existing topics are upgraded. It requires a database handle which can begin transactions.
+	Migrate bool
+
+	// VersionsTable is the table storing applied schema versions, see SchemaMigratorConfig.VersionsTable.
+	// It should be the same as in SchemaMigratorConfig, if SchemaMigrator is used as well.
+	VersionsTable string
+}
+
+// sharedTablesSchemaAdapter is implemented by schema adapters storing messages of all topics in the same tables.
+type sharedTablesSchemaAdapter interface {
+	sharedTables()
+}
+
+// initializeSchemaVersions applies or checks the pending migrations of the topic, see SchemaVersionsConfig.
+// It's called before the tables of the topic are initialized. The returned function must be called
+// after the initialization, to record versions of the tables it created.
+func initializeSchemaVersions(
+	ctx context.Context,
+	topic string,
+	logger watermill.LoggerAdapter,
+	db ContextExecutor,
+	schemaAdapter SchemaAdapter,
+	offsetsAdapter OffsetsAdapter,
+	config SchemaVersionsConfig,
+) (func(ctx context.Context) error, error) {
+	noop := func(ctx context.Context) error { return nil }
+
+	_, schemaMigrations := schemaAdapter.(SchemaMigrationsProvider)
+	_, offsetsMigrations := offsetsAdapter.(SchemaMigrationsProvider)
+	if !schemaMigrations && !offsetsMigrations {
+		if config.Migrate {
+			return nil, errors.New("adapters don't provide schema migrations")
+		}
+		return noop, nil
+	}
+
+	beginner, ok := db.(Beginner)
+	if !ok {
+		if config.Migrate {
+			return nil, errors.New("migrating schema requires a database handle which can begin transactions")
+		}
+		return noop, nil
+	}
+
+	migrator, err := NewSchemaMigrator(SchemaMigratorConfig{
+		DB:             beginner,
+		SchemaAdapter:  schemaAdapter,
+		OffsetsAdapter: offsetsAdapter,
+		VersionsTable:  config.VersionsTable,
+		Logger:         logger,
+	})
+	if err != nil {
+		if config.Migrate {
+			return nil, fmt.Errorf("could not create schema migrator: %w", err)
+		}
+		// adapters without the dialect don't support the versions table
+		return noop, nil
+	}
+
+	if config.Migrate {
+		if _, err := migrator.Migrate(ctx, topic); err != nil {
+			return nil, fmt.Errorf("could not migrate schema: %w", err)
+		}
+		return noop, nil
+	}
+
+	pending, created, err := migrator.checkVersions(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("could not check schema versions: %w", err)
+	}
+
+	if len(pending) > 0 {
+		var migrations []string
+		for _, step := range pending {
+			migrations = append(migrations, fmt.Sprintf("%s %d (%s)", step.Component, step.Migration.Version, step.Migration.Description))
+		}
+
+		logger.Error("Topic has pending schema migrations, apply them with SchemaMigrator or SchemaVersionsConfig.Migrate", nil, watermill.LogFields{
+			"topic":      topic,
+			"migrations": strings.Join(migrations, ", "),
+		})
+	}
+
+	if len(created) == 0 {
+		return noop, nil
+	}
+
+	return func(ctx context.Context) error {
+		if err := migrator.recordCreatedVersions(ctx, topic, created); err != nil {
+			return fmt.Errorf("could not record schema versions: %w", err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,397 @@
+package sql_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func TestSchemaMigrator_SQLite(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "migrator_" + watermill.NewShortUUID()
+
+	migrator, err := sql.NewSchemaMigrator(sql.SchemaMigratorConfig{
+		DB:             db,
+		SchemaAdapter:  newSQLiteSchemaAdapter(1),
+		OffsetsAdapter: newSQLiteOffsetsAdapter(),
+		Logger:         logger,
+	})
+	require.NoError(t, err)
+
+	applied, err := migrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"schema 1", "offsets 1"}, migrationSteps(applied))
+
+	assert.Equal(t, 1, sqliteTablesCount(t, db, "test_"+topic))
+	assert.Equal(t, 1, sqliteTablesCount(t, db, "test_offsets_"+topic))
+
+	// applied migrations are recorded, so they are not applied again
+	applied, err = migrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	publisher, subscriber := newPubSub(t, db, "migrator", newSQLiteSchemaAdapter(1), newSQLiteOffsetsAdapter())
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	require.NoError(t, publisher.Publish(topic, msg))
+
+	messages, err := subscriber.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	select {
+	case received := <-messages:
+		assert.Equal(t, msg.UUID, received.UUID)
+		received.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestSchemaMigrator_SQLite_dry_run(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "migrator_" + watermill.NewShortUUID()
+
+	config := sql.SchemaMigratorConfig{
+		DB:             db,
+		SchemaAdapter:  newSQLiteSchemaAdapter(1),
+		OffsetsAdapter: newSQLiteOffsetsAdapter(),
+		VersionsTable:  `"test_schema_versions_` + topic + `"`,
+		DryRun:         true,
+		Logger:         logger,
+	}
+
+	dryRunMigrator, err := sql.NewSchemaMigrator(config)
+	require.NoError(t, err)
+
+	pending, err := dryRunMigrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+	require.Equal(t, []string{"schema 1", "offsets 1"}, migrationSteps(pending))
+	require.NotEmpty(t, pending[0].Migration.Queries)
+	assert.Contains(t, pending[0].Migration.Queries[0].Query, "CREATE TABLE IF NOT EXISTS")
+
+	// nothing is created in the dry-run mode, including the versions table
+	assert.Equal(t, 0, sqliteTablesCount(t, db, "test_"+topic))
+	assert.Equal(t, 0, sqliteTablesCount(t, db, "test_offsets_"+topic))
+	assert.Equal(t, 0, sqliteTablesCount(t, db, "test_schema_versions_"+topic))
+
+	config.DryRun = false
+	migrator, err := sql.NewSchemaMigrator(config)
+	require.NoError(t, err)
+
+	_, err = migrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+
+	pending, err = dryRunMigrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestSchemaMigrator_SQLite_schema_initialization(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "migrator_" + watermill.NewShortUUID()
+
+	versions := sql.SchemaVersionsConfig{
+		VersionsTable: `"test_schema_versions_` + topic + `"`,
+	}
+
+	initializeSchema := func(topic string, versions sql.SchemaVersionsConfig) *watermill.CaptureLoggerAdapter {
+		captureLogger := watermill.NewCaptureLogger()
+
+		subscriber, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+			SchemaAdapter:  newSQLiteSchemaAdapter(1),
+			OffsetsAdapter: newSQLiteOffsetsAdapter(),
+			SchemaVersions: versions,
+		}, captureLogger)
+		require.NoError(t, err)
+		require.NoError(t, subscriber.SubscribeInitialize(topic))
+
+		return captureLogger
+	}
+
+	pendingMigrationsLogged := func(captureLogger *watermill.CaptureLoggerAdapter) bool {
+		for _, msg := range captureLogger.Captured()[watermill.ErrorLogLevel] {
+			if strings.Contains(msg.Msg, "pending schema migrations") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	// without the versions table, SchemaMigrator is not used, so nothing is checked
+	assert.False(t, pendingMigrationsLogged(initializeSchema(topic, versions)))
+
+	config := sql.SchemaMigratorConfig{
+		DB:             db,
+		SchemaAdapter:  newSQLiteSchemaAdapter(1),
+		OffsetsAdapter: newSQLiteOffsetsAdapter(),
+		VersionsTable:  versions.VersionsTable,
+		Logger:         logger,
+	}
+
+	// the versions table is created when SchemaMigrator migrates another topic
+	migrator, err := sql.NewSchemaMigrator(config)
+	require.NoError(t, err)
+
+	_, err = migrator.Migrate(context.Background(), "other_"+topic)
+	require.NoError(t, err)
+
+	config.DryRun = true
+	dryRunMigrator, err := sql.NewSchemaMigrator(config)
+	require.NoError(t, err)
+
+	// the tables of the topic were created before the versions table, so the versions are unknown
+	assert.True(t, pendingMigrationsLogged(initializeSchema(topic, versions)))
+
+	// versions of topics created by the initialization are recorded
+	newTopic := "new_" + topic
+	assert.False(t, pendingMigrationsLogged(initializeSchema(newTopic, versions)))
+	assert.False(t, pendingMigrationsLogged(initializeSchema(newTopic, versions)))
+
+	pending, err := dryRunMigrator.Migrate(context.Background(), newTopic)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	versions.Migrate = true
+	assert.False(t, pendingMigrationsLogged(initializeSchema(topic, versions)))
+
+	pending, err = dryRunMigrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	versions.Migrate = false
+	assert.False(t, pendingMigrationsLogged(initializeSchema(topic, versions)))
+}
+
+func TestSchemaMigrator_PostgreSQL(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	topic := "migrator_" + watermill.NewShortUUID()
+
+	schemaAdapter := newPostgresSchemaAdapter(1)
+	offsetsAdapter := newPostgresOffsetsAdapter()
+
+	// the layout of tables created before transaction_id was added
+	_, err := db.ExecContext(context.Background(), fmt.Sprintf(`
+		CREATE TABLE %s (
+			"offset" SERIAL PRIMARY KEY,
+			"uuid" VARCHAR(36) NOT NULL,
+			"created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			"payload" BYTEA DEFAULT NULL,
+			"metadata" JSON DEFAULT NULL
+		)`,
+		schemaAdapter.MessagesTable(topic),
+	))
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(context.Background(), fmt.Sprintf(`
+		CREATE TABLE %s (
+			consumer_group VARCHAR(255) NOT NULL,
+			offset_acked BIGINT,
+			PRIMARY KEY(consumer_group)
+		)`,
+		offsetsAdapter.MessagesOffsetsTable(topic),
+	))
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(
+		context.Background(),
+		`INSERT INTO `+schemaAdapter.MessagesTable(topic)+` (uuid, payload) VALUES ($1, $2)`,
+		"old", []byte("{}"),
+	)
+	require.NoError(t, err)
+
+	dryRunMigrator, err := sql.NewSchemaMigrator(sql.SchemaMigratorConfig{
+		DB:             db,
+		SchemaAdapter:  schemaAdapter,
+		OffsetsAdapter: offsetsAdapter,
+		DryRun:         true,
+		Logger:         logger,
+	})
+	require.NoError(t, err)
+
+	pending, err := dryRunMigrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"schema 1", "schema 2", "offsets 1", "offsets 2"}, migrationSteps(pending))
+
+	migrator, err := sql.NewSchemaMigrator(sql.SchemaMigratorConfig{
+		DB:             db,
+		SchemaAdapter:  schemaAdapter,
+		OffsetsAdapter: offsetsAdapter,
+		Logger:         logger,
+	})
+	require.NoError(t, err)
+
+	applied, err := migrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"schema 1", "schema 2", "offsets 1", "offsets 2"}, migrationSteps(applied))
+
+	applied, err = migrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	// messages stored before the migration are delivered before new messages
+	publisher, subscriber := newPubSub(t, db, "migrator", schemaAdapter, offsetsAdapter)
+	require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	require.NoError(t, publisher.Publish(topic, msg))
+
+	messages, err := subscriber.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	for _, expectedUUID := range []string{"old", msg.UUID} {
+		select {
+		case received := <-messages:
+			assert.Equal(t, expectedUUID, received.UUID)
+			received.Ack()
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+}
+
+func TestSchemaMigrator_PostgreSQL_namespace(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	topic := "migrator_" + watermill.NewShortUUID()
+	namespace := sql.PostgreSQLNamespace{
+		Schema:      "test_" + strings.ToLower(watermill.NewShortUUID()),
+		TablePrefix: "wm_",
+	}
+
+	migrator, err := sql.NewSchemaMigrator(sql.SchemaMigratorConfig{
+		DB:             db,
+		SchemaAdapter:  sql.DefaultPostgreSQLSchema{Namespace: namespace},
+		OffsetsAdapter: sql.DefaultPostgreSQLOffsetsAdapter{Namespace: namespace},
+		Logger:         logger,
+	})
+	require.NoError(t, err)
+
+	_, err = migrator.Migrate(context.Background(), topic)
+	require.NoError(t, err)
+
+	// the versions table is created in the namespace of the adapters
+	rows, err := db.QueryContext(
+		context.Background(),
+		`SELECT to_regclass($1) IS NOT NULL`,
+		fmt.Sprintf(`"%s"."wm_schema_versions"`, namespace.Schema),
+	)
+	require.NoError(t, err)
+
+	var exists bool
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&exists))
+	require.NoError(t, rows.Close())
+
+	assert.True(t, exists)
+}
+
+func TestSchemaMigrator_migrations(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name             string
+		Adapter          sql.SchemaMigrationsProvider
+		ExpectedVersions int
+	}{
+		{Name: "postgresql", Adapter: sql.DefaultPostgreSQLSchema{}, ExpectedVersions: 2},
+		{Name: "postgresql_offsets", Adapter: sql.DefaultPostgreSQLOffsetsAdapter{}, ExpectedVersions: 2},
+		{Name: "postgresql_queue", Adapter: sql.PostgreSQLQueueSchema{}, ExpectedVersions: 4},
+		{Name: "postgresql_shared_table", Adapter: sql.PostgreSQLSharedTableSchema{}, ExpectedVersions: 1},
+		{Name: "postgresql_shared_table_offsets", Adapter: sql.PostgreSQLSharedTableOffsetsAdapter{}, ExpectedVersions: 1},
+		{Name: "mysql", Adapter: sql.DefaultMySQLSchema{}, ExpectedVersions: 1},
+		{Name: "mysql_offsets", Adapter: sql.DefaultMySQLOffsetsAdapter{}, ExpectedVersions: 1},
+		{Name: "sqlite", Adapter: sql.DefaultSQLiteSchema{}, ExpectedVersions: 1},
+		{Name: "sqlite_offsets", Adapter: sql.DefaultSQLiteOffsetsAdapter{}, ExpectedVersions: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			migrations, err := tc.Adapter.SchemaMigrations("topic")
+			require.NoError(t, err)
+			require.Len(t, migrations, tc.ExpectedVersions)
+
+			for i, migration := range migrations {
+				assert.Equal(t, i+1, migration.Version)
+				assert.NotEmpty(t, migration.Description)
+				assert.NotEmpty(t, migration.Queries)
+			}
+
+			assert.Contains(t, strings.Join(queriesOf(migrations[0]), "\n"), "CREATE TABLE")
+		})
+	}
+}
+
+func TestSchemaMigrator_invalid_config(t *testing.T) {
+	t.Parallel()
+
+	_, err := sql.NewSchemaMigrator(sql.SchemaMigratorConfig{
+		DB:            newSQLite(t),
+		SchemaAdapter: struct{ sql.SchemaAdapter }{sql.DefaultSQLiteSchema{}},
+	})
+	assert.ErrorContains(t, err, "missing dialect")
+
+	_, err = sql.NewSchemaMigrator(sql.SchemaMigratorConfig{
+		DB:            newSQLite(t),
+		SchemaAdapter: struct{ sql.SchemaAdapter }{sql.DefaultSQLiteSchema{}},
+		Dialect:       sql.DialectSQLite,
+	})
+	assert.NoError(t, err)
+
+	_, err = sql.NewSchemaMigrator(sql.SchemaMigratorConfig{
+		SchemaAdapter: sql.DefaultSQLiteSchema{},
+	})
+	assert.ErrorContains(t, err, "missing db")
+}
+
+func migrationSteps(steps []sql.SchemaMigrationStep) []string {
+	var result []string
+	for _, step := range steps {
+		result = append(result, fmt.Sprintf("%s %d", step.Component, step.Migration.Version))
+	}
+
+	return result
+}
+
+func queriesOf(migration sql.SchemaMigration) []string {
+	var result []string
+	for _, query := range migration.Queries {
+		result = append(result, query.Query)
+	}
+
+	return result
+}
+
+func sqliteTablesCount(t *testing.T, db sql.Beginner, table string) int {
+	t.Helper()
+
+	rows, err := db.QueryContext(context.Background(), `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table)
+	require.NoError(t, err)
+
+	var count int
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&count))
+	require.NoError(t, rows.Close())
+
+	return count
+}
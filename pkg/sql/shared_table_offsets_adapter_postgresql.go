@@ -0,0 +1,189 @@
+package sql
+
+import (
+	"fmt"
+)
+
+// PostgreSQLSharedTableOffsetsAdapter is an OffsetsAdapter for the PostgreSQLSharedTableSchema.
+// It stores offsets of all topics in a single table, with the topic column.
+//
+// Offsets are locked and acked in the same way as with DefaultPostgreSQLOffsetsAdapter,
+// so it supports multiple subscribers with exactly once delivery and guaranteed order.
+type PostgreSQLSharedTableOffsetsAdapter struct {
+	// TableName is the name of the offsets table, without quotes.
+	// Defaults to "offsets" with Namespace's TablePrefix, so "watermill_offsets".
+	TableName string
+
+	// Namespace sets the PostgreSQL schema of the offsets table, and the prefix of its default name.
+	// It should be the same as Namespace of PostgreSQLSharedTableSchema.
+	Namespace PostgreSQLNamespace
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) SchemaInitializingQueries(params OffsetsSchemaInitializingQueriesParams) ([]Query, error) {
+	return append(a.Namespace.schemaInitializingQueries(), Query{
+		Query: `
+				CREATE TABLE IF NOT EXISTS ` + a.MessagesOffsetsTable(params.Topic) + ` (
+				topic VARCHAR(` + fmt.Sprintf("%d", maxSharedTableTopicLength) + `) NOT NULL,
+				consumer_group VARCHAR(255) NOT NULL,
+				offset_acked BIGINT,
+				last_processed_transaction_id xid8 NOT NULL,
+				PRIMARY KEY(topic, consumer_group)
+			)`,
+	}), nil
+}
+
+// SchemaMigrations returns migrations of the offsets table, see SchemaMigrator.
+func (a PostgreSQLSharedTableOffsetsAdapter) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := a.SchemaInitializingQueries(OffsetsSchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create offsets table", Queries: createQueries},
+	}, nil
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) NextOffsetQuery(params NextOffsetQueryParams) (Query, error) {
+	return Query{
+		Query: `
+			SELECT
+				offset_acked,
+				last_processed_transaction_id
+			FROM ` + a.MessagesOffsetsTable(params.Topic) + `
+			WHERE topic=$1 AND consumer_group=$2
+			FOR UPDATE
+		`,
+		Args: []any{params.Topic, params.ConsumerGroup},
+	}, nil
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) AckMessageQuery(params AckMessageQueryParams) (Query, error) {
+	ackQuery := `INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + `(offset_acked, last_processed_transaction_id, topic, consumer_group)
+	VALUES
+		($1, $2, $3, $4)
+	ON CONFLICT
+		(topic, consumer_group)
+	DO UPDATE SET
+		offset_acked = excluded.offset_acked,
+		last_processed_transaction_id = excluded.last_processed_transaction_id`
+
+	return Query{ackQuery, []any{
+		params.LastRow.Offset,
+		params.LastRow.ExtraData["transaction_id"],
+		params.Topic,
+		params.ConsumerGroup,
+	}}, nil
+}
+
+// MessagesOffsetsTable returns the offsets table, which is the same for all topics.
+func (a PostgreSQLSharedTableOffsetsAdapter) MessagesOffsetsTable(topic string) string {
+	if a.TableName == "" {
+		return a.Namespace.table("offsets")
+	}
+
+	return a.Namespace.qualify(a.TableName)
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) ConsumedMessageQuery(params ConsumedMessageQueryParams) (Query, error) {
+	return Query{}, nil
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) BeforeSubscribingQueries(params BeforeSubscribingQueriesParams) ([]Query, error) {
+	return []Query{
+		{
+			// "zero offsets" are required for the exactly-once delivery guarantee,
+			// see DefaultPostgreSQLOffsetsAdapter.BeforeSubscribingQueries
+			Query: `INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + ` (topic, consumer_group, offset_acked, last_processed_transaction_id) VALUES ($1, $2, 0, '0') ON CONFLICT DO NOTHING;`,
+			Args:  []any{params.Topic, params.ConsumerGroup},
+		},
+	}, nil
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectPostgreSQL,
+		ConsumerGroups: true,
+	}
+}
+
+// ValidateTopicName allows any topic which fits into the topic column.
+func (a PostgreSQLSharedTableOffsetsAdapter) ValidateTopicName(topic string) error {
+	return validateSharedTableTopicName(topic)
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) ConsumerGroupsQuery(params ConsumerGroupsQueryParams) (Query, error) {
+	// lag is counted in the same way as SelectQuery selects messages, messages from not committed
+	// transactions are not counted
+	return Query{
+		Query: `
+			SELECT
+				o.consumer_group,
+				COALESCE(o.offset_acked, 0),
+				o.last_processed_transaction_id::text,
+				(
+					SELECT COUNT(*) FROM ` + params.MessagesTable + ` m
+					WHERE
+						m.topic = o.topic
+						AND (m.transaction_id, m."offset") > (o.last_processed_transaction_id, COALESCE(o.offset_acked, 0))
+						AND m.transaction_id < pg_snapshot_xmin(pg_current_snapshot())
+				)
+			FROM ` + a.MessagesOffsetsTable(params.Topic) + ` o
+			WHERE o.topic = $1
+			ORDER BY o.consumer_group
+		`,
+		Args: []any{params.Topic},
+	}, nil
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) MessageExistsQuery(params MessageExistsQueryParams) (Query, error) {
+	return Query{
+		Query: `SELECT EXISTS (SELECT 1 FROM ` + params.MessagesTable + ` WHERE topic = $1 AND uuid = $2)`,
+		Args:  []any{params.Topic, params.MessageUUID},
+	}, nil
+}
+
+func (a PostgreSQLSharedTableOffsetsAdapter) SeekQuery(params SeekQueryParams) (Query, error) {
+	args := []any{params.Topic, params.ConsumerGroup}
+
+	// position is the last message before the next message to consume
+	var position string
+	switch params.SeekTo {
+	case SeekToEarliest:
+		position = `SELECT 0 AS "offset", '0'::xid8 AS transaction_id`
+	case SeekToLatest:
+		position = `
+			SELECT "offset", transaction_id FROM ` + params.MessagesTable + `
+			WHERE topic = $1 AND transaction_id < pg_snapshot_xmin(pg_current_snapshot())
+			ORDER BY transaction_id DESC, "offset" DESC
+			LIMIT 1`
+	case SeekToTimestamp:
+		args = append(args, params.Timestamp)
+		position = `
+			SELECT "offset", transaction_id FROM ` + params.MessagesTable + `
+			WHERE topic = $1 AND created_at < $3::timestamptz
+			ORDER BY transaction_id DESC, "offset" DESC
+			LIMIT 1`
+	case SeekToMessageUUID:
+		args = append(args, params.MessageUUID)
+		position = `
+			SELECT "offset" - 1 AS "offset", transaction_id FROM ` + params.MessagesTable + `
+			WHERE topic = $1 AND uuid = $3
+			ORDER BY transaction_id ASC, "offset" ASC
+			LIMIT 1`
+	default:
+		return Query{}, fmt.Errorf("unknown seek position: %d", params.SeekTo)
+	}
+
+	seekQuery := `
+		INSERT INTO ` + a.MessagesOffsetsTable(params.Topic) + ` (topic, consumer_group, offset_acked, last_processed_transaction_id)
+		SELECT $1, $2, COALESCE(p."offset", 0), COALESCE(p.transaction_id, '0')
+		FROM (SELECT 1) AS d LEFT JOIN (` + position + `) AS p ON TRUE
+		ON CONFLICT
+			(topic, consumer_group)
+		DO UPDATE SET
+			offset_acked = excluded.offset_acked,
+			last_processed_transaction_id = excluded.last_processed_transaction_id`
+
+	return Query{seekQuery, args}, nil
+}
@@ -0,0 +1,191 @@
+package sql_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func TestPostgreSQLSharedTable_ValidateTopicName(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name        string
+		Topic       string
+		ExpectedErr bool
+	}{
+		{
+			Name:  "path",
+			Topic: "tenants/acme/orders",
+		},
+		{
+			Name:  "sql",
+			Topic: "some_topic; DROP DATABASE `watermill`",
+		},
+		{
+			Name:  "unicode",
+			Topic: "zamówienia",
+		},
+		{
+			Name:        "empty",
+			Topic:       "",
+			ExpectedErr: true,
+		},
+		{
+			Name:        "too_long",
+			Topic:       strings.Repeat("a", 256),
+			ExpectedErr: true,
+		},
+		{
+			Name:        "nul",
+			Topic:       "topic\x00",
+			ExpectedErr: true,
+		},
+		{
+			Name:        "invalid_utf8",
+			Topic:       "topic\xff",
+			ExpectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, validator := range []sql.TopicNameValidator{
+				sql.PostgreSQLSharedTableSchema{},
+				sql.PostgreSQLSharedTableOffsetsAdapter{},
+			} {
+				err := validator.ValidateTopicName(tc.Topic)
+				if tc.ExpectedErr {
+					assert.ErrorIs(t, err, sql.ErrInvalidTopicName)
+				} else {
+					assert.NoError(t, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPostgreSQLSharedTable_topics_isolation(t *testing.T) {
+	t.Parallel()
+
+	db := newPostgreSQL(t)
+	schemaAdapter := newPostgresSharedTableSchemaAdapter()
+	offsetsAdapter := newPostgresSharedTableOffsetsAdapter()
+
+	publisher, subscriber := newPubSub(t, db, "isolation", schemaAdapter, offsetsAdapter)
+
+	// topics which are not valid identifiers
+	topics := []string{
+		"tenants/" + watermill.NewShortUUID() + "/orders",
+		"tenants/" + watermill.NewShortUUID() + "/orders; DROP TABLE watermill_messages",
+	}
+
+	expectedUUIDs := map[string][]string{}
+	for _, topic := range topics {
+		require.NoError(t, subscriber.(message.SubscribeInitializer).SubscribeInitialize(topic))
+
+		for i := 0; i < 5; i++ {
+			msg := message.NewMessage(watermill.NewUUID(), nil)
+			require.NoError(t, publisher.Publish(topic, msg))
+			expectedUUIDs[topic] = append(expectedUUIDs[topic], msg.UUID)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	admin, err := sql.NewOffsetsAdmin(db, schemaAdapter, offsetsAdapter)
+	require.NoError(t, err)
+
+	for _, topic := range topics {
+		messages, err := subscriber.Subscribe(ctx, topic)
+		require.NoError(t, err)
+
+		var receivedUUIDs []string
+		for range expectedUUIDs[topic] {
+			select {
+			case msg := <-messages:
+				receivedUUIDs = append(receivedUUIDs, msg.UUID)
+				msg.Ack()
+			case <-time.After(5 * time.Second):
+				t.Fatal("message not received")
+			}
+		}
+		assert.Equal(t, expectedUUIDs[topic], receivedUUIDs)
+
+		select {
+		case msg := <-messages:
+			t.Fatalf("message %s of another topic received", msg.UUID)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		assert.Eventually(t, func() bool {
+			groups, err := admin.ConsumerGroups(context.Background(), topic)
+			require.NoError(t, err)
+			require.Len(t, groups, 1)
+
+			return groups[0].Lag == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestPostgreSQLSharedTableSchema_queries(t *testing.T) {
+	t.Parallel()
+
+	schemaAdapter := sql.PostgreSQLSharedTableSchema{
+		InitializeSchemaWithoutTransaction: true,
+		GeneratePayloadType: func(topic string) string {
+			return "BYTEA"
+		},
+		Marshaler: tenantMarshaler{},
+		JSONB:     true,
+		GenerateIndexes: func(topic string) []sql.PostgreSQLIndex {
+			return []sql.PostgreSQLIndex{
+				sql.PostgreSQLMetadataGINIndex("messages_metadata_idx"),
+			}
+		},
+	}
+
+	queries, err := schemaAdapter.SchemaInitializingQueries(sql.SchemaInitializingQueriesParams{Topic: "topic"})
+	require.NoError(t, err)
+
+	var schemaQueries []string
+	for _, query := range queries {
+		schemaQueries = append(schemaQueries, query.Query)
+	}
+
+	createTable := strings.Join(schemaQueries, "\n")
+	assert.Contains(t, createTable, `"payload" BYTEA DEFAULT NULL`)
+	assert.Contains(t, createTable, `"metadata" JSONB DEFAULT NULL`)
+	assert.Contains(t, createTable, `"tenant" TEXT DEFAULT NULL`)
+	assert.Contains(
+		t,
+		schemaQueries,
+		`CREATE INDEX IF NOT EXISTS "messages_metadata_idx" ON "watermill_messages" USING gin (metadata jsonb_path_ops)`,
+	)
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{"foo":"bar"}`))
+	msg.Metadata.Set("tenant", "acme")
+
+	insertQuery, err := schemaAdapter.InsertQuery(sql.InsertQueryParams{
+		Topic: "topic",
+		Msgs:  message.Messages{msg, msg},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, insertQuery.Query, `(topic, uuid, payload, metadata, "tenant", transaction_id)`)
+	require.Len(t, insertQuery.Args, 10)
+	assert.Equal(t, "topic", insertQuery.Args[5])
+	assert.Equal(t, msg.UUID, insertQuery.Args[6])
+	assert.Equal(t, "acme", insertQuery.Args[9])
+}
@@ -0,0 +1,352 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxSharedTableTopicLength is the length of the topic column of PostgreSQLSharedTableSchema.
+const maxSharedTableTopicLength = 255
+
+// PostgreSQLSharedTableSchema is a schema adapter for PostgreSQL which stores messages of all topics in a single table,
+// with the topic column. It should be used with PostgreSQLSharedTableOffsetsAdapter, which stores offsets
+// of all topics in a single table as well.
+//
+// It's useful when there are many topics (for example, a topic per tenant), so creating two tables
+// per topic is not practical. Messages are ordered and delivered exactly once, like with DefaultPostgreSQLSchema.
+//
+// Topics are not used in SQL identifiers, so they can contain any characters (see ValidateTopicName).
+type PostgreSQLSharedTableSchema struct {
+	// TableName is the name of the messages table, without quotes.
+	// Defaults to "messages" with Namespace's TablePrefix, so "watermill_messages".
+	TableName string
+
+	// Namespace sets the PostgreSQL schema of the messages table, and the prefix of its default name.
+	// It should be the same as Namespace of PostgreSQLSharedTableOffsetsAdapter.
+	Namespace PostgreSQLNamespace
+
+	// GeneratePayloadType is the type of the payload column in the messages table.
+	// By default, it's JSON. If your payload is not JSON, you can use BYTEA.
+	// The table is shared by all topics, so it should return the same type for every topic.
+	//
+	// JSONB may be used for JSON payloads which don't need to be stored byte for byte.
+	// JSONB normalizes the payload: whitespace and the order of keys are not preserved,
+	// and only the last value of duplicate keys is kept.
+	GeneratePayloadType func(topic string) string
+
+	// GenerateMetadataType is the type of the metadata column in the messages table.
+	// By default, it's JSON (or JSONB, see JSONB).
+	// The table is shared by all topics, so it should return the same type for every topic.
+	GenerateMetadataType func(topic string) string
+
+	// Marshaler converts messages to values of the messages table columns, and back.
+	// The table is shared by all topics, so its extra columns should be the same for every topic.
+	// Defaults to DefaultMarshaler.
+	Marshaler Marshaler
+
+	// JSONB creates the metadata column as JSONB instead of JSON, unless its type is set with GenerateMetadataType.
+	// See DefaultPostgreSQLSchema.JSONB.
+	// Existing tables are not converted, see JSONBMigrationQueries.
+	JSONB bool
+
+	// GenerateIndexes returns indexes of the messages table created with the table,
+	// for example PostgreSQLMetadataGINIndex or PostgreSQLMetadataKeyIndex.
+	// The indexes are created when a topic is initialized, and they index messages of all topics,
+	// unless they are limited to the topic with PostgreSQLIndex.Where.
+	// Indexes are not dropped when they are removed from the list.
+	GenerateIndexes func(topic string) []PostgreSQLIndex
+
+	// SubscribeBatchSize is the number of messages to be queried at once.
+	//
+	// Higher value, increases a chance of message re-delivery in case of crash or networking issues.
+	// 1 is the safest value, but it may have a negative impact on performance when consuming a lot of messages.
+	//
+	// Default value is 100.
+	SubscribeBatchSize int
+
+	// InitializeSchemaWithoutTransaction disables initializing the schema in a transaction,
+	// see DefaultPostgreSQLSchema.InitializeSchemaWithoutTransaction.
+	InitializeSchemaWithoutTransaction bool
+
+	// InitializeSchemaLock is a PostgreSQL advisory lock to be acquired before initializing the schema.
+	// If empty and InitializeSchemaWithoutTransaction is false, a default will be used.
+	InitializeSchemaLock int
+}
+
+func (s PostgreSQLSharedTableSchema) SchemaInitializingQueries(params SchemaInitializingQueriesParams) ([]Query, error) {
+	// the topic is first in the primary key, so each topic is read with a range scan of the index,
+	// like a separate table
+	createMessagesTable := `
+		CREATE TABLE IF NOT EXISTS ` + s.MessagesTable(params.Topic) + ` (
+			"topic" VARCHAR(` + strconv.Itoa(maxSharedTableTopicLength) + `) NOT NULL,
+			"offset" BIGSERIAL,
+			"uuid" VARCHAR(36) NOT NULL,
+			"created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			"payload" ` + s.PayloadColumnType(params.Topic) + ` DEFAULT NULL,
+			"metadata" ` + s.MetadataColumnType(params.Topic) + ` DEFAULT NULL,
+			"transaction_id" xid8 NOT NULL` + marshalerColumnsDefinitions(s.marshaler().ExtraColumns(params.Topic), `"`) + `,
+			PRIMARY KEY ("topic", "transaction_id", "offset")
+		);
+	`
+
+	// used by Cleaner and OffsetsAdmin, which look for messages of the topic by their age
+	createCreatedAtIndex := fmt.Sprintf(
+		`CREATE INDEX IF NOT EXISTS %s ON %s ("topic", "created_at")`,
+		pgx.Identifier{s.tableName() + "_topic_created_at_idx"}.Sanitize(),
+		s.MessagesTable(params.Topic),
+	)
+
+	queries := append(
+		s.Namespace.schemaInitializingQueries(),
+		Query{Query: createMessagesTable},
+		Query{Query: createCreatedAtIndex},
+	)
+	if s.GenerateIndexes != nil {
+		indexQueries, err := postgreSQLIndexQueries(s.MessagesTable(params.Topic), s.GenerateIndexes(params.Topic))
+		if err != nil {
+			return nil, fmt.Errorf("invalid index: %w", err)
+		}
+
+		queries = append(queries, indexQueries...)
+	}
+
+	if !s.InitializeSchemaWithoutTransaction {
+		lock := DefaultSchemaInitializationLock("watermill")
+		if s.InitializeSchemaLock > 0 {
+			lock = s.InitializeSchemaLock
+		}
+
+		queries = append([]Query{
+			{Query: fmt.Sprintf("SELECT pg_advisory_xact_lock(%d);", lock)},
+		}, queries...)
+	}
+
+	return queries, nil
+}
+
+// SchemaMigrations returns migrations of the messages table, see SchemaMigrator.
+func (s PostgreSQLSharedTableSchema) SchemaMigrations(topic string) ([]SchemaMigration, error) {
+	createQueries, err := s.SchemaInitializingQueries(SchemaInitializingQueriesParams{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	return []SchemaMigration{
+		{Version: 1, Description: "create messages table", Queries: createQueries},
+	}, nil
+}
+
+func (s PostgreSQLSharedTableSchema) InsertQuery(params InsertQueryParams) (Query, error) {
+	extraColumns := s.marshaler().ExtraColumns(params.Topic)
+	columns := 3 + len(extraColumns)
+
+	insertQuery := fmt.Sprintf(
+		`INSERT INTO %s (topic, uuid, payload, metadata%s, transaction_id) VALUES %s`,
+		s.MessagesTable(params.Topic),
+		marshalerColumnsList(extraColumns, `"`, ""),
+		defaultInsertMarkers(len(params.Msgs), columns+1),
+	)
+
+	values, err := marshalMessages(s.marshaler(), params.Topic, params.Msgs)
+	if err != nil {
+		return Query{}, err
+	}
+
+	// the topic precedes the marshaled values of each message
+	args := make([]any, 0, len(params.Msgs)*(columns+1))
+	for i := range params.Msgs {
+		args = append(args, params.Topic)
+		args = append(args, values[i*columns:(i+1)*columns]...)
+	}
+
+	return Query{insertQuery, args}, nil
+}
+
+// sharedTables marks the adapter as storing messages of all topics in the same table, see SchemaVersionsConfig.
+func (s PostgreSQLSharedTableSchema) sharedTables() {}
+
+func (s PostgreSQLSharedTableSchema) batchSize() int {
+	if s.SubscribeBatchSize == 0 {
+		return 100
+	}
+
+	return s.SubscribeBatchSize
+}
+
+func (s PostgreSQLSharedTableSchema) SelectQuery(params SelectQueryParams) (Query, error) {
+	nextOffsetQuery, err := params.OffsetsAdapter.NextOffsetQuery(NextOffsetQueryParams{
+		Topic:         params.Topic,
+		ConsumerGroup: params.ConsumerGroup,
+	})
+	if err != nil {
+		return Query{}, err
+	}
+
+	args := append(nextOffsetQuery.Args, params.Topic)
+	topicPlaceholder := "$" + strconv.Itoa(len(args))
+
+	// the same query as in DefaultPostgreSQLSchema, limited to the topic,
+	// see DefaultPostgreSQLSchema.SelectQuery for details
+	selectQuery := `
+	SELECT * FROM (
+		WITH last_processed AS (
+			` + nextOffsetQuery.Query + `
+		)
+
+		SELECT "offset", transaction_id::text, uuid, payload, metadata` +
+		marshalerColumnsList(s.marshaler().ExtraColumns(params.Topic), `"`, "") + ` FROM ` + s.MessagesTable(params.Topic) + `
+
+		WHERE
+			topic = ` + topicPlaceholder + `
+		AND
+		(
+			(
+				transaction_id = (SELECT last_processed_transaction_id FROM last_processed)
+				AND
+				"offset" > (SELECT offset_acked FROM last_processed)
+			)
+			OR
+			(transaction_id > (SELECT last_processed_transaction_id FROM last_processed))
+		)
+		AND
+			transaction_id < pg_snapshot_xmin(pg_current_snapshot())
+	) AS messages
+	ORDER BY
+		transaction_id ASC,
+		"offset" ASC
+	LIMIT ` + fmt.Sprintf("%d", s.batchSize())
+
+	return Query{selectQuery, args}, nil
+}
+
+func (s PostgreSQLSharedTableSchema) UnmarshalMessage(params UnmarshalMessageParams) (Row, error) {
+	r := Row{}
+	var transactionID XID8
+
+	err := unmarshalRow(s.marshaler(), params.Topic, params.Row, &r, &r.Offset, &transactionID, &r.UUID, &r.Payload, &r.Metadata)
+	if err != nil {
+		return Row{}, err
+	}
+
+	if r.ExtraData == nil {
+		r.ExtraData = map[string]any{}
+	}
+	r.ExtraData["transaction_id"] = transactionID
+
+	return r, nil
+}
+
+// MessagesTable returns the messages table, which is the same for all topics.
+func (s PostgreSQLSharedTableSchema) MessagesTable(topic string) string {
+	return s.Namespace.qualify(s.tableName())
+}
+
+func (s PostgreSQLSharedTableSchema) tableName() string {
+	if s.TableName == "" {
+		return s.Namespace.prefix() + "messages"
+	}
+
+	return s.TableName
+}
+
+func (s PostgreSQLSharedTableSchema) PayloadColumnType(topic string) string {
+	if s.GeneratePayloadType == nil {
+		return "JSON"
+	}
+
+	return s.GeneratePayloadType(topic)
+}
+
+func (s PostgreSQLSharedTableSchema) MetadataColumnType(topic string) string {
+	if s.GenerateMetadataType == nil {
+		if s.JSONB {
+			return "JSONB"
+		}
+
+		return "JSON"
+	}
+
+	return s.GenerateMetadataType(topic)
+}
+
+func (s PostgreSQLSharedTableSchema) marshaler() Marshaler {
+	return marshalerOrDefault(s.Marshaler)
+}
+
+func (s PostgreSQLSharedTableSchema) SubscribeIsolationLevel() sql.IsolationLevel {
+	// For Postgres Repeatable Read is enough.
+	return sql.LevelRepeatableRead
+}
+
+func (s PostgreSQLSharedTableSchema) RequiresTransaction() bool {
+	return !s.InitializeSchemaWithoutTransaction
+}
+
+func (s PostgreSQLSharedTableSchema) Capabilities() Capabilities {
+	return Capabilities{
+		Dialect:        DialectPostgreSQL,
+		ConsumerGroups: true,
+	}
+}
+
+// ValidateTopicName allows any topic which fits into the topic column.
+func (s PostgreSQLSharedTableSchema) ValidateTopicName(topic string) error {
+	return validateSharedTableTopicName(topic)
+}
+
+func (s PostgreSQLSharedTableSchema) CleanupQuery(params CleanupQueryParams) (Query, error) {
+	table := s.MessagesTable(params.Topic)
+
+	args := []any{params.Topic}
+	conditions := []string{"m.topic = $1"}
+
+	if params.MaxAge > 0 {
+		args = append(args, params.MaxAge.Seconds())
+		conditions = append(conditions, `m.created_at < CURRENT_TIMESTAMP - make_interval(secs => $`+strconv.Itoa(len(args))+`)`)
+	}
+
+	if params.OnlyAcked {
+		offsetsTable, err := cleanupOffsetsTable(params)
+		if err != nil {
+			return Query{}, err
+		}
+
+		// the same order as in SelectQuery, a message is acked if it's not after the last acked message
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM `+offsetsTable+` o WHERE o.topic = m.topic)`)
+		conditions = append(conditions, `NOT EXISTS (
+			SELECT 1 FROM `+offsetsTable+` o
+			WHERE o.topic = m.topic
+			AND (m.transaction_id, m."offset") > (o.last_processed_transaction_id, COALESCE(o.offset_acked, 0))
+		)`)
+	}
+
+	cleanupQuery := `
+		DELETE FROM ` + table + `
+		WHERE (topic, transaction_id, "offset") IN (
+			SELECT topic, transaction_id, "offset" FROM ` + table + ` m
+			WHERE ` + strings.Join(conditions, " AND ") + `
+			ORDER BY transaction_id, "offset"
+			LIMIT ` + strconv.Itoa(params.BatchSize) + `
+		)`
+
+	return Query{cleanupQuery, args}, nil
+}
+
+func validateSharedTableTopicName(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("topic is empty: %w", ErrInvalidTopicName)
+	}
+	if len(topic) > maxSharedTableTopicLength {
+		return fmt.Errorf("topic is longer than %d bytes: %w", maxSharedTableTopicLength, ErrInvalidTopicName)
+	}
+	if !utf8.ValidString(topic) || strings.ContainsRune(topic, 0) {
+		return fmt.Errorf("topic %q is not a valid UTF-8 string without NUL characters: %w", topic, ErrInvalidTopicName)
+	}
+
+	return nil
+}
@@ -5,15 +5,18 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/oklog/ulid"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 )
 
 var (
@@ -43,6 +46,23 @@ type SubscriberConfig struct {
 	// Must be non-negative. Defaults to 1s.
 	ResendInterval time.Duration
 
+	// MaxDeliveryAttempts is the number of times a message is delivered before it's moved to the dead-letter topic.
+	// The message is published to the dead-letter topic in the same transaction in which it's acked,
+	// so the consumer can move on to the next messages.
+	//
+	// If the SchemaAdapter persists delivery attempts (like PostgreSQLQueueSchema), they are counted as well,
+	// so a message which crashes the subscriber is moved to the dead-letter topic instead of being delivered again.
+	//
+	// Must be non-negative. Defaults to 0, which means that nacked messages are resent until they are acked.
+	MaxDeliveryAttempts int
+
+	// GenerateDeadLetterTopic returns the topic where messages are published after MaxDeliveryAttempts nacks.
+	// Dead-lettered messages are stored with the SchemaAdapter, and have middleware.PoisonedTopicKey
+	// and middleware.ReasonForPoisonedKey metadata set, so they can be requeued with DelayedRequeuer.
+	//
+	// Defaults to the topic with "_dead_letter" suffix.
+	GenerateDeadLetterTopic func(topic string) string
+
 	// RetryInterval is the time to wait before resuming querying for messages after an error (Prefer using the BackoffManager instead).
 	// Must be non-negative. Defaults to 1s.
 	RetryInterval time.Duration
@@ -58,6 +78,41 @@ type SubscriberConfig struct {
 
 	// InitializeSchema option enables initializing schema on making subscription.
 	InitializeSchema bool
+
+	// SchemaVersions configures how InitializeSchema handles the schema versions recorded by SchemaMigrator,
+	// for example applying pending migrations of existing topics. See SchemaVersionsConfig.
+	SchemaVersions SchemaVersionsConfig
+
+	// LeasedMessageTx makes the transaction acking a leased message available to the handler with TxFromContext,
+	// when the SchemaAdapter leases messages (see PostgreSQLQueueSchema.LeaseDuration).
+	//
+	// By default, the handler of a leased message runs without a transaction, and the message is acked
+	// in a new short transaction after the handler acks it. With LeasedMessageTx, the transaction is open
+	// while the handler runs, so it gives up the benefit of the lease mode of not holding transactions
+	// (and database connections) open during processing. If the lease is lost before the message is acked,
+	// the transaction is rolled back.
+	LeasedMessageTx bool
+
+	// Notifier is optional. When set, the Subscriber stops waiting for the next query when it's notified
+	// about new messages (for example, with PostgreSQLNotifier).
+	// PollInterval is still used as a fallback, when a notification is missed or the notifier is not available.
+	Notifier Notifier
+
+	// Metrics is optional. When set, it's notified about consumed messages, queries, and backoff.
+	Metrics Metrics
+
+	// LagReportInterval is the interval of reporting the consumer group lag to Metrics.
+	// The lag is reported only for adapters supported by OffsetsAdmin.
+	// Must be non-negative. Defaults to 30s.
+	LagReportInterval time.Duration
+
+	// Tracing enables OpenTelemetry tracing. Disabled by default.
+	Tracing TracingConfig
+
+	// TopicsRescanInterval is the interval of listing the messages tables by SubscribePattern,
+	// to subscribe to topics created after subscribing.
+	// Must be non-negative. Defaults to 10s.
+	TopicsRescanInterval time.Duration
 }
 
 func (c *SubscriberConfig) setDefaults() {
@@ -77,6 +132,21 @@ func (c *SubscriberConfig) setDefaults() {
 	if c.BackoffManager == nil {
 		c.BackoffManager = NewDefaultBackoffManager(c.PollInterval, c.RetryInterval)
 	}
+	if c.GenerateDeadLetterTopic == nil {
+		c.GenerateDeadLetterTopic = func(topic string) string {
+			return topic + "_dead_letter"
+		}
+	}
+	if c.Metrics == nil {
+		c.Metrics = NopMetrics{}
+	}
+	if c.LagReportInterval == 0 {
+		c.LagReportInterval = time.Second * 30
+	}
+	if c.TopicsRescanInterval == 0 {
+		c.TopicsRescanInterval = time.Second * 10
+	}
+	c.Tracing.setDefaults()
 }
 
 func (c SubscriberConfig) validate() error {
@@ -95,12 +165,24 @@ func (c SubscriberConfig) validate() error {
 	if c.RetryInterval <= 0 {
 		return errors.New("resend interval must be a positive duration")
 	}
+	if c.MaxDeliveryAttempts < 0 {
+		return errors.New("max delivery attempts must be non-negative")
+	}
+	if c.LagReportInterval < 0 {
+		return errors.New("lag report interval must be non-negative")
+	}
+	if c.TopicsRescanInterval < 0 {
+		return errors.New("topics rescan interval must be non-negative")
+	}
 	if c.SchemaAdapter == nil {
 		return errors.New("schema adapter is nil")
 	}
 	if c.OffsetsAdapter == nil {
 		return errors.New("offsets adapter is nil")
 	}
+	if err := checkAdaptersCompatibility(c.SchemaAdapter, c.OffsetsAdapter, c.ConsumerGroup); err != nil {
+		return fmt.Errorf("incompatible adapters: %w", err)
+	}
 
 	return nil
 }
@@ -119,6 +201,7 @@ type Subscriber struct {
 	closed      uint32
 
 	logger watermill.LoggerAdapter
+	tracer tracer
 }
 
 func NewSubscriber(db Beginner, config SubscriberConfig, logger watermill.LoggerAdapter) (*Subscriber, error) {
@@ -152,6 +235,7 @@ func NewSubscriber(db Beginner, config SubscriberConfig, logger watermill.Logger
 		closing:     make(chan struct{}),
 
 		logger: logger,
+		tracer: newTracer(config.Tracing),
 	}
 
 	return sub, nil
@@ -172,10 +256,16 @@ func (s *Subscriber) Subscribe(ctx context.Context, topic string) (o <-chan *mes
 		return nil, ErrSubscriberClosed
 	}
 
-	if err = validateTopicName(topic); err != nil {
+	if err = validateTopic(topic, s.config.SchemaAdapter, s.config.OffsetsAdapter); err != nil {
 		return nil, err
 	}
 
+	if s.config.MaxDeliveryAttempts > 0 {
+		if err = validateTopic(s.config.GenerateDeadLetterTopic(topic), s.config.SchemaAdapter); err != nil {
+			return nil, fmt.Errorf("invalid dead-letter topic: %w", err)
+		}
+	}
+
 	if s.config.InitializeSchema {
 		if err := s.SubscribeInitialize(topic); err != nil {
 			return nil, err
@@ -213,17 +303,34 @@ func (s *Subscriber) Subscribe(ctx context.Context, topic string) (o <-chan *mes
 	ctx, cancel := context.WithCancel(ctx)
 	out := make(chan *message.Message)
 
+	var notifications <-chan struct{}
+	if s.config.Notifier != nil {
+		notifications, err = s.config.Notifier.Listen(ctx, topic)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("cannot listen for notifications: %w", err)
+		}
+	}
+
 	s.subscribeWg.Add(1)
 	go func() {
-		s.consume(ctx, topic, out)
+		s.consume(ctx, topic, out, notifications)
 		close(out)
 		cancel()
 	}()
 
+	if _, nop := s.config.Metrics.(NopMetrics); !nop {
+		s.subscribeWg.Add(1)
+		go s.reportLag(ctx, topic, s.logger.With(watermill.LogFields{
+			"topic":          topic,
+			"consumer_group": s.config.ConsumerGroup,
+		}))
+	}
+
 	return out, nil
 }
 
-func (s *Subscriber) consume(ctx context.Context, topic string, out chan *message.Message) {
+func (s *Subscriber) consume(ctx context.Context, topic string, out chan *message.Message, notifications <-chan struct{}) {
 	defer s.subscribeWg.Done()
 
 	logger := s.logger.With(watermill.LogFields{
@@ -243,6 +350,14 @@ func (s *Subscriber) consume(ctx context.Context, topic string, out chan *messag
 			return
 
 		case <-time.After(sleepTime): // Wait if needed
+
+		case _, ok := <-notifications:
+			if !ok {
+				logger.Info("Notifier closed, falling back to polling", nil)
+				notifications = nil
+			} else {
+				logger.Trace("Notified about new messages", nil)
+			}
 		}
 
 		noMsg, err := s.query(ctx, topic, out, logger)
@@ -256,6 +371,7 @@ func (s *Subscriber) consume(ctx context.Context, topic string, out chan *messag
 				logFields.Add(watermill.LogFields{"err": err.Error()})
 			}
 			logger.Trace("Backing off querying", logFields)
+			s.config.Metrics.BackoffSleep(topic, s.config.ConsumerGroup, backoff)
 		}
 		sleepTime = backoff
 	}
@@ -267,6 +383,10 @@ func (s *Subscriber) query(
 	out chan *message.Message,
 	logger watermill.LoggerAdapter,
 ) (noMsg bool, err error) {
+	if s.leasesMessages() {
+		return s.queryLeased(ctx, topic, out, logger)
+	}
+
 	txOptions := &sql.TxOptions{
 		Isolation: s.config.SchemaAdapter.SubscribeIsolationLevel(),
 	}
@@ -298,6 +418,7 @@ func (s *Subscriber) query(
 			Topic:          topic,
 			ConsumerGroup:  s.config.ConsumerGroup,
 			OffsetsAdapter: s.config.OffsetsAdapter,
+			ConsumerULID:   s.consumerIdBytes,
 		},
 	)
 	if err != nil {
@@ -307,8 +428,11 @@ func (s *Subscriber) query(
 		"query":      selectQuery.Query,
 		"query_args": sqlArgsToLog(selectQuery.Args),
 	})
+	selectStart := time.Now()
 	rows, err := tx.QueryContext(ctx, selectQuery.Query, selectQuery.Args...)
 	if err != nil {
+		s.config.Metrics.QueryDuration(MetricsQuerySelect, topic, time.Since(selectStart), err)
+		s.tracer.recordQuery(ctx, "select", selectStart, err, s.tracingAttributes(topic)...)
 		return false, fmt.Errorf("could not query message: %w", err)
 	}
 
@@ -320,23 +444,55 @@ func (s *Subscriber) query(
 
 	var lastOffset int64
 	var lastRow Row
+	var processedRows []Row
 
 	messageRows := make([]Row, 0)
 
 	for rows.Next() {
 		row, err := s.config.SchemaAdapter.UnmarshalMessage(UnmarshalMessageParams{
-			Row: rows,
+			Topic: topic,
+			Row:   rows,
 		})
 		if errors.Is(err, sql.ErrNoRows) {
+			s.config.Metrics.QueryDuration(MetricsQuerySelect, topic, time.Since(selectStart), nil)
 			return true, nil
 		} else if err != nil {
+			s.config.Metrics.QueryDuration(MetricsQuerySelect, topic, time.Since(selectStart), err)
+			s.tracer.recordQuery(ctx, "select", selectStart, err, s.tracingAttributes(topic)...)
 			return false, fmt.Errorf("could not unmarshal message from query: %w", err)
 		}
 
 		messageRows = append(messageRows, row)
 	}
 
+	// rows are read before processing messages, so the duration doesn't include the time of handling them
+	s.config.Metrics.QueryDuration(MetricsQuerySelect, topic, time.Since(selectStart), nil)
+
+	if len(messageRows) == 0 {
+		return true, nil
+	}
+
+	// the receive span is started after messages are found, so polling doesn't create empty spans
+	ctx, receiveSpan := s.tracer.startReceive(ctx, topic, s.config.ConsumerGroup, len(messageRows), selectStart)
+	defer func() {
+		endSpan(receiveSpan, err)
+	}()
+	s.tracer.recordQuery(
+		ctx,
+		"select",
+		selectStart,
+		nil,
+		append(s.tracingAttributes(topic), TracingAttributeBatchSize.Int(len(messageRows)))...,
+	)
+
 	for _, row := range messageRows {
+		if row.Skipped {
+			lastOffset = row.Offset
+			lastRow = row
+			processedRows = append(processedRows, row)
+			continue
+		}
+
 		acked, err := s.processMessage(ctx, topic, row, tx, out, logger)
 		if err != nil {
 			return false, fmt.Errorf("could not process message: %w", err)
@@ -347,6 +503,7 @@ func (s *Subscriber) query(
 
 		lastOffset = row.Offset
 		lastRow = row
+		processedRows = append(processedRows, row)
 	}
 
 	if lastOffset == 0 {
@@ -357,7 +514,7 @@ func (s *Subscriber) query(
 		AckMessageQueryParams{
 			Topic:         topic,
 			LastRow:       lastRow,
-			Rows:          messageRows,
+			Rows:          processedRows,
 			ConsumerGroup: s.config.ConsumerGroup,
 		},
 	)
@@ -370,7 +527,15 @@ func (s *Subscriber) query(
 		"query_args": sqlArgsToLog(ackQuery.Args),
 	})
 
-	result, err := tx.ExecContext(ctx, ackQuery.Query, ackQuery.Args...)
+	ackCtx, ackSpan := s.tracer.startQuery(
+		ctx,
+		"ack",
+		append(s.tracingAttributes(topic), TracingAttributeOffset.Int64(lastRow.Offset))...,
+	)
+	ackStart := time.Now()
+	result, err := tx.ExecContext(ackCtx, ackQuery.Query, ackQuery.Args...)
+	s.config.Metrics.QueryDuration(MetricsQueryAck, topic, time.Since(ackStart), err)
+	endSpan(ackSpan, err)
 	if err != nil {
 		return false, fmt.Errorf("could not get args for acking the message: %w", err)
 	}
@@ -391,13 +556,19 @@ func (s *Subscriber) processMessage(
 	tx Tx,
 	out chan *message.Message,
 	logger watermill.LoggerAdapter,
-) (bool, error) {
+) (acked bool, err error) {
 	if *s.config.AckDeadline != 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, *s.config.AckDeadline)
 		defer cancel()
 	}
 
+	ctx, span := s.tracer.startProcess(ctx, topic, s.config.ConsumerGroup, row)
+	defer func() {
+		span.SetAttributes(tracingAttributeAcked.Bool(acked))
+		endSpan(span, err)
+	}()
+
 	consumedQuery, err := s.config.OffsetsAdapter.ConsumedMessageQuery(
 		ConsumedMessageQueryParams{
 			Topic:         topic,
@@ -415,7 +586,13 @@ func (s *Subscriber) processMessage(
 			"query_args": sqlArgsToLog(consumedQuery.Args),
 		})
 
-		_, err := tx.ExecContext(ctx, consumedQuery.Query, consumedQuery.Args...)
+		consumedCtx, consumedSpan := s.tracer.startQuery(
+			ctx,
+			"consumed",
+			append(s.tracingAttributes(topic), TracingAttributeOffset.Int64(row.Offset))...,
+		)
+		_, err := tx.ExecContext(consumedCtx, consumedQuery.Query, consumedQuery.Args...)
+		endSpan(consumedSpan, err)
 		if err != nil {
 			return false, fmt.Errorf("cannot send consumed query: %w", err)
 		}
@@ -423,6 +600,13 @@ func (s *Subscriber) processMessage(
 		logger.Trace("Executed query to confirm message consumed", nil)
 	}
 
+	if querier, ok := s.config.OffsetsAdapter.(DeliveryAttemptQuerier); ok {
+		row, err = s.recordDeliveryAttempt(ctx, topic, row, querier, logger)
+		if err != nil {
+			return false, err
+		}
+	}
+
 	logger = logger.With(watermill.LogFields{
 		"msg_uuid": row.Msg.UUID,
 	})
@@ -430,41 +614,85 @@ func (s *Subscriber) processMessage(
 
 	msgCtx := setTxToContext(ctx, tx)
 
-	return s.sendMessage(msgCtx, row.Msg, out, logger), nil
+	return s.sendMessage(msgCtx, topic, row, tx, out, logger)
 }
 
 // sendMessages sends messages on the output channel.
+// When the message is moved to the dead-letter topic, it's considered acked.
 func (s *Subscriber) sendMessage(
 	ctx context.Context,
-	msg *message.Message,
+	topic string,
+	row Row,
+	tx Tx,
 	out chan *message.Message,
 	logger watermill.LoggerAdapter,
-) (acked bool) {
+) (acked bool, err error) {
+	msg := row.Msg
+
 	msgCtx, cancel := context.WithCancel(ctx)
 	msg.SetContext(msgCtx)
 	defer cancel()
 
+	// attempts persisted by the schema adapter include deliveries interrupted by a crash or a rolled back transaction
+	deliveryAttempts := previousDeliveryAttempts(row)
+	if s.reachedMaxDeliveryAttempts(deliveryAttempts) {
+		if err := s.deadLetter(ctx, topic, msg, tx, deliveredWithoutAckReason(deliveryAttempts), logger); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
 ResendLoop:
 	for {
+		deliveryAttempts++
 
 		select {
 		case out <- msg:
+			s.config.Metrics.MessageConsumed(topic, s.config.ConsumerGroup)
 
 		case <-s.closing:
 			logger.Info("Discarding queued message, subscriber closing", nil)
-			return false
+			return false, nil
 
 		case <-ctx.Done():
 			logger.Info("Discarding queued message, context canceled", nil)
-			return false
+			return false, nil
 		}
 
 		select {
 		case <-msg.Acked():
 			logger.Debug("Message acked by subscriber", nil)
-			return true
+			s.config.Metrics.MessageAcked(topic, s.config.ConsumerGroup)
+			return true, nil
 
 		case <-msg.Nacked():
+			s.config.Metrics.MessageNacked(topic, s.config.ConsumerGroup)
+
+			if err := s.notAcked(ctx, topic, row, tx, notAckedReasonNacked, logger); err != nil {
+				return false, err
+			}
+
+			if s.reachedMaxDeliveryAttempts(deliveryAttempts) {
+				err := s.deadLetter(ctx, topic, msg, tx, nackedReason(deliveryAttempts), logger)
+				if err != nil {
+					return false, err
+				}
+
+				return true, nil
+			}
+
+			if _, ok := s.config.OffsetsAdapter.(DeliveryAttemptQuerier); ok {
+				// the message is selected again after the transaction is committed, so the next delivery is recorded
+				logger.Debug("Message nacked, releasing", nil)
+
+				if s.config.ResendInterval != 0 {
+					time.Sleep(s.config.ResendInterval)
+				}
+
+				return false, nil
+			}
+
 			//message nacked, try resending
 			logger.Debug("Message nacked, resending", nil)
 			msg = msg.Copy()
@@ -478,12 +706,221 @@ ResendLoop:
 
 		case <-s.closing:
 			logger.Info("Discarding queued message, subscriber closing", nil)
-			return false
+			return false, nil
 
 		case <-ctx.Done():
 			logger.Info("Discarding queued message, context canceled", nil)
-			return false
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				if err := s.notAcked(ctx, topic, row, tx, notAckedReasonAckDeadlineExceeded, logger); err != nil {
+					return false, err
+				}
+			}
+
+			return false, nil
+		}
+	}
+}
+
+// notAckedReason is the reason why a message was not acked, stored by NotAckedMessageQuery.
+// The behavior of the subscriber depends only on the constants, not on their text.
+type notAckedReason string
+
+const (
+	notAckedReasonNacked              notAckedReason = "message nacked"
+	notAckedReasonAckDeadlineExceeded notAckedReason = "ack deadline exceeded"
+	notAckedReasonSubscriberClosing   notAckedReason = "subscriber closing"
+	notAckedReasonContextCanceled     notAckedReason = "context canceled"
+	notAckedReasonLeaseExpired        notAckedReason = "lease expired"
+)
+
+// notAcked executes the NotAckedMessageQuery, if the OffsetsAdapter supports it.
+func (s *Subscriber) notAcked(
+	ctx context.Context,
+	topic string,
+	row Row,
+	db ContextExecutor,
+	reason notAckedReason,
+	logger watermill.LoggerAdapter,
+) error {
+	querier, ok := s.config.OffsetsAdapter.(NotAckedMessageQuerier)
+	if !ok {
+		return nil
+	}
+
+	params := NotAckedMessageQueryParams{
+		Topic:         topic,
+		Row:           row,
+		ConsumerGroup: s.config.ConsumerGroup,
+		Reason:        string(reason),
+	}
+	if s.leasesMessages() {
+		params.ConsumerULID = s.consumerIdBytes
+	}
+
+	notAckedQuery, err := querier.NotAckedMessageQuery(params)
+	if err != nil {
+		return fmt.Errorf("could not get not acked message query: %w", err)
+	}
+	if notAckedQuery.IsZero() {
+		return nil
+	}
+
+	logger.Trace("Executing not acked message query", watermill.LogFields{
+		"query":      notAckedQuery.Query,
+		"query_args": sqlArgsToLog(notAckedQuery.Args),
+	})
+
+	// the message context may be already canceled after the ack deadline, but the transaction is still valid
+	_, err = db.ExecContext(context.WithoutCancel(ctx), notAckedQuery.Query, notAckedQuery.Args...)
+	if err != nil {
+		return fmt.Errorf("cannot send not acked message query: %w", err)
+	}
+
+	return nil
+}
+
+// recordDeliveryAttempt executes the DeliveryAttemptQuery outside the consuming transaction,
+// and updates delivery attempts of the row with the persisted value.
+func (s *Subscriber) recordDeliveryAttempt(
+	ctx context.Context,
+	topic string,
+	row Row,
+	querier DeliveryAttemptQuerier,
+	logger watermill.LoggerAdapter,
+) (Row, error) {
+	attemptQuery, err := querier.DeliveryAttemptQuery(DeliveryAttemptQueryParams{
+		Topic:         topic,
+		Row:           row,
+		ConsumerGroup: s.config.ConsumerGroup,
+	})
+	if err != nil {
+		return Row{}, fmt.Errorf("could not get delivery attempt query: %w", err)
+	}
+	if attemptQuery.IsZero() {
+		return row, nil
+	}
+
+	logger.Trace("Executing delivery attempt query", watermill.LogFields{
+		"query":      attemptQuery.Query,
+		"query_args": sqlArgsToLog(attemptQuery.Args),
+	})
+
+	attemptCtx, attemptSpan := s.tracer.startQuery(
+		ctx,
+		"delivery_attempt",
+		append(s.tracingAttributes(topic), TracingAttributeOffset.Int64(row.Offset))...,
+	)
+	attempts, err := queryDeliveryAttempts(attemptCtx, s.db, attemptQuery)
+	endSpan(attemptSpan, err)
+	if err != nil {
+		return Row{}, fmt.Errorf("could not record delivery attempt: %w", err)
+	}
+
+	previousAttempts := previousDeliveryAttempts(row) + attempts - 1
+
+	if row.ExtraData == nil {
+		row.ExtraData = map[string]any{}
+	}
+	row.ExtraData["delivery_attempts"] = previousAttempts
+
+	if row.Msg.Metadata == nil {
+		row.Msg.Metadata = message.Metadata{}
+	}
+	row.Msg.Metadata.Set(DeliveryAttemptsMetadataKey, strconv.Itoa(previousAttempts+1))
+
+	return row, nil
+}
+
+func queryDeliveryAttempts(ctx context.Context, db ContextExecutor, query Query) (attempts int, err error) {
+	rows, err := db.QueryContext(ctx, query.Query, query.Args...)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
 		}
+	}()
+
+	if !rows.Next() {
+		return 0, errors.New("delivery attempt query returned no rows")
+	}
+
+	if err := rows.Scan(&attempts); err != nil {
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+func (s *Subscriber) leasesMessages() bool {
+	lm, ok := s.config.SchemaAdapter.(LeasesMessages)
+	return ok && lm.LeasesMessages()
+}
+
+// previousDeliveryAttempts returns the number of previous deliveries of the message persisted by the schema adapter,
+// or 0 if the schema adapter doesn't track them.
+func previousDeliveryAttempts(row Row) int {
+	attempts, _ := row.ExtraData["delivery_attempts"].(int)
+	return attempts
+}
+
+func (s *Subscriber) reachedMaxDeliveryAttempts(deliveryAttempts int) bool {
+	return s.config.MaxDeliveryAttempts > 0 && deliveryAttempts >= s.config.MaxDeliveryAttempts
+}
+
+func nackedReason(deliveryAttempts int) string {
+	return fmt.Sprintf("message nacked %d times", deliveryAttempts)
+}
+
+func deliveredWithoutAckReason(deliveryAttempts int) string {
+	return fmt.Sprintf("message delivered %d times without ack", deliveryAttempts)
+}
+
+// deadLetter publishes the message to the dead-letter topic in the consuming transaction.
+func (s *Subscriber) deadLetter(
+	ctx context.Context,
+	topic string,
+	msg *message.Message,
+	tx Tx,
+	reason string,
+	logger watermill.LoggerAdapter,
+) error {
+	deadLetterTopic := s.config.GenerateDeadLetterTopic(topic)
+
+	logger.Info("Message exceeded max delivery attempts, moving to dead-letter topic", watermill.LogFields{
+		"max_delivery_attempts": s.config.MaxDeliveryAttempts,
+		"dead_letter_topic":     deadLetterTopic,
+		"reason":                reason,
+	})
+
+	publisher, err := NewPublisher(tx, PublisherConfig{
+		SchemaAdapter: s.config.SchemaAdapter,
+		Metrics:       s.config.Metrics,
+		Tracing:       s.config.Tracing,
+	}, s.logger)
+	if err != nil {
+		return fmt.Errorf("could not create dead-letter publisher: %w", err)
+	}
+
+	deadLetterMsg := msg.Copy()
+	deadLetterMsg.Metadata.Set(middleware.PoisonedTopicKey, topic)
+	deadLetterMsg.Metadata.Set(middleware.ReasonForPoisonedKey, reason)
+
+	// the message is moved even if the subscriber is closing, as the consuming transaction is committed anyway
+	err = publisher.PublishWithContext(context.WithoutCancel(ctx), deadLetterTopic, deadLetterMsg)
+	if err != nil {
+		return fmt.Errorf("could not publish message to dead-letter topic: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Subscriber) tracingAttributes(topic string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		TracingAttributeTopic.String(topic),
+		TracingAttributeConsumerGroup.String(s.config.ConsumerGroup),
 	}
 }
 
@@ -502,12 +939,35 @@ func (s *Subscriber) SubscribeInitialize(topic string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
 	defer cancel()
 
-	return initializeSchema(
+	err := initializeSchema(
 		ctx,
 		topic,
 		s.logger,
 		s.db,
 		s.config.SchemaAdapter,
 		s.config.OffsetsAdapter,
+		s.config.ConsumerGroup,
+		s.config.SchemaVersions,
 	)
+	if err != nil {
+		return err
+	}
+
+	if s.config.MaxDeliveryAttempts > 0 {
+		err = initializeSchema(
+			ctx,
+			s.config.GenerateDeadLetterTopic(topic),
+			s.logger,
+			s.db,
+			s.config.SchemaAdapter,
+			s.config.OffsetsAdapter,
+			s.config.ConsumerGroup,
+			s.config.SchemaVersions,
+		)
+		if err != nil {
+			return fmt.Errorf("could not initialize dead-letter topic schema: %w", err)
+		}
+	}
+
+	return nil
 }
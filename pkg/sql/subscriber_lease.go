@@ -0,0 +1,373 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+var (
+	errMessageNotAcked = errors.New("message not acked")
+	errLeaseLost       = errors.New("message is not leased by the subscriber anymore")
+)
+
+// queryLeased claims messages with a lease, and processes each of them in a separate transaction.
+// It's used with schema adapters implementing LeasesMessages.
+func (s *Subscriber) queryLeased(
+	ctx context.Context,
+	topic string,
+	out chan *message.Message,
+	logger watermill.LoggerAdapter,
+) (noMsg bool, err error) {
+	selectStart := time.Now()
+	messageRows, err := s.leaseMessages(ctx, topic, logger)
+	if err != nil {
+		s.tracer.recordQuery(ctx, "select", selectStart, err, s.tracingAttributes(topic)...)
+		return false, err
+	}
+
+	if len(messageRows) == 0 {
+		return true, nil
+	}
+
+	ctx, receiveSpan := s.tracer.startReceive(ctx, topic, s.config.ConsumerGroup, len(messageRows), selectStart)
+	defer func() {
+		endSpan(receiveSpan, err)
+	}()
+	s.tracer.recordQuery(
+		ctx,
+		"select",
+		selectStart,
+		nil,
+		append(s.tracingAttributes(topic), TracingAttributeBatchSize.Int(len(messageRows)))...,
+	)
+
+	for i, row := range messageRows {
+		if s.isStopping(ctx) {
+			// releasing the rest of the batch, so other subscribers don't need to wait for the lease to expire
+			for _, notProcessedRow := range messageRows[i:] {
+				if err := s.notAcked(ctx, topic, notProcessedRow, s.db, "", logger); err != nil {
+					return false, fmt.Errorf("could not release message: %w", err)
+				}
+			}
+
+			return false, nil
+		}
+
+		err := s.processLeasedMessage(ctx, topic, row, out, logger)
+		if err != nil {
+			return false, fmt.Errorf("could not process message: %w", err)
+		}
+	}
+
+	return false, nil
+}
+
+// leaseMessages claims messages with SelectQuery and commits the transaction right away.
+func (s *Subscriber) leaseMessages(
+	ctx context.Context,
+	topic string,
+	logger watermill.LoggerAdapter,
+) (messageRows []Row, err error) {
+	txOptions := &sql.TxOptions{
+		Isolation: s.config.SchemaAdapter.SubscribeIsolationLevel(),
+	}
+	tx, err := s.db.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin tx for leasing: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+				logger.Error("could not rollback tx for leasing messages", rollbackErr, watermill.LogFields{
+					"query_err": err,
+				})
+			}
+			return
+		}
+
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("could not commit tx for leasing messages: %w", commitErr)
+		}
+	}()
+
+	selectQuery, err := s.config.SchemaAdapter.SelectQuery(
+		SelectQueryParams{
+			Topic:          topic,
+			ConsumerGroup:  s.config.ConsumerGroup,
+			OffsetsAdapter: s.config.OffsetsAdapter,
+			ConsumerULID:   s.consumerIdBytes,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get select query: %w", err)
+	}
+	logger.Trace("Leasing messages", watermill.LogFields{
+		"query":      selectQuery.Query,
+		"query_args": sqlArgsToLog(selectQuery.Args),
+	})
+
+	selectStart := time.Now()
+	defer func() {
+		s.config.Metrics.QueryDuration(MetricsQuerySelect, topic, time.Since(selectStart), err)
+	}()
+
+	rows, err := tx.QueryContext(ctx, selectQuery.Query, selectQuery.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not lease messages: %w", err)
+	}
+
+	for rows.Next() {
+		row, err := s.config.SchemaAdapter.UnmarshalMessage(UnmarshalMessageParams{
+			Topic: topic,
+			Row:   rows,
+		})
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not unmarshal message from query: %w", err)
+		}
+
+		messageRows = append(messageRows, row)
+	}
+
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %w", err)
+	}
+
+	return messageRows, nil
+}
+
+// processLeasedMessage sends the message and acks it in a new short transaction.
+// When the message is not acked, it's released.
+func (s *Subscriber) processLeasedMessage(
+	ctx context.Context,
+	topic string,
+	row Row,
+	out chan *message.Message,
+	logger watermill.LoggerAdapter,
+) (err error) {
+	logger = logger.With(watermill.LogFields{
+		"msg_uuid": row.Msg.UUID,
+	})
+	logger.Trace("Received message", nil)
+
+	var reason notAckedReason
+
+	ctx, span := s.tracer.startProcess(ctx, topic, s.config.ConsumerGroup, row)
+	defer func() {
+		span.SetAttributes(tracingAttributeAcked.Bool(err == nil && reason == ""))
+		endSpan(span, err)
+	}()
+
+	if s.config.LeasedMessageTx {
+		err = runInTx(ctx, s.db, func(ctx context.Context, tx Tx) error {
+			var err error
+			reason, err = s.deliverLeasedMessage(ctx, topic, row, tx, out, logger)
+			return err
+		})
+	} else {
+		reason, err = s.deliverLeasedMessage(ctx, topic, row, nil, out, logger)
+	}
+
+	if errors.Is(err, errLeaseLost) {
+		// the message was claimed by another subscriber after the lease expired, so it's not released
+		logger.Error("Message lease expired before it was acked, it may be delivered again", err, watermill.LogFields{
+			"lease_tx": s.config.LeasedMessageTx,
+		})
+		reason = notAckedReasonLeaseExpired
+		return nil
+	}
+	if !errors.Is(err, errMessageNotAcked) {
+		return err
+	}
+
+	logger.Debug("Message not acked, releasing", watermill.LogFields{
+		"reason": reason,
+	})
+
+	if err := s.notAcked(ctx, topic, row, s.db, reason, logger); err != nil {
+		return fmt.Errorf("could not release message: %w", err)
+	}
+
+	if reason == notAckedReasonNacked && s.config.ResendInterval != 0 {
+		time.Sleep(s.config.ResendInterval)
+	}
+
+	return nil
+}
+
+// deliverLeasedMessage sends the message, and acks it (or moves it to the dead-letter topic).
+//
+// When tx is nil, the message is delivered without a transaction, and acked in a new short transaction.
+// Otherwise, tx is available to the handler with TxFromContext, and the message is acked in it.
+func (s *Subscriber) deliverLeasedMessage(
+	ctx context.Context,
+	topic string,
+	row Row,
+	tx Tx,
+	out chan *message.Message,
+	logger watermill.LoggerAdapter,
+) (reason notAckedReason, err error) {
+	msgCtx := ctx
+	if *s.config.AckDeadline != 0 {
+		var cancel context.CancelFunc
+		msgCtx, cancel = context.WithTimeout(ctx, *s.config.AckDeadline)
+		defer cancel()
+	}
+	if tx != nil {
+		msgCtx = setTxToContext(msgCtx, tx)
+	}
+
+	// the lease query counts the current delivery, so previous deliveries were not acked
+	previousAttempts := previousDeliveryAttempts(row)
+
+	var deadLetterReason string
+	if s.reachedMaxDeliveryAttempts(previousAttempts) {
+		deadLetterReason = deliveredWithoutAckReason(previousAttempts)
+	} else if !row.Skipped {
+		var acked bool
+		acked, reason = s.sendLeasedMessage(msgCtx, topic, row.Msg, out, logger)
+		if !acked {
+			if reason != notAckedReasonNacked || !s.reachedMaxDeliveryAttempts(previousAttempts+1) {
+				return reason, errMessageNotAcked
+			}
+
+			deadLetterReason = nackedReason(previousAttempts + 1)
+		}
+	}
+
+	ack := func(ctx context.Context, tx Tx) error {
+		if deadLetterReason != "" {
+			if err := s.deadLetter(ctx, topic, row.Msg, tx, deadLetterReason, logger); err != nil {
+				return err
+			}
+		}
+
+		return s.ackLeasedMessage(ctx, topic, row, tx, logger)
+	}
+
+	if tx != nil {
+		return "", ack(ctx, tx)
+	}
+
+	return "", runInTx(ctx, s.db, ack)
+}
+
+// ackLeasedMessage acks the message, if it's still leased by the subscriber.
+func (s *Subscriber) ackLeasedMessage(
+	ctx context.Context,
+	topic string,
+	row Row,
+	tx Tx,
+	logger watermill.LoggerAdapter,
+) error {
+	ackQuery, err := s.config.OffsetsAdapter.AckMessageQuery(
+		AckMessageQueryParams{
+			Topic:         topic,
+			LastRow:       row,
+			Rows:          []Row{row},
+			ConsumerGroup: s.config.ConsumerGroup,
+			ConsumerULID:  s.consumerIdBytes,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("could not get ack message query: %w", err)
+	}
+
+	logger.Trace("Executing ack message query", watermill.LogFields{
+		"query":      ackQuery.Query,
+		"query_args": sqlArgsToLog(ackQuery.Args),
+	})
+
+	ackCtx, ackSpan := s.tracer.startQuery(
+		ctx,
+		"ack",
+		append(s.tracingAttributes(topic), TracingAttributeOffset.Int64(row.Offset))...,
+	)
+	ackStart := time.Now()
+	result, err := tx.ExecContext(ackCtx, ackQuery.Query, ackQuery.Args...)
+	s.config.Metrics.QueryDuration(MetricsQueryAck, topic, time.Since(ackStart), err)
+	endSpan(ackSpan, err)
+	if err != nil {
+		return fmt.Errorf("could not ack message: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected by ack: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errLeaseLost
+	}
+
+	return nil
+}
+
+// sendLeasedMessage sends the message on the output channel once.
+// Nacked messages are not resent, they are released and claimed again instead.
+func (s *Subscriber) sendLeasedMessage(
+	ctx context.Context,
+	topic string,
+	msg *message.Message,
+	out chan *message.Message,
+	logger watermill.LoggerAdapter,
+) (acked bool, reason notAckedReason) {
+	msgCtx, cancel := context.WithCancel(ctx)
+	msg.SetContext(msgCtx)
+	defer cancel()
+
+	select {
+	case out <- msg:
+		s.config.Metrics.MessageConsumed(topic, s.config.ConsumerGroup)
+
+	case <-s.closing:
+		logger.Info("Discarding queued message, subscriber closing", nil)
+		return false, ""
+
+	case <-ctx.Done():
+		logger.Info("Discarding queued message, context canceled", nil)
+		return false, ""
+	}
+
+	select {
+	case <-msg.Acked():
+		logger.Debug("Message acked by subscriber", nil)
+		s.config.Metrics.MessageAcked(topic, s.config.ConsumerGroup)
+		return true, ""
+
+	case <-msg.Nacked():
+		logger.Debug("Message nacked", nil)
+		s.config.Metrics.MessageNacked(topic, s.config.ConsumerGroup)
+		return false, notAckedReasonNacked
+
+	case <-s.closing:
+		logger.Info("Discarding queued message, subscriber closing", nil)
+		return false, notAckedReasonSubscriberClosing
+
+	case <-ctx.Done():
+		logger.Info("Discarding queued message, context canceled", nil)
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return false, notAckedReasonAckDeadlineExceeded
+		}
+
+		return false, notAckedReasonContextCanceled
+	}
+}
+
+func (s *Subscriber) isStopping(ctx context.Context) bool {
+	select {
+	case <-s.closing:
+		return true
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
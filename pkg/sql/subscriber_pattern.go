@@ -0,0 +1,273 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// TopicsLister may be implemented by SchemaAdapter to support Subscriber.SubscribePattern.
+type TopicsLister interface {
+	// MessagesTablesQuery returns the SQL query and arguments listing the messages tables of the adapter.
+	// Each row contains a single table name, without quotes.
+	MessagesTablesQuery() (Query, error)
+
+	// TopicFromMessagesTable returns the topic of the table returned by MessagesTablesQuery,
+	// or false if the table doesn't belong to any topic.
+	TopicFromMessagesTable(table string) (string, bool)
+}
+
+// topicFromMessagesTable returns the topic of the table named with the default "<prefix><topic>" convention,
+// or with parse, if it's set.
+func topicFromMessagesTable(parse func(table string) (string, bool), prefix string, table string) (string, bool) {
+	if parse != nil {
+		return parse(table)
+	}
+
+	topic, ok := strings.CutPrefix(table, prefix)
+	if !ok || topic == "" || validateTopicName(topic) != nil {
+		return "", false
+	}
+
+	return topic, true
+}
+
+func validateMessagesTableNameParser(generate func(topic string) string, parse func(table string) (string, bool)) error {
+	if generate != nil && parse == nil {
+		return errors.New("ParseMessagesTableName is required when GenerateMessagesTableName is set")
+	}
+
+	return nil
+}
+
+// topicPattern is a subject-style pattern of topics. Tokens of the topic are separated by dots.
+// The "*" token matches exactly one token, and the ">" token, allowed only at the end of the pattern,
+// matches one or more remaining tokens. For example, "orders.*" matches "orders.placed", but not "orders.placed.eu",
+// and "orders.>" matches both.
+type topicPattern []string
+
+func parseTopicPattern(pattern string) (topicPattern, error) {
+	if pattern == "" {
+		return nil, errors.New("topic pattern is empty")
+	}
+
+	tokens := strings.Split(pattern, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return nil, fmt.Errorf("topic pattern %s contains an empty token", pattern)
+		case token == ">" && i != len(tokens)-1:
+			return nil, fmt.Errorf("topic pattern %s: > is allowed only as the last token", pattern)
+		case token == "*" || token == ">":
+		default:
+			if err := validateTopicName(token); err != nil {
+				return nil, fmt.Errorf("invalid topic pattern: %w", err)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p topicPattern) match(topic string) bool {
+	tokens := strings.Split(topic, ".")
+
+	for i, token := range p {
+		if token == ">" {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) {
+			return false
+		}
+		if token != "*" && token != tokens[i] {
+			return false
+		}
+	}
+
+	return len(tokens) == len(p)
+}
+
+// SubscribePattern subscribes to all topics matching the subject-style pattern, like "orders.*" or "orders.>".
+// Tokens of topics are separated by dots: "*" matches exactly one token, and ">" at the end of the pattern
+// matches one or more remaining tokens.
+//
+// The pattern is resolved against the existing messages tables, listed by the SchemaAdapter (see TopicsLister).
+// They are re-scanned every TopicsRescanInterval, so topics created later are subscribed as well.
+// Dead-letter topics of the listed topics (see GenerateDeadLetterTopic) are skipped.
+//
+// Messages of all topics are delivered to the single channel. Each topic is consumed like with Subscribe,
+// so offsets are tracked per topic, and the order of messages is preserved only within a topic.
+// The topic of the received message is available with TopicFromContext.
+func (s *Subscriber) SubscribePattern(ctx context.Context, pattern string) (<-chan *message.Message, error) {
+	if atomic.LoadUint32(&s.closed) == 1 {
+		return nil, ErrSubscriberClosed
+	}
+
+	lister, ok := s.config.SchemaAdapter.(TopicsLister)
+	if !ok {
+		return nil, fmt.Errorf("schema adapter %T doesn't support listing topics", s.config.SchemaAdapter)
+	}
+
+	p, err := parseTopicPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := s.logger.With(watermill.LogFields{
+		"topic_pattern":  pattern,
+		"consumer_group": s.config.ConsumerGroup,
+	})
+
+	topics, err := s.matchingTopics(ctx, lister, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// the information about closing the subscriber is propagated through ctx
+	ctx, cancel := context.WithCancel(ctx)
+	out := make(chan *message.Message)
+
+	forwardWg := &sync.WaitGroup{}
+	subscribed := map[string]struct{}{}
+
+	subscribe := func(topics []string) error {
+		for _, topic := range topics {
+			if _, ok := subscribed[topic]; ok {
+				continue
+			}
+
+			messages, err := s.Subscribe(ctx, topic)
+			if err != nil {
+				return fmt.Errorf("cannot subscribe to topic %s: %w", topic, err)
+			}
+			subscribed[topic] = struct{}{}
+
+			logger.Info("Subscribed to topic matching pattern", watermill.LogFields{"topic": topic})
+
+			forwardWg.Add(1)
+			go func(topic string) {
+				defer forwardWg.Done()
+				forwardMessages(ctx, topic, messages, out)
+			}(topic)
+		}
+
+		return nil
+	}
+
+	if err := subscribe(topics); err != nil {
+		cancel()
+		forwardWg.Wait()
+		return nil, err
+	}
+
+	s.subscribeWg.Add(1)
+	go func() {
+		defer s.subscribeWg.Done()
+
+		s.rescanTopics(ctx, lister, p, subscribe, logger)
+
+		cancel()
+		forwardWg.Wait()
+		close(out)
+	}()
+
+	return out, nil
+}
+
+func (s *Subscriber) rescanTopics(
+	ctx context.Context,
+	lister TopicsLister,
+	p topicPattern,
+	subscribe func(topics []string) error,
+	logger watermill.LoggerAdapter,
+) {
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(s.config.TopicsRescanInterval):
+		}
+
+		topics, err := s.matchingTopics(ctx, lister, p)
+		if err == nil {
+			err = subscribe(topics)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("Could not subscribe to topics matching pattern", err, nil)
+		}
+	}
+}
+
+// matchingTopics returns the topics of the existing messages tables which match the pattern,
+// skipping dead-letter topics of other listed topics.
+func (s *Subscriber) matchingTopics(ctx context.Context, lister TopicsLister, p topicPattern) ([]string, error) {
+	query, err := lister.MessagesTablesQuery()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get messages tables query: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query.Query, query.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list messages tables: %w", err)
+	}
+	defer rows.Close()
+
+	topics := map[string]struct{}{}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("cannot scan messages table: %w", err)
+		}
+
+		if topic, ok := lister.TopicFromMessagesTable(table); ok {
+			topics[topic] = struct{}{}
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("cannot list messages tables: %w", err)
+	}
+
+	deadLetterTopics := map[string]struct{}{}
+	for topic := range topics {
+		deadLetterTopics[s.config.GenerateDeadLetterTopic(topic)] = struct{}{}
+	}
+
+	var matching []string
+	for topic := range topics {
+		if _, ok := deadLetterTopics[topic]; ok {
+			continue
+		}
+		if p.match(topic) {
+			matching = append(matching, topic)
+		}
+	}
+	sort.Strings(matching)
+
+	return matching, nil
+}
+
+func forwardMessages(ctx context.Context, topic string, in <-chan *message.Message, out chan<- *message.Message) {
+	for msg := range in {
+		msg.SetContext(setTopicToContext(msg.Context(), topic))
+
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+			// the message is not acked, so it's nacked by the subscription of the topic
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package sql_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func TestSubscribePattern_SQLite(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	prefix := "pattern_" + watermill.NewShortUUID()
+
+	schemaAdapter := newSQLiteSchemaAdapter(1)
+	schemaAdapter.ParseMessagesTableName = func(table string) (string, bool) {
+		return strings.CutPrefix(table, "test_")
+	}
+
+	publisher, err := sql.NewPublisher(db, sql.PublisherConfig{SchemaAdapter: schemaAdapter}, logger)
+	require.NoError(t, err)
+
+	subscriber, err := sql.NewSubscriber(
+		db,
+		sql.SubscriberConfig{
+			ConsumerGroup:        "pattern",
+			PollInterval:         time.Millisecond,
+			TopicsRescanInterval: 10 * time.Millisecond,
+			SchemaAdapter:        schemaAdapter,
+			OffsetsAdapter:       newSQLiteOffsetsAdapter(),
+		},
+		logger,
+	)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, subscriber.Close())
+	}()
+
+	publish := func(topic string) string {
+		require.NoError(t, subscriber.SubscribeInitialize(topic))
+
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		require.NoError(t, publisher.Publish(topic, msg))
+
+		return msg.UUID
+	}
+
+	expected := map[string]string{
+		publish(prefix + ".orders.placed"): prefix + ".orders.placed",
+		publish(prefix + ".orders.paid"):   prefix + ".orders.paid",
+	}
+	publish(prefix + ".orders.placed.eu")
+	publish(prefix + ".payments.received")
+	publish(prefix + ".orders.placed_dead_letter")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := subscriber.SubscribePattern(ctx, prefix+".orders.*")
+	require.NoError(t, err)
+
+	// topics created after subscribing are discovered by the rescan
+	expected[publish(prefix+".orders.shipped")] = prefix + ".orders.shipped"
+
+	received := map[string]string{}
+	for range expected {
+		select {
+		case msg := <-messages:
+			topic, ok := sql.TopicFromContext(msg.Context())
+			require.True(t, ok)
+			received[msg.UUID] = topic
+			msg.Ack()
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	assert.Equal(t, expected, received)
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("message %s of not matching topic received", msg.UUID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-messages:
+		assert.False(t, ok, "channel should be closed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestSubscribePattern_invalid(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name          string
+		Pattern       string
+		SchemaAdapter sql.SchemaAdapter
+	}{
+		{
+			Name:          "empty_pattern",
+			Pattern:       "",
+			SchemaAdapter: sql.DefaultSQLiteSchema{},
+		},
+		{
+			Name:          "empty_token",
+			Pattern:       "orders..*",
+			SchemaAdapter: sql.DefaultSQLiteSchema{},
+		},
+		{
+			Name:          "not_last_tail_wildcard",
+			Pattern:       "orders.>.eu",
+			SchemaAdapter: sql.DefaultSQLiteSchema{},
+		},
+		{
+			Name:          "invalid_token",
+			Pattern:       "orders.pla*",
+			SchemaAdapter: sql.DefaultSQLiteSchema{},
+		},
+		{
+			Name:          "missing_table_name_parser",
+			Pattern:       "orders.*",
+			SchemaAdapter: newSQLiteSchemaAdapter(1),
+		},
+		{
+			Name:    "not_supported_schema_adapter",
+			Pattern: "orders.*",
+			// only methods of SchemaAdapter are promoted, so it doesn't implement TopicsLister
+			SchemaAdapter: struct{ sql.SchemaAdapter }{sql.DefaultSQLiteSchema{}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			subscriber, err := sql.NewSubscriber(
+				newSQLite(t),
+				sql.SubscriberConfig{
+					SchemaAdapter:  tc.SchemaAdapter,
+					OffsetsAdapter: sql.DefaultSQLiteOffsetsAdapter{},
+				},
+				logger,
+			)
+			require.NoError(t, err)
+
+			_, err = subscriber.SubscribePattern(context.Background(), tc.Pattern)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package sql_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+)
+
+func TestSubscriber_MaxDeliveryAttempts(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name           string
+		DbConstructor  func(t *testing.T) sql.Beginner
+		SchemaAdapter  sql.SchemaAdapter
+		OffsetsAdapter sql.OffsetsAdapter
+	}{
+		{
+			Name:           "sqlite",
+			DbConstructor:  newSQLite,
+			SchemaAdapter:  newSQLiteSchemaAdapter(0),
+			OffsetsAdapter: newSQLiteOffsetsAdapter(),
+		},
+		{
+			Name:           "postgresql",
+			DbConstructor:  newPostgreSQL,
+			SchemaAdapter:  newPostgresSchemaAdapter(0),
+			OffsetsAdapter: newPostgresOffsetsAdapter(),
+		},
+		{
+			Name:           "mysql",
+			DbConstructor:  newMySQL,
+			SchemaAdapter:  newMySQLSchemaAdapter(0),
+			OffsetsAdapter: newMySQLOffsetsAdapter(),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			db := tc.DbConstructor(t)
+
+			pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+				SchemaAdapter: tc.SchemaAdapter,
+			}, logger)
+			require.NoError(t, err)
+
+			sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+				ConsumerGroup:       "test",
+				PollInterval:        time.Millisecond,
+				ResendInterval:      time.Millisecond,
+				MaxDeliveryAttempts: 3,
+				SchemaAdapter:       tc.SchemaAdapter,
+				OffsetsAdapter:      tc.OffsetsAdapter,
+				InitializeSchema:    true,
+			}, logger)
+			require.NoError(t, err)
+
+			topic := "topic_" + watermill.NewUUID()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			messages, err := sub.Subscribe(ctx, topic)
+			require.NoError(t, err)
+
+			poisonMsg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+			nextMsg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+			require.NoError(t, pub.Publish(topic, poisonMsg, nextMsg))
+
+			for i := 0; i < 3; i++ {
+				select {
+				case msg := <-messages:
+					require.Equal(t, poisonMsg.UUID, msg.UUID)
+					msg.Nack()
+				case <-time.After(time.Second * 10):
+					t.Fatal("poison message not redelivered")
+				}
+			}
+
+			select {
+			case msg := <-messages:
+				require.Equal(t, nextMsg.UUID, msg.UUID, "poison message should be moved to the dead-letter topic")
+				msg.Ack()
+			case <-time.After(time.Second * 10):
+				t.Fatal("next message not received")
+			}
+
+			deadLetterSub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+				ConsumerGroup:  "test",
+				PollInterval:   time.Millisecond,
+				SchemaAdapter:  tc.SchemaAdapter,
+				OffsetsAdapter: tc.OffsetsAdapter,
+			}, logger)
+			require.NoError(t, err)
+
+			deadLetterMessages, err := deadLetterSub.Subscribe(ctx, topic+"_dead_letter")
+			require.NoError(t, err)
+
+			select {
+			case msg := <-deadLetterMessages:
+				assert.Equal(t, poisonMsg.UUID, msg.UUID)
+				assert.Equal(t, poisonMsg.Payload, msg.Payload)
+				assert.Equal(t, topic, msg.Metadata.Get(middleware.PoisonedTopicKey))
+				assert.NotEmpty(t, msg.Metadata.Get(middleware.ReasonForPoisonedKey))
+				msg.Ack()
+			case <-time.After(time.Second * 10):
+				t.Fatal("message not received from the dead-letter topic")
+			}
+		})
+	}
+}
+
+func TestNewSubscriber_incompatible_adapters(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name           string
+		ConsumerGroup  string
+		SchemaAdapter  sql.SchemaAdapter
+		OffsetsAdapter sql.OffsetsAdapter
+		ExpectedError  string
+	}{
+		{
+			Name:           "queue_schema_with_default_offsets_adapter",
+			SchemaAdapter:  sql.PostgreSQLQueueSchema{},
+			OffsetsAdapter: sql.DefaultPostgreSQLOffsetsAdapter{},
+			ExpectedError:  "schema adapter sql.PostgreSQLQueueSchema acks each message separately",
+		},
+		{
+			Name:           "default_schema_with_queue_offsets_adapter",
+			SchemaAdapter:  sql.DefaultPostgreSQLSchema{},
+			OffsetsAdapter: sql.PostgreSQLQueueOffsetsAdapter{},
+			ExpectedError:  "offsets adapter sql.PostgreSQLQueueOffsetsAdapter acks each message separately",
+		},
+		{
+			Name:           "consumer_group_on_queue",
+			ConsumerGroup:  "test",
+			SchemaAdapter:  sql.PostgreSQLQueueSchema{},
+			OffsetsAdapter: sql.PostgreSQLQueueOffsetsAdapter{},
+			ExpectedError:  "sql.PostgreSQLQueueSchema doesn't support consumer groups",
+		},
+		{
+			Name:           "queue_with_consumer_groups_without_consumer_group",
+			SchemaAdapter:  sql.PostgreSQLQueueSchema{ConsumerGroups: true},
+			OffsetsAdapter: sql.PostgreSQLQueueOffsetsAdapter{},
+			ExpectedError:  "sql.PostgreSQLQueueSchema requires a consumer group",
+		},
+		{
+			Name:           "mysql_schema_with_postgresql_offsets_adapter",
+			SchemaAdapter:  sql.DefaultMySQLSchema{},
+			OffsetsAdapter: sql.DefaultPostgreSQLOffsetsAdapter{},
+			ExpectedError:  "schema adapter sql.DefaultMySQLSchema uses mysql dialect, but offsets adapter sql.DefaultPostgreSQLOffsetsAdapter uses postgresql dialect",
+		},
+		{
+			Name:           "pointer_adapters",
+			SchemaAdapter:  &sql.DefaultSQLiteSchema{},
+			OffsetsAdapter: &sql.DefaultMySQLOffsetsAdapter{},
+			ExpectedError:  "uses sqlite dialect, but offsets adapter *sql.DefaultMySQLOffsetsAdapter uses mysql dialect",
+		},
+		{
+			Name:           "queue_with_consumer_groups",
+			ConsumerGroup:  "test",
+			SchemaAdapter:  sql.PostgreSQLQueueSchema{ConsumerGroups: true},
+			OffsetsAdapter: sql.PostgreSQLQueueOffsetsAdapter{},
+		},
+		{
+			Name:           "default_postgresql",
+			SchemaAdapter:  sql.DefaultPostgreSQLSchema{},
+			OffsetsAdapter: sql.DefaultPostgreSQLOffsetsAdapter{},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := sql.NewSubscriber(newSQLite(t), sql.SubscriberConfig{
+				ConsumerGroup:  tc.ConsumerGroup,
+				SchemaAdapter:  tc.SchemaAdapter,
+				OffsetsAdapter: tc.OffsetsAdapter,
+			}, logger)
+
+			if tc.ExpectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestSubscriber_MaxDeliveryAttempts_persisted(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name          string
+		LeaseDuration time.Duration
+	}{
+		{Name: "select"},
+		{Name: "lease", LeaseDuration: time.Minute},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newPostgreSQL(t)
+
+			schemaAdapter := sql.PostgreSQLQueueSchema{
+				GeneratePayloadType: func(topic string) string {
+					return "BYTEA"
+				},
+				GenerateMessagesTableName: func(topic string) string {
+					return fmt.Sprintf(`"test_persisted_%s"`, topic)
+				},
+				LeaseDuration: tc.LeaseDuration,
+			}
+			offsetsAdapter := sql.PostgreSQLQueueOffsetsAdapter{
+				GenerateMessagesTableName: schemaAdapter.GenerateMessagesTableName,
+			}
+
+			topic := "topic_" + watermill.NewShortUUID()
+
+			pub, err := sql.NewPublisher(db, sql.PublisherConfig{
+				SchemaAdapter:        schemaAdapter,
+				AutoInitializeSchema: true,
+			}, logger)
+			require.NoError(t, err)
+
+			poisonMsg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+			nextMsg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+			require.NoError(t, pub.Publish(topic, poisonMsg, nextMsg))
+			require.NoError(t, pub.Publish(topic+"_dead_letter", message.NewMessage(watermill.NewUUID(), []byte("{}"))))
+
+			// deliveries of the poison message which crashed the subscriber before it could record them as nacked
+			_, err = db.ExecContext(
+				context.Background(),
+				`UPDATE `+schemaAdapter.MessagesTable(topic)+` SET delivery_attempts = 3 WHERE uuid = $1`,
+				poisonMsg.UUID,
+			)
+			require.NoError(t, err)
+
+			sub, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+				PollInterval:        time.Millisecond,
+				ResendInterval:      time.Millisecond,
+				MaxDeliveryAttempts: 3,
+				SchemaAdapter:       schemaAdapter,
+				OffsetsAdapter:      offsetsAdapter,
+			}, logger)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			messages, err := sub.Subscribe(ctx, topic)
+			require.NoError(t, err)
+
+			select {
+			case msg := <-messages:
+				require.Equal(t, nextMsg.UUID, msg.UUID, "poison message should not be delivered again")
+				msg.Ack()
+			case <-time.After(time.Second * 10):
+				t.Fatal("next message not received")
+			}
+
+			deadLetterMessages, err := sub.Subscribe(ctx, topic+"_dead_letter")
+			require.NoError(t, err)
+
+			received := map[string]*message.Message{}
+			for len(received) < 2 {
+				select {
+				case msg := <-deadLetterMessages:
+					received[msg.UUID] = msg
+					msg.Ack()
+				case <-time.After(time.Second * 10):
+					t.Fatal("message not received from the dead-letter topic")
+				}
+			}
+
+			require.Contains(t, received, poisonMsg.UUID)
+			assert.Equal(t, topic, received[poisonMsg.UUID].Metadata.Get(middleware.PoisonedTopicKey))
+			assert.Equal(
+				t,
+				"message delivered 3 times without ack",
+				received[poisonMsg.UUID].Metadata.Get(middleware.ReasonForPoisonedKey),
+			)
+		})
+	}
+}
@@ -19,3 +19,34 @@ func validateTopicName(topic string) error {
 
 	return nil
 }
+
+// TopicNameValidator may be implemented by SchemaAdapter and OffsetsAdapter which don't use topics
+// in SQL identifiers (like PostgreSQLSharedTableSchema), to allow topic names rejected by the default validation.
+type TopicNameValidator interface {
+	ValidateTopicName(topic string) error
+}
+
+// validateTopic checks the topic name with all adapters. Adapters which don't implement TopicNameValidator
+// may use the topic in SQL identifiers, so the topic is checked with validateTopicName for them.
+// Nil adapters are skipped.
+func validateTopic(topic string, adapters ...any) error {
+	for _, adapter := range adapters {
+		if adapter == nil {
+			continue
+		}
+
+		validator, ok := adapter.(TopicNameValidator)
+		if !ok {
+			if err := validateTopicName(topic); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := validator.ValidateTopicName(topic); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
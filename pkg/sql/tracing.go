@@ -0,0 +1,193 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+const tracerName = "github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+
+// Attributes of spans created with TracingConfig.
+const (
+	TracingAttributeTopic         = attribute.Key("messaging.destination.name")
+	TracingAttributeConsumerGroup = attribute.Key("messaging.consumer.group.name")
+	TracingAttributeBatchSize     = attribute.Key("messaging.batch.message_count")
+	TracingAttributeMessageUUID   = attribute.Key("messaging.message.id")
+	TracingAttributeOffset        = attribute.Key("watermill_sql.offset")
+
+	tracingAttributeAcked = attribute.Key("watermill_sql.acked")
+)
+
+// TracingConfig configures OpenTelemetry tracing of Publisher and Subscriber.
+//
+// Publisher creates a "publish <topic>" span, and injects its context into the metadata of published messages.
+// With PublisherConfig.Batching, messages of multiple calls are written in a "publish batch <topic>" span,
+// which is linked to their "publish" spans.
+//
+// Subscriber creates a "receive <topic>" span for each batch of selected messages, with "select" and "ack" child spans.
+// For each delivered message, it creates a "process <topic>" span, which is a child of the context extracted
+// from the message metadata, and is linked to the "receive" span. The message context contains this span,
+// so spans of the handler are its children. The "consumed" query span is a child of the "process" span.
+type TracingConfig struct {
+	// TracerProvider enables tracing, for example otel.GetTracerProvider().
+	// If nil, no spans are created.
+	TracerProvider trace.TracerProvider
+
+	// Propagator injects and extracts the trace context to and from the message metadata.
+	// Defaults to propagation.TraceContext.
+	Propagator propagation.TextMapPropagator
+}
+
+func (c TracingConfig) enabled() bool {
+	return c.TracerProvider != nil
+}
+
+func (c *TracingConfig) setDefaults() {
+	if c.Propagator == nil {
+		c.Propagator = propagation.TraceContext{}
+	}
+}
+
+// tracer creates spans configured with TracingConfig. When tracing is disabled, it creates no-op spans.
+type tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newTracer(config TracingConfig) tracer {
+	if !config.enabled() {
+		return tracer{tracer: noop.NewTracerProvider().Tracer(tracerName)}
+	}
+
+	return tracer{
+		tracer:     config.TracerProvider.Tracer(tracerName),
+		propagator: config.Propagator,
+	}
+}
+
+func (t tracer) startPublish(ctx context.Context, topic string, messages message.Messages) (context.Context, trace.Span) {
+	ctx, span := t.tracer.Start(
+		ctx,
+		"publish "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			TracingAttributeTopic.String(topic),
+			TracingAttributeBatchSize.Int(len(messages)),
+		),
+	)
+
+	if t.propagator != nil {
+		for _, msg := range messages {
+			if msg.Metadata == nil {
+				msg.Metadata = message.Metadata{}
+			}
+			t.propagator.Inject(ctx, metadataCarrier(msg.Metadata))
+		}
+	}
+
+	return ctx, span
+}
+
+// startPublishBatch starts the root span of writing a batch of messages, linked to the spans of publishing them.
+func (t tracer) startPublishBatch(topic string, messagesCount int, links []trace.Link) (context.Context, trace.Span) {
+	return t.tracer.Start(
+		context.Background(),
+		"publish batch "+topic,
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			TracingAttributeTopic.String(topic),
+			TracingAttributeBatchSize.Int(messagesCount),
+		),
+	)
+}
+
+// startReceive starts the span of a batch of messages, at the time when the select query started.
+func (t tracer) startReceive(ctx context.Context, topic string, consumerGroup string, batchSize int, start time.Time) (context.Context, trace.Span) {
+	return t.tracer.Start(
+		ctx,
+		"receive "+topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			TracingAttributeTopic.String(topic),
+			TracingAttributeConsumerGroup.String(consumerGroup),
+			TracingAttributeBatchSize.Int(batchSize),
+		),
+	)
+}
+
+// startProcess starts the span of a delivered message, with the parent extracted from the message metadata.
+func (t tracer) startProcess(ctx context.Context, topic string, consumerGroup string, row Row) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			TracingAttributeTopic.String(topic),
+			TracingAttributeConsumerGroup.String(consumerGroup),
+			TracingAttributeMessageUUID.String(row.Msg.UUID),
+			TracingAttributeOffset.Int64(row.Offset),
+		),
+	}
+
+	if t.propagator != nil {
+		opts = append(opts, trace.WithLinks(trace.LinkFromContext(ctx)))
+		ctx = t.propagator.Extract(ctx, metadataCarrier(row.Msg.Metadata))
+	}
+
+	return t.tracer.Start(ctx, "process "+topic, opts...)
+}
+
+// startQuery starts the span of a query, executed in the context of a receive or process span.
+func (t tracer) startQuery(ctx context.Context, query string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, query, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+// recordQuery records the span of a query which already finished.
+func (t tracer) recordQuery(ctx context.Context, query string, start time.Time, err error, attributes ...attribute.KeyValue) {
+	_, span := t.tracer.Start(
+		ctx,
+		query,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attributes...),
+	)
+	endSpan(span, err)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// metadataCarrier adapts message.Metadata to propagation.TextMapCarrier.
+type metadataCarrier message.Metadata
+
+func (c metadataCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
@@ -0,0 +1,176 @@
+package sql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/v4/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "tracing_" + watermill.NewShortUUID()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracing := sql.TracingConfig{
+		TracerProvider: tracerProvider,
+	}
+
+	publisher, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        newSQLiteSchemaAdapter(1),
+		AutoInitializeSchema: true,
+		Tracing:              tracing,
+	}, logger)
+	require.NoError(t, err)
+
+	subscriber, err := sql.NewSubscriber(db, sql.SubscriberConfig{
+		ConsumerGroup:    "tracing",
+		PollInterval:     10 * time.Millisecond,
+		SchemaAdapter:    newSQLiteSchemaAdapter(1),
+		OffsetsAdapter:   newSQLiteOffsetsAdapter(),
+		InitializeSchema: true,
+		Tracing:          tracing,
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, parentSpan := tracerProvider.Tracer("test").Start(context.Background(), "parent")
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	msg.SetContext(ctx)
+	require.NoError(t, publisher.Publish(topic, msg))
+	parentSpan.End()
+
+	assert.NotEmpty(t, msg.Metadata.Get("traceparent"), "trace context should be injected into metadata")
+
+	subscribeCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := subscriber.Subscribe(subscribeCtx, topic)
+	require.NoError(t, err)
+
+	received := <-messages
+	assert.Equal(t, parentSpan.SpanContext().TraceID(), trace.SpanContextFromContext(received.Context()).TraceID())
+	received.Ack()
+
+	var spans map[string]tracetest.SpanStub
+	require.Eventually(t, func() bool {
+		spans = spansByName(exporter.GetSpans())
+		_, processed := spans["process "+topic]
+		_, consumed := spans["consumed"]
+		_, ack := spans["ack"]
+		return processed && consumed && ack
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, subscriber.Close())
+	spans = spansByName(exporter.GetSpans())
+
+	publishSpan, ok := spans["publish "+topic]
+	require.True(t, ok)
+	assert.Equal(t, parentSpan.SpanContext().SpanID(), publishSpan.Parent.SpanID())
+
+	processSpan := spans["process "+topic]
+	assert.Equal(t, publishSpan.SpanContext.TraceID(), processSpan.SpanContext.TraceID())
+	assert.Equal(t, publishSpan.SpanContext.SpanID(), processSpan.Parent.SpanID())
+	assert.Contains(t, processSpan.Attributes, sql.TracingAttributeMessageUUID.String(msg.UUID))
+	assert.Contains(t, processSpan.Attributes, sql.TracingAttributeConsumerGroup.String("tracing"))
+
+	receiveSpan, ok := spans["receive "+topic]
+	require.True(t, ok)
+	assert.Contains(t, receiveSpan.Attributes, sql.TracingAttributeBatchSize.Int(1))
+	require.Len(t, processSpan.Links, 1)
+	assert.Equal(t, receiveSpan.SpanContext.SpanID(), processSpan.Links[0].SpanContext.SpanID())
+
+	selectSpan, ok := spans["select"]
+	require.True(t, ok)
+	assert.Equal(t, receiveSpan.SpanContext.SpanID(), selectSpan.Parent.SpanID())
+	assert.Contains(t, selectSpan.Attributes, sql.TracingAttributeTopic.String(topic))
+
+	assert.Equal(t, processSpan.SpanContext.SpanID(), spans["consumed"].Parent.SpanID())
+	assert.Equal(t, receiveSpan.SpanContext.SpanID(), spans["ack"].Parent.SpanID())
+	assert.Contains(t, spans["ack"].Attributes, sql.TracingAttributeOffset.Int64(1))
+}
+
+func TestTracing_batching(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "tracing_batching_" + watermill.NewShortUUID()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	publisher, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        newSQLiteSchemaAdapter(1),
+		AutoInitializeSchema: true,
+		Batching: sql.PublisherBatchingConfig{
+			MaxBatchSize: 10,
+		},
+		Tracing: sql.TracingConfig{
+			TracerProvider: tracerProvider,
+		},
+	}, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, publisher.Publish(topic, message.NewMessage(watermill.NewUUID(), []byte("payload"))))
+	require.NoError(t, publisher.Close())
+
+	spans := spansByName(exporter.GetSpans())
+
+	publishSpan, ok := spans["publish "+topic]
+	require.True(t, ok)
+
+	batchSpan, ok := spans["publish batch "+topic]
+	require.True(t, ok)
+
+	assert.False(t, batchSpan.Parent.IsValid(), "batch span should not be a child of any of the callers' spans")
+	require.Len(t, batchSpan.Links, 1)
+	assert.Equal(t, publishSpan.SpanContext.SpanID(), batchSpan.Links[0].SpanContext.SpanID())
+	assert.Contains(t, batchSpan.Attributes, sql.TracingAttributeBatchSize.Int(1))
+}
+
+func TestTracing_disabled(t *testing.T) {
+	t.Parallel()
+
+	db := newSQLite(t)
+	topic := "tracing_disabled_" + watermill.NewShortUUID()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	publisher, err := sql.NewPublisher(db, sql.PublisherConfig{
+		SchemaAdapter:        newSQLiteSchemaAdapter(1),
+		AutoInitializeSchema: true,
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, parentSpan := tracerProvider.Tracer("test").Start(context.Background(), "parent")
+	defer parentSpan.End()
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	msg.SetContext(ctx)
+	require.NoError(t, publisher.Publish(topic, msg))
+
+	assert.Empty(t, msg.Metadata.Get("traceparent"))
+	assert.Empty(t, exporter.GetSpans())
+}
+
+func spansByName(spans tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+
+	return byName
+}